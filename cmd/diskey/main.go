@@ -63,6 +63,10 @@ func runClient(ctx context.Context, serverPort string) {
 
 // This is a test main for now. Eventually it will a CLI tool
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "slowlog" {
+		os.Exit(runSlowLog(context.Background(), os.Args[2:]))
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"diskey/pkg/cluster"
+	"diskey/pkg/rpc"
+)
+
+// runSlowLog prints the slow log of a node, like the Redis SLOWLOG GET command, and returns the exit code.
+//
+//	diskey slowlog -address localhost:8000 -limit 10 -reset -transport grpc
+func runSlowLog(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("slowlog", flag.ContinueOnError)
+	address := flags.String("address", "localhost:8000", "rpc address of the node")
+	limit := flags.Int("limit", 10, "number of entries to print, or 0 for all of them")
+	reset := flags.Bool("reset", false, "clear the slow log after printing it")
+	transportName := flags.String("transport", rpc.TransportFramed.String(), "transport the node serves: framed or grpc")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	transport, err := rpc.ParseTransport(*transportName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	host, port, err := net.SplitHostPort(*address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	client := rpc.NewClient(host, port)
+	client.SetTransport(transport)
+	if connectErr := client.Connect(ctx); connectErr.IsErr() {
+		fmt.Fprintln(os.Stderr, connectErr.Error())
+		return 1
+	}
+	defer client.Disconnect(ctx)
+
+	reply := &cluster.SlowLogReply{}
+	if err := client.Send(ctx, cluster.NewSlowLogRequest(cluster.SlowLogArgs{Limit: *limit, Reset: *reset}, reply)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "ID\tTIME\tDURATION\tCOMMAND\tKEY\tPEER\tBATCH")
+	for _, entry := range reply.Entries {
+		peer := entry.Peer
+		if peer == "" {
+			peer = "local"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%d\n", entry.ID, entry.Time.Format(time.RFC3339Nano), entry.Duration, entry.Command, entry.Key, peer, entry.BatchSize)
+	}
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	return 0
+}
@@ -1,93 +1,200 @@
 package batcher
 
 import (
+	"context"
+	"sync"
 	"time"
 )
 
-func Run[T any](batchSize int, onFlush func(batch []T)) chan<- T {
-	batchChannel := make(chan T, batchSize)
+type options struct {
+	// done stops the batcher once closed. A nil channel never stops it.
+	done        <-chan struct{}
+	onFlushed   func(batchSize int, wait time.Duration)
+	linger      time.Duration
+	maxBytes    int
+	concurrency int
+}
+
+type Option func(batcherOptions *options)
 
-	const numberOfBuffers = 1
-	for range numberOfBuffers {
-		go processBatches(batchChannel, batchSize, onFlush)
+// OptionOnFlushed calls the function after every flush with the size of the batch and how long its first item waited
+// before the flush started. Used to observe how well batching works.
+func OptionOnFlushed(onFlushed func(batchSize int, wait time.Duration)) Option {
+	return func(batcherOptions *options) {
+		batcherOptions.onFlushed = onFlushed
 	}
+}
 
-	return batchChannel
+// OptionContext stops the batcher when the context is done, after flushing the items already batched. Items sent
+// afterwards are never flushed, so senders should stop sending once the context is done.
+func OptionContext(ctx context.Context) Option {
+	return func(batcherOptions *options) {
+		batcherOptions.done = ctx.Done()
+	}
 }
 
-func processBatches[T any](batchChannel <-chan T, batchSize int, onFlush func(batch []T)) {
-	maxFlushInterval := time.Second
+// OptionLinger waits up to the linger after the first item of a batch for more items. Without a linger, which is the
+// default, a batch is flushed as soon as no more items are waiting, so items only batch up while earlier batches are
+// being flushed.
+func OptionLinger(linger time.Duration) Option {
+	return func(batcherOptions *options) {
+		batcherOptions.linger = linger
+	}
+}
+
+// OptionMaxBytes flushes a batch before it grows past maxBytes, counting the Size of items that implement Sizer. An
+// item larger than maxBytes is flushed on its own. Bytes are not limited by default.
+func OptionMaxBytes(maxBytes int) Option {
+	return func(batcherOptions *options) {
+		batcherOptions.maxBytes = maxBytes
+	}
+}
+
+// OptionConcurrency runs up to the given number of flushes at once. Defaults to one, which flushes batches one after
+// the other.
+func OptionConcurrency(concurrency int) Option {
+	return func(batcherOptions *options) {
+		batcherOptions.concurrency = max(concurrency, 1)
+	}
+}
+
+// Sizer is implemented by items that count towards OptionMaxBytes.
+type Sizer interface {
+	Size() int
+}
+
+// Run batches the items sent to the returned channel and calls onFlush with each batch of at most batchSize items.
+// A batch is flushed once it is full, would grow past the max bytes, or its linger runs out. Batches are not reused,
+// so onFlush may keep them. The batcher stops once the channel is closed, after flushing the items sent before.
+func Run[T any](batchSize int, onFlush func(batch []T), batcherOptions ...Option) chan<- T {
+	batchChannel := make(chan T, batchSize)
+
+	runOptions := options{
+		done:        nil,
+		onFlushed:   func(int, time.Duration) {},
+		linger:      0,
+		maxBytes:    0,
+		concurrency: 1,
+	}
+	for index := range batcherOptions {
+		batcherOptions[index](&runOptions)
+	}
+
+	go processBatches(batchChannel, max(batchSize, 1), onFlush, runOptions)
 
-	flushInterval := time.Millisecond
+	return batchChannel
+}
 
-	var length int
-	var item T
-	batchedItems := make([]T, batchSize)
-	ticker := time.NewTicker(flushInterval)
-	running := true
+func processBatches[T any](batchChannel <-chan T, batchSize int, onFlush func(batch []T), runOptions options) {
+	// Holding a slot while collecting lets items pile up in the channel while every slot is flushing, so the next
+	// batch picks them all up at once.
+	slots := make(chan struct{}, runOptions.concurrency)
+	flushing := sync.WaitGroup{}
+	defer flushing.Wait()
+
+	flush := func(batch []T, started time.Time) {
+		wait := time.Since(started)
+		flushing.Add(1)
+		go func() {
+			defer flushing.Done()
+			defer func() { <-slots }()
+
+			onFlush(batch)
+			runOptions.onFlushed(len(batch), wait)
+		}()
+	}
 
-	itemsSinceLastInterval := 0
+	// next is an item that did not fit in the previous batch.
+	var next T
+	hasNext := false
 
 	for {
 		select {
-		// A receive expression used in an assignment statement or initialization of the special form yields
-		// an additional untyped boolean result reporting whether the communication succeeded. The value of
-		// ok is true if the value received was delivered by a successful send operation to the channel, or
-		// false if it is a zero value generated because the channel is closed and empty.
-		case item, running = <-batchChannel:
-			if !running {
-				running = false
-				ticker.Stop()
-				// Break out of the select{} in order to trigger a flush and exit.
-				// Do not add any more items to the queue because it will only be zero valued items.
-				break
-			}
-
-			batchedItems[length] = item
-			length++
-			itemsSinceLastInterval++
+		case slots <- struct{}{}:
+		case <-runOptions.done:
+			return
+		}
 
-			if length != batchSize {
-				// Restart the loop and keep collection batch items.
-				continue
-			}
-		case <-ticker.C:
-			// Dynamically adjust the flush interval to reduce waits and contention.
-			if itemsSinceLastInterval > 0 {
-				batchesSinceLastInternal := float64(itemsSinceLastInterval) / float64(batchSize)
-				// If the max interval is way to large, we can start from the fastest interval and scale up from there.
-				newInterval := time.Nanosecond
-				if batchesSinceLastInternal > 1 {
-					intervalPerBatch := float64(flushInterval.Nanoseconds()) / batchesSinceLastInternal
-					newInterval = time.Duration(int64(intervalPerBatch * float64(time.Nanosecond)))
+		if !hasNext {
+			var open bool
+			select {
+			case next, open = <-batchChannel:
+				if !open {
+					<-slots
+					return
 				}
+			case <-runOptions.done:
+				<-slots
+				return
+			}
+		}
 
-				if newInterval.Nanoseconds() > maxFlushInterval.Nanoseconds() {
-					newInterval = maxFlushInterval
+		started := time.Now()
+		batch := make([]T, 0, batchSize)
+		batch = append(batch, next)
+		bytes := sizeOf(next)
+		hasNext = false
+
+		var timer *time.Timer
+		var linger <-chan time.Time
+		if runOptions.linger > 0 {
+			timer = time.NewTimer(runOptions.linger)
+			linger = timer.C
+		}
+
+		stop := false
+	collect:
+		for len(batch) < batchSize {
+			var item T
+			var open bool
+
+			if linger == nil {
+				select {
+				case item, open = <-batchChannel:
+				default:
+					break collect
 				}
-				if newInterval.Nanoseconds() <= 0 {
-					newInterval = time.Nanosecond
+			} else {
+				select {
+				case item, open = <-batchChannel:
+				case <-linger:
+					break collect
+				case <-runOptions.done:
+					stop = true
+					break collect
 				}
-				// fmt.Println("batches since last interval", batchesSinceLastInternal, "items since last interval", itemsSinceLastInterval, "updating interval", flushInterval.Nanoseconds(), "->", newInterval.Nanoseconds())
-				flushInterval = newInterval
+			}
 
-				itemsSinceLastInterval = 0
+			if !open {
+				stop = true
+				break
+			}
 
-				// Break out of the select{} in order to trigger a flush.
+			itemBytes := sizeOf(item)
+			if runOptions.maxBytes > 0 && bytes+itemBytes > runOptions.maxBytes {
+				next = item
+				hasNext = true
 				break
 			}
-		}
 
-		if length != 0 {
-			onFlush(batchedItems[:length])
-			length = 0
+			batch = append(batch, item)
+			bytes += itemBytes
 		}
 
-		ticker.Reset(flushInterval)
+		if timer != nil {
+			timer.Stop()
+		}
+		flush(batch, started)
 
-		if !running {
-			// Stop the for{} and exit.
-			break
+		if stop {
+			return
 		}
 	}
 }
+
+func sizeOf[T any](item T) int {
+	if sizer, ok := any(item).(Sizer); ok {
+		return sizer.Size()
+	}
+	return 0
+}
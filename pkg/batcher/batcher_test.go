@@ -1,8 +1,13 @@
 package batcher_test
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 
 	"diskey/pkg/batcher"
 )
@@ -26,8 +31,6 @@ func TestRun_concurrent(t *testing.T) {
 
 	close(batchChannel)
 	waitGroup.Wait()
-
-	t.Fail()
 }
 
 func TestRun_sequential(t *testing.T) {
@@ -50,6 +53,168 @@ func TestRun_sequential(t *testing.T) {
 
 	close(batchChannel)
 	waitGroup.Wait()
+}
+
+func TestRun_batchSize(t *testing.T) {
+	t.Parallel()
+
+	waitGroup := &sync.WaitGroup{}
+
+	batchSize := 10
+	batchChannel := batcher.Run(batchSize, func(batch []int) {
+		assert.LessOrEqual(t, len(batch), batchSize)
+		for range batch {
+			waitGroup.Done()
+		}
+	})
+
+	for i := 0; i < batchSize*100; i++ {
+		waitGroup.Add(1)
+		batchChannel <- i
+	}
+
+	close(batchChannel)
+	waitGroup.Wait()
+}
+
+func TestRun_linger(t *testing.T) {
+	t.Parallel()
+
+	batches := make(chan []int, 10)
+	batchChannel := batcher.Run(10, func(batch []int) {
+		batches <- batch
+	}, batcher.OptionLinger(100*time.Millisecond))
+	defer close(batchChannel)
+
+	for i := 0; i < 3; i++ {
+		batchChannel <- i
+	}
+
+	select {
+	case batch := <-batches:
+		assert.Equal(t, []int{0, 1, 2}, batch)
+	case <-time.After(time.Second):
+		assert.Fail(t, "batch not flushed after the linger")
+	}
+
+	for i := 0; i < 10; i++ {
+		batchChannel <- i
+	}
+
+	// A full batch is flushed without waiting for the linger, so it holds all ten items.
+	assert.Len(t, <-batches, 10)
+}
+
+type sizedItem int
+
+func (self sizedItem) Size() int {
+	return int(self)
+}
 
-	t.Fail()
+func TestRun_maxBytes(t *testing.T) {
+	t.Parallel()
+
+	batches := make(chan []sizedItem, 10)
+	batchChannel := batcher.Run(10, func(batch []sizedItem) {
+		batches <- batch
+	}, batcher.OptionLinger(50*time.Millisecond), batcher.OptionMaxBytes(10))
+
+	for _, item := range []sizedItem{4, 4, 4, 20, 1} {
+		batchChannel <- item
+	}
+	close(batchChannel)
+
+	assert.Equal(t, []sizedItem{4, 4}, <-batches)
+	assert.Equal(t, []sizedItem{4}, <-batches)
+	assert.Equal(t, []sizedItem{20}, <-batches)
+	assert.Equal(t, []sizedItem{1}, <-batches)
+}
+
+func TestRun_concurrency(t *testing.T) {
+	t.Parallel()
+
+	var flushing atomic.Int32
+	var maxFlushing atomic.Int32
+	release := make(chan struct{})
+	flushed := sync.WaitGroup{}
+
+	batchChannel := batcher.Run(1, func(batch []int) {
+		current := flushing.Add(1)
+		for {
+			highest := maxFlushing.Load()
+			if current <= highest || maxFlushing.CompareAndSwap(highest, current) {
+				break
+			}
+		}
+
+		<-release
+		flushing.Add(-1)
+		flushed.Done()
+	}, batcher.OptionConcurrency(3))
+
+	flushed.Add(5)
+	go func() {
+		for i := 0; i < 5; i++ {
+			batchChannel <- i
+		}
+		close(batchChannel)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return flushing.Load() == 3
+	}, time.Second, time.Millisecond)
+
+	close(release)
+	flushed.Wait()
+
+	assert.Equal(t, int32(3), maxFlushing.Load())
+}
+
+func TestRun_onFlushed(t *testing.T) {
+	t.Parallel()
+
+	flushedItems := atomic.Int64{}
+	flushed := sync.WaitGroup{}
+	flushed.Add(100)
+
+	batchChannel := batcher.Run(10, func([]int) {}, batcher.OptionOnFlushed(func(batchSize int, wait time.Duration) {
+		assert.LessOrEqual(t, batchSize, 10)
+		assert.GreaterOrEqual(t, wait, time.Duration(0))
+		flushedItems.Add(int64(batchSize))
+		flushed.Add(-batchSize)
+	}))
+
+	for i := 0; i < 100; i++ {
+		batchChannel <- i
+	}
+	close(batchChannel)
+	flushed.Wait()
+
+	assert.Equal(t, int64(100), flushedItems.Load())
+}
+
+func TestRun_context(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	batches := make(chan []int, 10)
+	batchChannel := batcher.Run(10, func(batch []int) {
+		batches <- batch
+	}, batcher.OptionContext(ctx), batcher.OptionLinger(time.Hour))
+
+	batchChannel <- 1
+	batchChannel <- 2
+	assert.Eventually(t, func() bool {
+		return len(batchChannel) == 0
+	}, time.Second, time.Millisecond)
+	cancel()
+
+	// Items batched before the context is done are still flushed.
+	select {
+	case batch := <-batches:
+		assert.Equal(t, []int{1, 2}, batch)
+	case <-time.After(time.Second):
+		assert.Fail(t, "batch not flushed after the context is done")
+	}
 }
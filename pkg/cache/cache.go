@@ -2,10 +2,12 @@ package cache
 
 import (
 	"context"
+	"encoding/binary"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
-	"github.com/vmihailenco/msgpack/v5"
+
+	"diskey/pkg/bytespool"
 )
 
 type Value interface{}
@@ -26,15 +28,20 @@ func Get[T Value](cache Cache, key string) (T, error) {
 }
 
 func UnmarshalValue[T Value](valueBytes []byte, value *T) error {
-	return msgpack.Unmarshal(valueBytes, &value)
+	return UnmarshalValueWith(Encoding{}, valueBytes, value)
 }
 
 func Set[T Value](cache Cache, key string, value T) error {
+	return SetWithTTL(cache, key, value, 0)
+}
+
+// SetWithTTL sets the value and expires it after the ttl. A ttl of zero never expires the value.
+func SetWithTTL[T Value](cache Cache, key string, value T, ttl time.Duration) error {
 	valueBytes, err := MarshalValue(value)
 	if err != nil {
 		return err
 	}
-	return cache.Set(key, valueBytes)
+	return cache.SetWithTTL(key, valueBytes, ttl)
 }
 
 func Delete(cache Cache, key string) error {
@@ -42,11 +49,7 @@ func Delete(cache Cache, key string) error {
 }
 
 func MarshalValue[T Value](value T) ([]byte, error) {
-	valueBytes, err := msgpack.Marshal(value)
-	if err != nil {
-		return nil, err
-	}
-	return valueBytes, nil
+	return MarshalValueWith(Encoding{}, value)
 }
 
 type Config struct {
@@ -96,22 +99,28 @@ func New(ctx context.Context, config Config) (Cache, error) {
 		// Default value is nil which means no callback and it prevents from unwrapping the oldest entry.
 		// Ignored if OnRemove is specified.
 		OnRemoveWithReason: func(key string, entry []byte, reason bigcache.RemoveReason) {
+			expiresAt, value := splitEntry(entry)
+			if reason == bigcache.Deleted && isExpired(expiresAt, time.Now()) {
+				// Expired entries are deleted lazily when they are read.
+				reason = bigcache.Expired
+			}
+
 			switch reason {
 			case bigcache.Expired:
 				if config.OnKeyExpired == nil {
 					return
 				}
-				config.OnKeyExpired(key, entry)
+				config.OnKeyExpired(key, value)
 			case bigcache.NoSpace:
 				if config.OnKeyEvicted == nil {
 					return
 				}
-				config.OnKeyEvicted(key, entry)
+				config.OnKeyEvicted(key, value)
 			case bigcache.Deleted:
 				if config.OnKeyDeleted == nil {
 					return
 				}
-				config.OnKeyDeleted(key, entry)
+				config.OnKeyDeleted(key, value)
 			}
 		},
 	}
@@ -127,13 +136,152 @@ func New(ctx context.Context, config Config) (Cache, error) {
 }
 
 func (self Cache) Set(key string, value []byte) error {
-	return self.cache.Set(key, value)
+	return self.SetWithTTL(key, value, 0)
+}
+
+// SetWithTTL stores the value and expires it after the ttl. A ttl of zero never expires the value.
+//
+// Note that the cache evicts every entry once it is older than the life window, regardless of the ttl.
+func (self Cache) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixNano()
+	}
+
+	entry := bytespool.GetWithCapacity(entryHeaderSize + len(value))
+	entry = binary.BigEndian.AppendUint64(entry, uint64(expiresAt))
+	entry = append(entry, value...)
+
+	// The entry is copied into the cache, so the buffer can go straight back into the pool.
+	err := self.cache.Set(key, entry)
+	bytespool.Put(entry)
+
+	return err
 }
 
 func (self Cache) Get(key string) ([]byte, error) {
-	return self.cache.Get(key)
+	value, _, err := self.GetWithExpiry(key)
+	return value, err
+}
+
+// GetWithExpiry returns the value along with the time it expires. The time is zero if the value never expires.
+func (self Cache) GetWithExpiry(key string) ([]byte, time.Time, error) {
+	entry, err := self.cache.Get(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	expiresAt, value := splitEntry(entry)
+	if isExpired(expiresAt, time.Now()) {
+		// The delete reports the key as expired to the OnKeyExpired callback.
+		_ = self.cache.Delete(key)
+		return nil, time.Time{}, bigcache.ErrEntryNotFound
+	}
+
+	if expiresAt == 0 {
+		return value, time.Time{}, nil
+	}
+
+	return value, time.Unix(0, expiresAt), nil
 }
 
 func (self Cache) Delete(key string) error {
 	return self.cache.Delete(key)
 }
+
+// Close stops the background clean up of expired entries.
+func (self Cache) Close() error {
+	return self.cache.Close()
+}
+
+type Stats struct {
+	Hits         int64
+	Misses       int64
+	DeleteHits   int64
+	DeleteMisses int64
+	// Collisions counts keys that hashed to the same value as a different key.
+	Collisions int64
+}
+
+func (self Cache) Stats() Stats {
+	stats := self.cache.Stats()
+	return Stats{
+		Hits:         stats.Hits,
+		Misses:       stats.Misses,
+		DeleteHits:   stats.DelHits,
+		DeleteMisses: stats.DelMisses,
+		Collisions:   stats.Collisions,
+	}
+}
+
+// Len returns the number of entries, including expired entries that have not been removed yet.
+func (self Cache) Len() int {
+	return self.cache.Len()
+}
+
+// Capacity returns the number of bytes allocated for entries.
+func (self Cache) Capacity() int {
+	return self.cache.Capacity()
+}
+
+// Each entry is prefixed with the unix nano timestamp it expires at, or zero if it never expires.
+const entryHeaderSize = 8
+
+func splitEntry(entry []byte) (int64, []byte) {
+	if len(entry) < entryHeaderSize {
+		return 0, entry
+	}
+	return int64(binary.BigEndian.Uint64(entry)), entry[entryHeaderSize:]
+}
+
+func isExpired(expiresAt int64, now time.Time) bool {
+	return expiresAt != 0 && expiresAt <= now.UnixNano()
+}
+
+// Range calls fn with each unexpired entry until fn returns false. Entries set or deleted while ranging may or may
+// not be visited.
+func (self Cache) Range(fn func(key string, value []byte) bool) {
+	iterator := self.Iterator()
+	for {
+		key, value, ok := iterator.Next()
+		if !ok || !fn(key, value) {
+			return
+		}
+	}
+}
+
+// Iterator walks the unexpired entries of the cache. It may be paused between entries and resumed later, so walking
+// every entry a page at a time costs the same as walking them at once. Entries set or deleted while iterating may or
+// may not be visited.
+type Iterator struct {
+	iterator *bigcache.EntryInfoIterator
+}
+
+// Iterator returns an iterator positioned before the first entry.
+func (self Cache) Iterator() *Iterator {
+	return &Iterator{
+		iterator: self.cache.Iterator(),
+	}
+}
+
+// Next returns the next unexpired entry, or false once every entry was visited.
+func (self *Iterator) Next() (string, []byte, bool) {
+	now := time.Now()
+
+	for self.iterator.SetNext() {
+		entryInfo, err := self.iterator.Value()
+		if err != nil {
+			// The entry was removed after the iterator moved to it.
+			continue
+		}
+
+		expiresAt, value := splitEntry(entryInfo.Value())
+		if isExpired(expiresAt, now) {
+			continue
+		}
+
+		return entryInfo.Key(), value, true
+	}
+
+	return "", nil, false
+}
@@ -1,8 +1,11 @@
 package cache_test
 
 import (
+	"compress/flate"
 	"context"
 	"encoding/gob"
+	"fmt"
+	"strings"
 	"testing"
 
 	"diskey/pkg/cache"
@@ -151,3 +154,94 @@ func Benchmark_golang_gob_Unmarshal(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+// A payload similar to the JSON documents stored in production, around 20KB encoded.
+func benchmarkDocument() Document {
+	var body strings.Builder
+	for index := 0; body.Len() < 20_000; index++ {
+		fmt.Fprintf(&body, `{"id":%d,"name":"item %d","tags":["alpha","beta","gamma"],"active":true},`, index, index)
+	}
+	return Document{
+		Title: "document",
+		Body:  body.String(),
+	}
+}
+
+var compressionBenchmarks = []struct {
+	name     string
+	encoding cache.Encoding
+}{
+	{name: "none", encoding: cache.Encoding{Codec: cache.Msgpack, Compressor: nil, CompressionThreshold: 0, Fingerprint: false}},
+	{name: "flate", encoding: cache.Encoding{Codec: cache.Msgpack, Compressor: cache.Flate, CompressionThreshold: 1024, Fingerprint: false}},
+	{name: "flate_fastest", encoding: cache.Encoding{Codec: cache.Msgpack, Compressor: cache.FlateCompressor{Level: flate.BestSpeed}, CompressionThreshold: 1024, Fingerprint: false}},
+	{name: "gzip", encoding: cache.Encoding{Codec: cache.Msgpack, Compressor: cache.Gzip, CompressionThreshold: 1024, Fingerprint: false}},
+}
+
+// Benchmark_Compression_Set reports the stored bytes per value alongside the time to encode and store it.
+func Benchmark_Compression_Set(b *testing.B) {
+	document := benchmarkDocument()
+
+	for _, benchmark := range compressionBenchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			storage, err := cache.New(context.Background(), cache.Config{})
+			if err != nil {
+				panic(err)
+			}
+			defer storage.Close()
+
+			var storedBytes int
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				valueBytes, err := cache.MarshalValueWith(benchmark.encoding, document)
+				if err != nil {
+					panic(err)
+				}
+				if err := storage.Set("key", valueBytes); err != nil {
+					panic(err)
+				}
+				storedBytes = len(valueBytes)
+			}
+			b.StopTimer()
+
+			b.ReportMetric(float64(storedBytes), "stored-B/value")
+		})
+	}
+}
+
+func Benchmark_Compression_Get(b *testing.B) {
+	document := benchmarkDocument()
+
+	for _, benchmark := range compressionBenchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			storage, err := cache.New(context.Background(), cache.Config{})
+			if err != nil {
+				panic(err)
+			}
+			defer storage.Close()
+
+			valueBytes, err := cache.MarshalValueWith(benchmark.encoding, document)
+			if err != nil {
+				panic(err)
+			}
+			if err := storage.Set("key", valueBytes); err != nil {
+				panic(err)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				valueBytes, err := storage.Get("key")
+				if err != nil {
+					panic(err)
+				}
+				var value Document
+				if err := cache.UnmarshalValueWith(benchmark.encoding, valueBytes, &value); err != nil {
+					panic(err)
+				}
+			}
+			b.StopTimer()
+		})
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"strconv"
 	"testing"
+	"time"
 
 	"diskey/pkg/cache"
 
+	"github.com/allegro/bigcache/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -36,6 +39,75 @@ func Test_Get_Set(t *testing.T) {
 	assert.Equal(t, testValue, actualValue)
 }
 
+func Test_SetWithTTL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var expiredKeys []string
+	cacheConfig := cache.Config{
+		OnKeyExpired: func(key string, entry []byte) {
+			expiredKeys = append(expiredKeys, key)
+		},
+	}
+	storage, err := cache.New(ctx, cacheConfig)
+	assert.NoError(t, err)
+
+	testValue := MyValue{
+		Foo: 10,
+		Bar: "test",
+	}
+
+	assert.NoError(t, cache.SetWithTTL(storage, "expires", testValue, 50*time.Millisecond))
+	assert.NoError(t, cache.SetWithTTL(storage, "forever", testValue, 0))
+
+	actualValue, err := cache.Get[MyValue](storage, "expires")
+	assert.NoError(t, err)
+	assert.Equal(t, testValue, actualValue)
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = cache.Get[MyValue](storage, "expires")
+	assert.ErrorIs(t, err, bigcache.ErrEntryNotFound)
+	assert.Equal(t, []string{"expires"}, expiredKeys)
+
+	actualValue, err = cache.Get[MyValue](storage, "forever")
+	assert.NoError(t, err)
+	assert.Equal(t, testValue, actualValue)
+}
+
+func Test_Iterator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	storage, err := cache.New(ctx, cache.Config{})
+	assert.NoError(t, err)
+
+	expectedKeys := []string{}
+	for index := range 100 {
+		key := "key" + strconv.Itoa(index)
+		expectedKeys = append(expectedKeys, key)
+		assert.NoError(t, storage.Set(key, []byte("value")))
+	}
+	assert.NoError(t, storage.SetWithTTL("expired", []byte("value"), time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	iterator := storage.Iterator()
+	keys := []string{}
+	for {
+		key, value, ok := iterator.Next()
+		if !ok {
+			break
+		}
+		assert.Equal(t, []byte("value"), value)
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, expectedKeys, keys)
+
+	_, _, ok := iterator.Next()
+	assert.False(t, ok)
+}
+
 func Test_vmihailenco_msgpack(t *testing.T) {
 	t.Parallel()
 
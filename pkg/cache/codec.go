@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// CodecID identifies a codec in the header of every value it encodes, so that a value can be decoded no matter which
+// codec the reader is configured with. IDs are part of the stored format and must never be reused.
+type CodecID byte
+
+const (
+	CodecIDMsgpack = CodecID(iota)
+	CodecIDGob
+	CodecIDJSON
+	CodecIDProtobuf
+	CodecIDRaw
+)
+
+func (self CodecID) String() string {
+	switch self {
+	case CodecIDMsgpack:
+		return "msgpack"
+	case CodecIDGob:
+		return "gob"
+	case CodecIDJSON:
+		return "json"
+	case CodecIDProtobuf:
+		return "protobuf"
+	case CodecIDRaw:
+		return "raw"
+	default:
+		return fmt.Sprintf("CodecID(%d)", byte(self))
+	}
+}
+
+// Codec encodes values before they are stored or sent to other nodes.
+type Codec interface {
+	ID() CodecID
+	Marshal(value any) ([]byte, error)
+	// Unmarshal decodes data into the value, which is always a pointer.
+	Unmarshal(data []byte, value any) error
+}
+
+var (
+	Msgpack  Codec = MsgpackCodec{}
+	Gob      Codec = GobCodec{}
+	JSON     Codec = JSONCodec{}
+	Protobuf Codec = ProtobufCodec{}
+	Raw      Codec = RawCodec{}
+)
+
+// codecs holds every codec by id for decoding values written with a different codec than the reader's.
+var codecs = map[CodecID]Codec{
+	CodecIDMsgpack:  Msgpack,
+	CodecIDGob:      Gob,
+	CodecIDJSON:     JSON,
+	CodecIDProtobuf: Protobuf,
+	CodecIDRaw:      Raw,
+}
+
+func codecByID(codecID CodecID) (Codec, error) {
+	codec, exists := codecs[codecID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, codecID)
+	}
+	return codec, nil
+}
+
+// MsgpackCodec is the default codec.
+type MsgpackCodec struct{}
+
+func (self MsgpackCodec) ID() CodecID {
+	return CodecIDMsgpack
+}
+
+func (self MsgpackCodec) Marshal(value any) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (self MsgpackCodec) Unmarshal(data []byte, value any) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+// GobCodec encodes values with encoding/gob. Every value carries its own type description, so values are larger than
+// with msgpack.
+type GobCodec struct{}
+
+func (self GobCodec) ID() CodecID {
+	return CodecIDGob
+}
+
+func (self GobCodec) Marshal(value any) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (self GobCodec) Unmarshal(data []byte, value any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+type JSONCodec struct{}
+
+func (self JSONCodec) ID() CodecID {
+	return CodecIDJSON
+}
+
+func (self JSONCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (self JSONCodec) Unmarshal(data []byte, value any) error {
+	return json.Unmarshal(data, value)
+}
+
+// ProtobufCodec encodes values that implement proto.Message. Values are typically pointers to generated messages, in
+// which case a nil pointer is allocated when decoding.
+type ProtobufCodec struct{}
+
+func (self ProtobufCodec) ID() CodecID {
+	return CodecIDProtobuf
+}
+
+func (self ProtobufCodec) Marshal(value any) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("protobuf codec: %T does not implement proto.Message", value)
+	}
+	return proto.Marshal(message)
+}
+
+func (self ProtobufCodec) Unmarshal(data []byte, value any) error {
+	if message, ok := value.(proto.Message); ok {
+		return proto.Unmarshal(data, message)
+	}
+
+	// Decoding into a *T where T is a pointer to a message.
+	pointer := reflect.ValueOf(value)
+	if pointer.Kind() != reflect.Pointer || pointer.IsNil() || pointer.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("protobuf codec: %T does not point to a proto.Message", value)
+	}
+
+	element := pointer.Elem()
+	if element.IsNil() {
+		element.Set(reflect.New(element.Type().Elem()))
+	}
+
+	message, ok := element.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("protobuf codec: %T does not point to a proto.Message", value)
+	}
+	return proto.Unmarshal(data, message)
+}
+
+// RawCodec stores []byte values as they are.
+type RawCodec struct{}
+
+func (self RawCodec) ID() CodecID {
+	return CodecIDRaw
+}
+
+func (self RawCodec) Marshal(value any) ([]byte, error) {
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec: %T is not []byte", value)
+	}
+	return data, nil
+}
+
+func (self RawCodec) Unmarshal(data []byte, value any) error {
+	target, ok := value.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec: %T is not *[]byte", value)
+	}
+	// The data may point into a reused buffer.
+	*target = bytes.Clone(data)
+	return nil
+}
@@ -0,0 +1,92 @@
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"diskey/pkg/cache"
+)
+
+func Test_Codecs(t *testing.T) {
+	t.Parallel()
+
+	testValue := MyValue{
+		Foo: 10,
+		Bar: "test",
+	}
+
+	for _, codec := range []cache.Codec{cache.Msgpack, cache.Gob, cache.JSON} {
+		t.Run(codec.ID().String(), func(t *testing.T) {
+			t.Parallel()
+
+			valueBytes, err := cache.MarshalValueWith(cache.Encoding{Codec: codec, Fingerprint: true}, testValue)
+			assert.NoError(t, err)
+
+			// The codec is read from the value header, not from the encoding.
+			var value MyValue
+			assert.NoError(t, cache.UnmarshalValueWith(cache.Encoding{Codec: cache.Msgpack, Fingerprint: false}, valueBytes, &value))
+			assert.Equal(t, testValue, value)
+
+			var other struct{ Foo int }
+			assert.ErrorIs(t, cache.UnmarshalValue(valueBytes, &other), cache.ErrTypeMismatch)
+		})
+	}
+}
+
+func Test_Codecs_protobuf(t *testing.T) {
+	t.Parallel()
+
+	valueBytes, err := cache.MarshalValueWith(cache.Encoding{Codec: cache.Protobuf, Fingerprint: false}, wrapperspb.String("test"))
+	assert.NoError(t, err)
+
+	var value *wrapperspb.StringValue
+	assert.NoError(t, cache.UnmarshalValue(valueBytes, &value))
+	assert.Equal(t, "test", value.GetValue())
+
+	_, err = cache.MarshalValueWith(cache.Encoding{Codec: cache.Protobuf, Fingerprint: false}, MyValue{})
+	assert.Error(t, err)
+}
+
+func Test_Codecs_raw(t *testing.T) {
+	t.Parallel()
+
+	valueBytes, err := cache.MarshalValueWith(cache.Encoding{Codec: cache.Raw, Fingerprint: false}, []byte("test"))
+	assert.NoError(t, err)
+
+	var value []byte
+	assert.NoError(t, cache.UnmarshalValue(valueBytes, &value))
+	assert.Equal(t, []byte("test"), value)
+
+	// Values written before codecs were recorded are msgpack.
+	valueBytes, err = cache.MarshalValue(MyValue{Foo: 1, Bar: ""})
+	assert.NoError(t, err)
+	var myValue MyValue
+	assert.NoError(t, cache.UnmarshalValueWith(cache.Encoding{Codec: cache.Raw, Fingerprint: false}, valueBytes, &myValue))
+	assert.Equal(t, 1, myValue.Foo)
+}
+
+func Test_Version(t *testing.T) {
+	t.Parallel()
+
+	type MyValueV1 struct {
+		Foo int
+	}
+
+	valueBytes, err := cache.MarshalValueWith(cache.Encoding{Fingerprint: true, Version: 1}, MyValueV1{Foo: 1})
+	assert.NoError(t, err)
+
+	version, err := cache.ValueVersion(valueBytes)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), version)
+
+	var value MyValue
+	assert.ErrorIs(t, cache.UnmarshalValueWith(cache.Encoding{Fingerprint: true, Version: 2}, valueBytes, &value), cache.ErrVersionMismatch)
+	assert.ErrorIs(t, cache.UnmarshalValue(valueBytes, &value), cache.ErrVersionMismatch)
+
+	// Decoding at the version the value was written at ignores the type it was written as.
+	assert.ErrorIs(t, cache.UnmarshalValueWith(cache.Encoding{Fingerprint: true, Version: 1}, valueBytes, &value), cache.ErrTypeMismatch)
+	assert.NoError(t, cache.UnmarshalVersionWith(cache.Encoding{Fingerprint: true, Version: 1}, "", valueBytes, &value))
+	assert.Equal(t, 1, value.Foo)
+}
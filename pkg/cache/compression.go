@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrUnknownCompressor = errors.New("unknown compressor")
+	ErrValueTooLarge     = errors.New("decompressed value too large")
+)
+
+// DefaultMaxDecompressedSize bounds how large a value may grow when it is decompressed, unless the encoding sets
+// another maximum.
+const DefaultMaxDecompressedSize = 64 << 20
+
+// CompressorID identifies a compressor in the header of every value it compressed. IDs are part of the stored format
+// and must never be reused.
+type CompressorID byte
+
+const (
+	CompressorIDFlate = CompressorID(iota + 1)
+	CompressorIDGzip
+)
+
+func (self CompressorID) String() string {
+	switch self {
+	case CompressorIDFlate:
+		return "flate"
+	case CompressorIDGzip:
+		return "gzip"
+	default:
+		return fmt.Sprintf("CompressorID(%d)", byte(self))
+	}
+}
+
+// Compressor compresses encoded values before they are stored or sent to other nodes.
+type Compressor interface {
+	ID() CompressorID
+	Compress(data []byte) ([]byte, error)
+	// Decompress fails with ErrValueTooLarge once the data decompresses to more than maxSize bytes, so that a small
+	// value cannot expand to exhaust memory.
+	Decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+var (
+	Flate Compressor = FlateCompressor{Level: flate.DefaultCompression}
+	Gzip  Compressor = GzipCompressor{Level: gzip.DefaultCompression}
+)
+
+// compressors holds every compressor by id for decompressing values compressed by another node.
+var compressors = map[CompressorID]Compressor{
+	CompressorIDFlate: Flate,
+	CompressorIDGzip:  Gzip,
+}
+
+// RegisterCompressor makes values compressed by the compressor readable. Compressors other than Flate and Gzip must be
+// registered on every node before values compressed by them are read. Intended to be called from init.
+func RegisterCompressor(compressor Compressor) {
+	compressors[compressor.ID()] = compressor
+}
+
+func compressorByID(compressorID CompressorID) (Compressor, error) {
+	compressor, exists := compressors[compressorID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompressor, compressorID)
+	}
+	return compressor, nil
+}
+
+// Writers are pooled by level, since each one allocates several hundred KB.
+var (
+	flateWriterPools sync.Map
+	gzipWriterPools  sync.Map
+)
+
+// writerPool returns the pool of writers at the level. Pools hold the error instead of a writer if the level is
+// invalid.
+func writerPool(pools *sync.Map, level int, newWriter func(level int) (io.WriteCloser, error)) *sync.Pool {
+	pool, _ := pools.LoadOrStore(level, &sync.Pool{
+		New: func() any {
+			writer, err := newWriter(level)
+			if err != nil {
+				return err
+			}
+			return writer
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// FlateCompressor compresses with DEFLATE at one of the compress/flate levels.
+type FlateCompressor struct {
+	Level int
+}
+
+func (self FlateCompressor) ID() CompressorID {
+	return CompressorIDFlate
+}
+
+func (self FlateCompressor) Compress(data []byte) ([]byte, error) {
+	pool := writerPool(&flateWriterPools, self.Level, func(level int) (io.WriteCloser, error) {
+		return flate.NewWriter(io.Discard, level)
+	})
+
+	pooled := pool.Get()
+	writer, ok := pooled.(*flate.Writer)
+	if !ok {
+		return nil, pooled.(error)
+	}
+	defer pool.Put(writer)
+
+	var buffer bytes.Buffer
+	writer.Reset(&buffer)
+	return compress(writer, &buffer, data)
+}
+
+func (self FlateCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+
+	return readAllLimited(reader, maxSize)
+}
+
+// GzipCompressor compresses with gzip at one of the compress/gzip levels. It is slightly larger than flate because of
+// the gzip header and checksum.
+type GzipCompressor struct {
+	Level int
+}
+
+func (self GzipCompressor) ID() CompressorID {
+	return CompressorIDGzip
+}
+
+func (self GzipCompressor) Compress(data []byte) ([]byte, error) {
+	pool := writerPool(&gzipWriterPools, self.Level, func(level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(io.Discard, level)
+	})
+
+	pooled := pool.Get()
+	writer, ok := pooled.(*gzip.Writer)
+	if !ok {
+		return nil, pooled.(error)
+	}
+	defer pool.Put(writer)
+
+	var buffer bytes.Buffer
+	writer.Reset(&buffer)
+	return compress(writer, &buffer, data)
+}
+
+func (self GzipCompressor) Decompress(data []byte, maxSize int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return readAllLimited(reader, maxSize)
+}
+
+// readAllLimited reads everything from the reader, failing with ErrValueTooLarge once more than maxSize bytes are read.
+func readAllLimited(reader io.Reader, maxSize int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrValueTooLarge, maxSize)
+	}
+	return data, nil
+}
+
+func compress(writer io.WriteCloser, buffer *bytes.Buffer, data []byte) ([]byte, error) {
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
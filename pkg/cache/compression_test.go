@@ -0,0 +1,114 @@
+package cache_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/cache"
+)
+
+type Document struct {
+	Title string
+	Body  string
+}
+
+func Test_Compression(t *testing.T) {
+	t.Parallel()
+
+	large := Document{
+		Title: "large",
+		Body:  strings.Repeat("compressible text ", 1000),
+	}
+	small := Document{
+		Title: "small",
+		Body:  "",
+	}
+
+	for _, compressor := range []cache.Compressor{cache.Flate, cache.Gzip} {
+		t.Run(compressor.ID().String(), func(t *testing.T) {
+			t.Parallel()
+
+			encoding := cache.Encoding{
+				Codec:                cache.Msgpack,
+				Compressor:           compressor,
+				CompressionThreshold: 1024,
+				Fingerprint:          false,
+			}
+
+			uncompressed, err := cache.MarshalValue(large)
+			assert.NoError(t, err)
+
+			valueBytes, err := cache.MarshalValueWith(encoding, large)
+			assert.NoError(t, err)
+			assert.Less(t, len(valueBytes), len(uncompressed)/10)
+
+			// Readers decompress regardless of their own encoding.
+			var value Document
+			assert.NoError(t, cache.UnmarshalValue(valueBytes, &value))
+			assert.Equal(t, large, value)
+
+			// Values below the threshold are stored as they are.
+			uncompressed, err = cache.MarshalValue(small)
+			assert.NoError(t, err)
+			valueBytes, err = cache.MarshalValueWith(encoding, small)
+			assert.NoError(t, err)
+			assert.Equal(t, uncompressed, valueBytes)
+		})
+	}
+}
+
+type unregisteredCompressor struct {
+	cache.FlateCompressor
+}
+
+func (self unregisteredCompressor) ID() cache.CompressorID {
+	return 200
+}
+
+func Test_Compression_unknown_compressor(t *testing.T) {
+	t.Parallel()
+
+	encoding := cache.Encoding{
+		Codec:                cache.Msgpack,
+		Compressor:           unregisteredCompressor{cache.FlateCompressor{Level: 1}},
+		CompressionThreshold: 0,
+		Fingerprint:          false,
+	}
+
+	valueBytes, err := cache.MarshalValueWith(encoding, strings.Repeat("a", 1000))
+	assert.NoError(t, err)
+
+	var value string
+	assert.ErrorIs(t, cache.UnmarshalValue(valueBytes, &value), cache.ErrUnknownCompressor)
+}
+
+func Test_Compression_max_decompressed_size(t *testing.T) {
+	t.Parallel()
+
+	for _, compressor := range []cache.Compressor{cache.Flate, cache.Gzip} {
+		t.Run(compressor.ID().String(), func(t *testing.T) {
+			t.Parallel()
+
+			encoding := cache.Encoding{
+				Codec:                cache.Msgpack,
+				Compressor:           compressor,
+				CompressionThreshold: 0,
+				MaxDecompressedSize:  1024,
+				Fingerprint:          false,
+			}
+
+			valueBytes, err := cache.MarshalValueWith(encoding, strings.Repeat("a", 1000))
+			assert.NoError(t, err)
+			var value string
+			assert.NoError(t, cache.UnmarshalValueWith(encoding, valueBytes, &value))
+
+			// A value that compresses well still fails to read once it decompresses past the maximum.
+			valueBytes, err = cache.MarshalValueWith(encoding, strings.Repeat("a", 2000))
+			assert.NoError(t, err)
+			assert.Less(t, len(valueBytes), 100)
+			assert.ErrorIs(t, cache.UnmarshalValueWith(encoding, valueBytes, &value), cache.ErrValueTooLarge)
+		})
+	}
+}
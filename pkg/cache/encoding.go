@@ -0,0 +1,328 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+)
+
+var (
+	ErrTypeMismatch    = errors.New("type mismatch")
+	ErrVersionMismatch = errors.New("schema version mismatch")
+)
+
+// Encoding controls how values are encoded before they are stored or sent to other nodes.
+type Encoding struct {
+	// Codec encodes the values. Defaults to Msgpack. Values are decoded with the codec recorded in their header, so
+	// values written with any codec can be read with any other.
+	Codec Codec
+	// Compressor compresses encoded values of at least CompressionThreshold bytes. Values that do not get smaller are
+	// stored uncompressed. Nil turns compression off, which is the default.
+	Compressor           Compressor
+	CompressionThreshold int
+	// MaxDecompressedSize bounds how large a compressed value may grow when it is read. Larger values fail with
+	// ErrValueTooLarge. Zero uses DefaultMaxDecompressedSize.
+	MaxDecompressedSize int
+	// Keyring encrypts values with AES-GCM. Only encodings holding the key a value was encrypted with can read it back,
+	// and values that are not encrypted are rejected. Values are compressed before they are encrypted. Nil leaves
+	// values in plaintext, which is the default.
+	Keyring *Keyring
+	// Version is the schema version of the values. Reading a value written at any other version fails with
+	// ErrVersionMismatch, so that values of an old or new shape are never decoded into zero fields. Zero is the version
+	// of values written without one.
+	Version uint32
+	// Fingerprint stores a fingerprint of the Go type with each value. Reading the value back into any other type
+	// fails with ErrTypeMismatch instead of silently decoding into a zero value.
+	Fingerprint bool
+}
+
+// Every encoded value starts with a header:
+//
+//	byte 0: headerMagic
+//	byte 1: header flags
+//	[8 bytes: type fingerprint, when headerFlagFingerprint is set]
+//	[1 byte: codec id, when headerFlagCodec is set]
+//	[1 byte: compressor id, when headerFlagCompressed is set]
+//	[4 bytes: schema version, when headerFlagVersion is set]
+//	[4 bytes: key id, 12 bytes: nonce, when headerFlagEncrypted is set]
+//
+// The magic byte is one msgpack never uses, so values without a header are still decoded as plain msgpack. Values
+// without a codec id were written with msgpack.
+const (
+	headerMagic      = 0xc1
+	headerFixedSize  = 2
+	fingerprintSize  = 8
+	codecIDSize      = 1
+	compressorIDSize = 1
+	versionSize      = 4
+	keyIDSize        = 4
+	nonceSize        = 12
+)
+
+type headerFlag byte
+
+const (
+	headerFlagFingerprint headerFlag = 1 << iota
+	headerFlagCodec
+	headerFlagCompressed
+	headerFlagVersion
+	// The payload is sealed with the whole header and the cache key as additional data, so neither can be changed.
+	headerFlagEncrypted
+)
+
+type header struct {
+	flags        headerFlag
+	fingerprint  uint64
+	codecID      CodecID
+	compressorID CompressorID
+	version      uint32
+	keyID        KeyID
+	nonce        [nonceSize]byte
+}
+
+func (self header) size() int {
+	size := headerFixedSize
+	if self.flags&headerFlagFingerprint != 0 {
+		size += fingerprintSize
+	}
+	if self.flags&headerFlagCodec != 0 {
+		size += codecIDSize
+	}
+	if self.flags&headerFlagCompressed != 0 {
+		size += compressorIDSize
+	}
+	if self.flags&headerFlagVersion != 0 {
+		size += versionSize
+	}
+	if self.flags&headerFlagEncrypted != 0 {
+		size += keyIDSize + nonceSize
+	}
+	return size
+}
+
+func (self header) appendTo(valueBytes []byte) []byte {
+	valueBytes = append(valueBytes, headerMagic, byte(self.flags))
+	if self.flags&headerFlagFingerprint != 0 {
+		valueBytes = binary.BigEndian.AppendUint64(valueBytes, self.fingerprint)
+	}
+	if self.flags&headerFlagCodec != 0 {
+		valueBytes = append(valueBytes, byte(self.codecID))
+	}
+	if self.flags&headerFlagCompressed != 0 {
+		valueBytes = append(valueBytes, byte(self.compressorID))
+	}
+	if self.flags&headerFlagVersion != 0 {
+		valueBytes = binary.BigEndian.AppendUint32(valueBytes, self.version)
+	}
+	if self.flags&headerFlagEncrypted != 0 {
+		valueBytes = binary.BigEndian.AppendUint32(valueBytes, uint32(self.keyID))
+		valueBytes = append(valueBytes, self.nonce[:]...)
+	}
+	return valueBytes
+}
+
+// splitHeader returns the header and the payload that follows it.
+func splitHeader(valueBytes []byte) (header, []byte, error) {
+	if len(valueBytes) < headerFixedSize || valueBytes[0] != headerMagic {
+		return header{}, valueBytes, nil
+	}
+
+	valueHeader := header{
+		flags: headerFlag(valueBytes[1]),
+	}
+	if len(valueBytes) < valueHeader.size() {
+		return header{}, nil, fmt.Errorf("value header truncated: %d bytes", len(valueBytes))
+	}
+
+	offset := headerFixedSize
+	if valueHeader.flags&headerFlagFingerprint != 0 {
+		valueHeader.fingerprint = binary.BigEndian.Uint64(valueBytes[offset:])
+		offset += fingerprintSize
+	}
+	if valueHeader.flags&headerFlagCodec != 0 {
+		valueHeader.codecID = CodecID(valueBytes[offset])
+		offset += codecIDSize
+	}
+	if valueHeader.flags&headerFlagCompressed != 0 {
+		valueHeader.compressorID = CompressorID(valueBytes[offset])
+		offset += compressorIDSize
+	}
+	if valueHeader.flags&headerFlagVersion != 0 {
+		valueHeader.version = binary.BigEndian.Uint32(valueBytes[offset:])
+		offset += versionSize
+	}
+	if valueHeader.flags&headerFlagEncrypted != 0 {
+		valueHeader.keyID = KeyID(binary.BigEndian.Uint32(valueBytes[offset:]))
+		offset += keyIDSize
+		copy(valueHeader.nonce[:], valueBytes[offset:])
+		offset += nonceSize
+	}
+
+	return valueHeader, valueBytes[offset:], nil
+}
+
+// Fingerprint identifies the Go type T. It is stable across processes built from the same source.
+func Fingerprint[T Value]() uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(typeName[T]()))
+	return hash.Sum64()
+}
+
+func typeName[T Value]() string {
+	valueType := reflect.TypeFor[T]()
+	if valueType.Name() != "" && valueType.PkgPath() != "" {
+		return valueType.PkgPath() + "." + valueType.Name()
+	}
+	return valueType.String()
+}
+
+func MarshalValueWith[T Value](encoding Encoding, value T) ([]byte, error) {
+	return MarshalValueFor(encoding, "", value)
+}
+
+// MarshalValueFor encodes a value stored under the key. Encrypted values are bound to the key, so they can only be
+// read back by UnmarshalValueFor with the same key.
+func MarshalValueFor[T Value](encoding Encoding, key string, value T) ([]byte, error) {
+	codec := encoding.Codec
+	if codec == nil {
+		codec = Msgpack
+	}
+
+	valueHeader := header{}
+	if encoding.Fingerprint {
+		valueHeader.flags |= headerFlagFingerprint
+		valueHeader.fingerprint = Fingerprint[T]()
+	}
+	if codec.ID() != CodecIDMsgpack {
+		valueHeader.flags |= headerFlagCodec
+		valueHeader.codecID = codec.ID()
+	}
+	if encoding.Version != 0 {
+		valueHeader.flags |= headerFlagVersion
+		valueHeader.version = encoding.Version
+	}
+
+	payload, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if encoding.Compressor != nil && len(payload) >= encoding.CompressionThreshold {
+		compressed, err := encoding.Compressor.Compress(payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(payload) {
+			valueHeader.flags |= headerFlagCompressed
+			valueHeader.compressorID = encoding.Compressor.ID()
+			payload = compressed
+		}
+	}
+
+	if encoding.Keyring == nil {
+		valueBytes := make([]byte, 0, valueHeader.size()+len(payload))
+		valueBytes = valueHeader.appendTo(valueBytes)
+		valueBytes = append(valueBytes, payload...)
+
+		return valueBytes, nil
+	}
+
+	keyID, aead, err := encoding.Keyring.primaryKey()
+	if err != nil {
+		return nil, err
+	}
+	valueHeader.flags |= headerFlagEncrypted
+	valueHeader.keyID = keyID
+	if valueHeader.nonce, err = newNonce(); err != nil {
+		return nil, err
+	}
+
+	headerSize := valueHeader.size()
+	valueBytes := make([]byte, 0, headerSize+len(payload)+aead.Overhead())
+	valueBytes = valueHeader.appendTo(valueBytes)
+	valueBytes = aead.Seal(valueBytes, valueHeader.nonce[:], payload, associatedData(valueBytes[:headerSize], key))
+
+	return valueBytes, nil
+}
+
+// UnmarshalValueWith decodes a value written by MarshalValueWith with the codec and compressor recorded in its header.
+// Values carrying a type fingerprint are always checked against T, regardless of the encoding they are read with.
+// Encrypted values are decrypted with the key named in the header, which must be in the keyring of the encoding.
+func UnmarshalValueWith[T Value](encoding Encoding, valueBytes []byte, value *T) error {
+	return unmarshalValue(encoding, "", valueBytes, value, true)
+}
+
+// UnmarshalValueFor decodes a value written by MarshalValueFor under the key. Encrypted values stored under any other
+// key fail to decrypt.
+func UnmarshalValueFor[T Value](encoding Encoding, key string, valueBytes []byte, value *T) error {
+	return unmarshalValue(encoding, key, valueBytes, value, true)
+}
+
+// UnmarshalVersionWith decodes a value stored under the key and written at the schema version of the encoding into T,
+// without checking the type it was written as. It is used to decode values written by an older build into a type
+// mirroring their old shape.
+func UnmarshalVersionWith[T Value](encoding Encoding, key string, valueBytes []byte, value *T) error {
+	return unmarshalValue(encoding, key, valueBytes, value, false)
+}
+
+// ValueVersion returns the schema version the value was written at. The header is not authenticated until the value
+// is decoded.
+func ValueVersion(valueBytes []byte) (uint32, error) {
+	valueHeader, _, err := splitHeader(valueBytes)
+	return valueHeader.version, err
+}
+
+func unmarshalValue[T Value](encoding Encoding, key string, valueBytes []byte, value *T, checkFingerprint bool) error {
+	valueHeader, payload, err := splitHeader(valueBytes)
+	if err != nil {
+		return err
+	}
+
+	if encoding.Keyring != nil && valueHeader.flags&headerFlagEncrypted == 0 {
+		// Anyone able to write the key could otherwise replace a secret with a value of their choosing.
+		return ErrNotEncrypted
+	}
+
+	if valueHeader.flags&headerFlagEncrypted != 0 {
+		aead, err := encoding.Keyring.key(valueHeader.keyID)
+		if err != nil {
+			return err
+		}
+		headerSize := len(valueBytes) - len(payload)
+		// Decrypted into a new buffer since the value may be shared with the key store.
+		if payload, err = aead.Open(nil, valueHeader.nonce[:], payload, associatedData(valueBytes[:headerSize], key)); err != nil {
+			return err
+		}
+	}
+
+	if valueHeader.version != encoding.Version {
+		return fmt.Errorf("%w: value was written at version %d, expected %d", ErrVersionMismatch, valueHeader.version, encoding.Version)
+	}
+
+	if checkFingerprint && valueHeader.flags&headerFlagFingerprint != 0 && valueHeader.fingerprint != Fingerprint[T]() {
+		return fmt.Errorf("%w: value was not written as %s", ErrTypeMismatch, typeName[T]())
+	}
+
+	if valueHeader.flags&headerFlagCompressed != 0 {
+		compressor, err := compressorByID(valueHeader.compressorID)
+		if err != nil {
+			return err
+		}
+		maxSize := encoding.MaxDecompressedSize
+		if maxSize <= 0 {
+			maxSize = DefaultMaxDecompressedSize
+		}
+		if payload, err = compressor.Decompress(payload, maxSize); err != nil {
+			return err
+		}
+	}
+
+	codec, err := codecByID(valueHeader.codecID)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(payload, value)
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown encryption key")
+	ErrNotEncrypted = errors.New("value is not encrypted")
+)
+
+// associatedData is what the authentication tag of an encrypted value covers besides its payload: the header, so
+// that no flag or id can be changed, and the cache key, so that a value cannot be copied under another key.
+func associatedData(valueHeader []byte, key string) []byte {
+	associated := make([]byte, 0, len(valueHeader)+len(key))
+	associated = append(associated, valueHeader...)
+	return append(associated, key...)
+}
+
+// KeyID identifies the key a value was encrypted with. It is stored in the value header.
+type KeyID uint32
+
+// Keyring holds the AES-GCM keys of a namespace. Values are encrypted with the primary key and decrypted with
+// whichever key they name, so keys can be rotated by adding a new primary and removing the old key once every value
+// encrypted with it has been rewritten or has expired.
+type Keyring struct {
+	keys    map[KeyID]cipher.AEAD
+	primary KeyID
+	mutex   sync.RWMutex
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys:    map[KeyID]cipher.AEAD{},
+		primary: 0,
+		mutex:   sync.RWMutex{},
+	}
+}
+
+// Add adds a key of 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256. The first key added becomes the primary
+// key.
+func (self *Keyring) Add(keyID KeyID, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if len(self.keys) == 0 {
+		self.primary = keyID
+	}
+	self.keys[keyID] = aead
+
+	return nil
+}
+
+// SetPrimary encrypts values written from now on with the key.
+func (self *Keyring) SetPrimary(keyID KeyID) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if _, exists := self.keys[keyID]; !exists {
+		return fmt.Errorf("%w: %d", ErrUnknownKey, keyID)
+	}
+	self.primary = keyID
+
+	return nil
+}
+
+// Remove removes a key. Values encrypted with it can no longer be read. The primary key cannot be removed.
+func (self *Keyring) Remove(keyID KeyID) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if keyID == self.primary {
+		return fmt.Errorf("key %d is the primary key", keyID)
+	}
+	delete(self.keys, keyID)
+
+	return nil
+}
+
+func (self *Keyring) primaryKey() (KeyID, cipher.AEAD, error) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	aead, exists := self.keys[self.primary]
+	if !exists {
+		return 0, nil, fmt.Errorf("%w: keyring is empty", ErrUnknownKey)
+	}
+	return self.primary, aead, nil
+}
+
+func (self *Keyring) key(keyID KeyID) (cipher.AEAD, error) {
+	if self == nil {
+		return nil, fmt.Errorf("%w: %d, no keyring", ErrUnknownKey, keyID)
+	}
+
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	aead, exists := self.keys[keyID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, keyID)
+	}
+	return aead, nil
+}
+
+// newNonce returns a random nonce. Random nonces are safe for about 2^32 values per key, so rotate keys well before
+// that.
+func newNonce() ([nonceSize]byte, error) {
+	var nonce [nonceSize]byte
+	_, err := rand.Read(nonce[:])
+	return nonce, err
+}
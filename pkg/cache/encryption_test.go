@@ -0,0 +1,92 @@
+package cache_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/cache"
+)
+
+func Test_Encryption(t *testing.T) {
+	t.Parallel()
+
+	keyring := cache.NewKeyring()
+	assert.NoError(t, keyring.Add(1, bytes.Repeat([]byte{1}, 32)))
+
+	encoding := cache.Encoding{
+		Codec:                cache.Msgpack,
+		Compressor:           nil,
+		CompressionThreshold: 0,
+		Keyring:              keyring,
+		Fingerprint:          true,
+	}
+
+	testValue := MyValue{
+		Foo: 10,
+		Bar: "secret",
+	}
+
+	valueBytes, err := cache.MarshalValueWith(encoding, testValue)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(valueBytes), "secret")
+
+	var value MyValue
+	assert.NoError(t, cache.UnmarshalValueWith(encoding, valueBytes, &value))
+	assert.Equal(t, testValue, value)
+
+	// Values cannot be read without the key.
+	assert.ErrorIs(t, cache.UnmarshalValue(valueBytes, &value), cache.ErrUnknownKey)
+
+	// Rotating keeps values encrypted with the old key readable until it is removed.
+	assert.NoError(t, keyring.Add(2, bytes.Repeat([]byte{2}, 16)))
+	assert.NoError(t, keyring.SetPrimary(2))
+	rotatedBytes, err := cache.MarshalValueWith(encoding, testValue)
+	assert.NoError(t, err)
+
+	assert.NoError(t, cache.UnmarshalValueWith(encoding, valueBytes, &value))
+	assert.NoError(t, keyring.Remove(1))
+	assert.ErrorIs(t, cache.UnmarshalValueWith(encoding, valueBytes, &value), cache.ErrUnknownKey)
+	assert.NoError(t, cache.UnmarshalValueWith(encoding, rotatedBytes, &value))
+	assert.Equal(t, testValue, value)
+	assert.Error(t, keyring.Remove(2))
+
+	// The header is authenticated along with the payload.
+	tampered := bytes.Clone(rotatedBytes)
+	tampered[1] &^= 1 // Clear the fingerprint flag.
+	assert.Error(t, cache.UnmarshalValueWith(encoding, tampered, &value))
+	tampered = bytes.Clone(rotatedBytes)
+	tampered[len(tampered)-1] ^= 1
+	assert.Error(t, cache.UnmarshalValueWith(encoding, tampered, &value))
+}
+
+func Test_Encryption_key(t *testing.T) {
+	t.Parallel()
+
+	keyring := cache.NewKeyring()
+	assert.NoError(t, keyring.Add(1, bytes.Repeat([]byte{1}, 32)))
+
+	encoding := cache.Encoding{
+		Codec:                cache.Msgpack,
+		Compressor:           nil,
+		CompressionThreshold: 0,
+		Keyring:              keyring,
+		Fingerprint:          false,
+	}
+
+	valueBytes, err := cache.MarshalValueFor(encoding, "user:1", "secret")
+	assert.NoError(t, err)
+
+	var value string
+	assert.NoError(t, cache.UnmarshalValueFor(encoding, "user:1", valueBytes, &value))
+	assert.Equal(t, "secret", value)
+
+	// Encrypted values are bound to their key, so they cannot be copied under another one.
+	assert.Error(t, cache.UnmarshalValueFor(encoding, "user:2", valueBytes, &value))
+
+	// Values that are not encrypted are rejected, so that they cannot replace encrypted ones.
+	plaintext, err := cache.MarshalValue("forged")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, cache.UnmarshalValueFor(encoding, "user:1", plaintext, &value), cache.ErrNotEncrypted)
+}
@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"diskey/pkg/batcher"
+)
+
+const (
+	defaultBatchSize        = 1000
+	defaultBatchLinger      = 0
+	defaultBatchMaxBytes    = 4 << 20
+	defaultBatchConcurrency = 2
+)
+
+// OptionBatchSize bounds how many requests are sent to a peer in one batch. Defaults to 1000.
+func OptionBatchSize(batchSize int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.batching.size = batchSize
+	}
+}
+
+// OptionBatchLinger waits up to the linger after the first request of a batch for more requests to the same peer.
+// Without a linger, which is the default, a batch is sent as soon as no more requests are waiting, so requests only
+// batch up while earlier batches to the peer are in flight. A linger trades latency for larger batches.
+func OptionBatchLinger(linger time.Duration) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.batching.linger = linger
+	}
+}
+
+// OptionBatchMaxBytes bounds the keys and values sent to a peer in one batch. A request larger than maxBytes is sent
+// in a batch of its own. Defaults to 4MiB.
+func OptionBatchMaxBytes(maxBytes int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.batching.maxBytes = maxBytes
+	}
+}
+
+// OptionBatchConcurrency bounds how many batches may be in flight to each peer at once. Defaults to 2.
+func OptionBatchConcurrency(concurrency int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.batching.concurrency = concurrency
+	}
+}
+
+// batchingConfig is how requests to other nodes are batched.
+type batchingConfig struct {
+	size        int
+	linger      time.Duration
+	maxBytes    int
+	concurrency int
+}
+
+// peerBatcher batches the requests sent to one peer. It stops when its context is done.
+type peerBatcher struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	batchChannel chan<- *keyRequest
+}
+
+// peerBatchers runs a batcher for every peer, so a slow peer only holds up the requests sent to it.
+type peerBatchers struct {
+	batchers map[string]peerBatcher
+	mutex    sync.Mutex
+}
+
+func newPeerBatchers() *peerBatchers {
+	return &peerBatchers{
+		batchers: map[string]peerBatcher{},
+		mutex:    sync.Mutex{},
+	}
+}
+
+// batcherFor returns the batcher of the peer, starting it on first use.
+func (self *Cluster) batcherFor(ownerAddress Address) peerBatcher {
+	peer := ownerAddress.String()
+
+	self.peerBatchers.mutex.Lock()
+	defer self.peerBatchers.mutex.Unlock()
+
+	if existing, exists := self.peerBatchers.batchers[peer]; exists {
+		return existing
+	}
+
+	ctx, cancel := context.WithCancel(self.ctx)
+	batchChannel := batcher.Run(self.batching.size, func(batch []*keyRequest) {
+		self.runBatch(ctx, batch)
+	},
+		batcher.OptionContext(ctx),
+		batcher.OptionLinger(self.batching.linger),
+		batcher.OptionMaxBytes(self.batching.maxBytes),
+		batcher.OptionConcurrency(self.batching.concurrency),
+		batcher.OptionOnFlushed(func(batchSize int, wait time.Duration) {
+			self.metrics.observeFlush(peer, batchSize, wait)
+		}),
+	)
+
+	started := peerBatcher{
+		ctx:          ctx,
+		cancel:       cancel,
+		batchChannel: batchChannel,
+	}
+	self.peerBatchers.batchers[peer] = started
+
+	return started
+}
+
+// stopBatcher stops the batcher of a peer that left. Requests still waiting in it fail.
+func (self *Cluster) stopBatcher(address Address) {
+	self.peerBatchers.mutex.Lock()
+	defer self.peerBatchers.mutex.Unlock()
+
+	if existing, exists := self.peerBatchers.batchers[address.String()]; exists {
+		existing.cancel()
+		delete(self.peerBatchers.batchers, address.String())
+	}
+}
+
+// Size is what the request counts towards the max bytes of a batch.
+func (self *keyRequest) Size() int {
+	if args, ok := self.request.Args.(SetArgs); ok {
+		return len(self.key) + len(args.ValueBytes)
+	}
+	return len(self.key)
+}
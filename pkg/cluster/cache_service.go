@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"diskey/pkg/command"
+	"diskey/pkg/trace"
+)
+
+// cacheService serves command.Cache to callers that are not nodes when the node uses rpc.TransportGRPC. Keys are sent
+// to their owners like the requests of any other caller of the cluster.
+type cacheService struct {
+	command.UnimplementedCacheServer
+	cluster *Cluster
+}
+
+func newCacheService(cluster *Cluster) cacheService {
+	return cacheService{
+		UnimplementedCacheServer: command.UnimplementedCacheServer{},
+		cluster:                  cluster,
+	}
+}
+
+func (self cacheService) Get(ctx context.Context, args *command.GetArgs) (*command.GetReply, error) {
+	ctx = trace.ContextWithSpanContext(ctx, args.GetTrace().SpanContext())
+
+	reply, err := self.cluster.getReply(ctx, args.GetKey())
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	return &command.GetReply{
+		Value:     reply.ValueBytes,
+		ExpiresAt: reply.ExpiresAt,
+		Exists:    reply.Exists,
+	}, nil
+}
+
+func (self cacheService) Set(ctx context.Context, args *command.SetArgs) (*command.SetReply, error) {
+	ctx = trace.ContextWithSpanContext(ctx, args.GetTrace().SpanContext())
+
+	if err := self.cluster.SetBytes(ctx, args.GetKey(), args.GetValue(), args.GetTtl().AsDuration()); err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	return &command.SetReply{}, nil
+}
+
+func (self cacheService) Delete(ctx context.Context, args *command.DeleteArgs) (*command.DeleteReply, error) {
+	ctx = trace.ContextWithSpanContext(ctx, args.GetTrace().SpanContext())
+
+	if err := Delete(ctx, self.cluster, args.GetKey()); err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	return &command.DeleteReply{}, nil
+}
+
+// Batch runs each item on its own and reports the error of a failed item in its result instead of failing the batch.
+func (self cacheService) Batch(ctx context.Context, args *command.BatchArgs) (*command.BatchReply, error) {
+	reply := &command.BatchReply{
+		Results: make([]*command.BatchResult, len(args.GetItems())),
+	}
+
+	for index, item := range args.GetItems() {
+		reply.Results[index] = self.runBatchItem(ctx, index, item)
+	}
+
+	return reply, nil
+}
+
+// BatchStream runs the items in the order they arrive and sends the result of each as soon as it has run, until the
+// caller closes its side of the stream.
+func (self cacheService) BatchStream(stream command.Cache_BatchStreamServer) error {
+	for index := 0; ; index++ {
+		item, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(self.runBatchItem(stream.Context(), index, item)); err != nil {
+			return err
+		}
+	}
+}
+
+// runBatchItem runs one item of a batch. A failed item carries its error in the result.
+func (self cacheService) runBatchItem(ctx context.Context, index int, item *command.BatchItem) *command.BatchResult {
+	result := &command.BatchResult{}
+	var err error
+
+	switch itemArgs := item.GetArgs().(type) {
+	case *command.BatchItem_Get:
+		var getReply *command.GetReply
+		if getReply, err = self.Get(ctx, itemArgs.Get); err == nil {
+			result.Reply = &command.BatchResult_Get{Get: getReply}
+		}
+	case *command.BatchItem_Set:
+		var setReply *command.SetReply
+		if setReply, err = self.Set(ctx, itemArgs.Set); err == nil {
+			result.Reply = &command.BatchResult_Set{Set: setReply}
+		}
+	case *command.BatchItem_Delete:
+		var deleteReply *command.DeleteReply
+		if deleteReply, err = self.Delete(ctx, itemArgs.Delete); err == nil {
+			result.Reply = &command.BatchResult_Delete{Delete: deleteReply}
+		}
+	default:
+		err = status.Errorf(codes.InvalidArgument, "batch item %d has no args", index)
+	}
+
+	if err != nil {
+		result.Error = status.Convert(err).Message()
+	}
+	return result
+}
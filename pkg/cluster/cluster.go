@@ -3,18 +3,24 @@ package cluster
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/memberlist"
 	"github.com/rs/zerolog/log"
 
-	"diskey/pkg/batcher"
 	"diskey/pkg/cache"
+	"diskey/pkg/command"
 	"diskey/pkg/discovery"
+	"diskey/pkg/metrics"
 	"diskey/pkg/rpc"
+	"diskey/pkg/trace"
 )
 
 type Option func(clusterClient *Cluster)
@@ -41,34 +47,122 @@ func OptionMemberListPort(portString string) func(clusterClient *Cluster) {
 	}
 }
 
+// OptionCodec sets the codec Get, Set and the other typed functions encode values with. Values are decoded with the
+// codec they were written with, so nodes using different codecs can share keys. Defaults to cache.Msgpack.
+func OptionCodec(codec cache.Codec) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.encoding.Codec = codec
+	}
+}
+
+// OptionCompression compresses values of at least threshold bytes before they are stored and sent to other nodes. Values
+// are decompressed by whichever node reads them, so nodes with different settings can share keys. Compression is off
+// by default.
+func OptionCompression(compressor cache.Compressor, threshold int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.encoding.Compressor = compressor
+		clusterClient.encoding.CompressionThreshold = threshold
+	}
+}
+
+// OptionMaxDecompressedSize bounds how large a compressed value may grow when this node reads it. Reading a larger
+// value fails instead of exhausting memory. Defaults to cache.DefaultMaxDecompressedSize.
+func OptionMaxDecompressedSize(maxSize int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.encoding.MaxDecompressedSize = maxSize
+	}
+}
+
+// OptionTransport sets the transport other nodes connect to this node with. Each node advertises its transport to the
+// others, so nodes can switch transports one at a time. Defaults to rpc.TransportFramed.
+func OptionTransport(transport rpc.Transport) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.transport = transport
+	}
+}
+
 type clusterMetadata struct {
 	Host string `json:"host"`
 	Port string `json:"port"`
+	// Transport is the transport the node serves. Older nodes leave it out and serve rpc.TransportFramed.
+	Transport rpc.Transport `json:"transport,omitempty"`
+	// Leaving is set while the node drains. Other nodes stop routing keys to it.
+	Leaving bool `json:"leaving,omitempty"`
 }
 
 type Cluster struct {
-	clients        []*rpc.Client
-	addresses      []Address
-	batchChannel   chan<- *keyRequest
-	clientsMutex   sync.RWMutex
-	disco          discovery.Discovery
-	clusterServer  rpc.Server
-	memberList     MemberList
-	keyStore       cache.Cache
-	memberListPort int
+	// ctx is the context the cluster was created with. Background work tied to the lifetime of the cluster uses it.
+	ctx             context.Context
+	cancel          context.CancelFunc
+	clients         []*rpc.Client
+	addresses       []Address
+	peerBatchers    *peerBatchers
+	peerQueues      *peerQueues
+	batching        batchingConfig
+	clientsMutex    sync.RWMutex
+	disco           discovery.Discovery
+	clusterServer   rpc.Server
+	memberList      MemberList
+	keyStore        cache.Cache
+	encoding        cache.Encoding
+	locks           *leaseTable
+	loads           *leaseTable
+	flights         *flightGroup
+	scans           *scanSessions
+	watches         *watchRegistry
+	subscriptions   *subscriptionRegistry
+	events          chan<- Event
+	hotKeys         *hotKeyTracker
+	replicas        *replicaStore
+	replicated      map[string]struct{}
+	replicatedMutex sync.Mutex
+	hotKeyThreshold uint64
+	replicaTTL      time.Duration
+	metrics         *clusterMetrics
+	tracer          *trace.Tracer
+	slowLog         *slowLog
+	readinessChecks []readinessCheck
+	readyMembers    int
+	draining        atomic.Bool
+	migrateMutex    sync.Mutex
+	tombstones      *drainTombstones
+	closed          atomic.Bool
+	pendingRequests atomic.Int64
+	httpMux         *http.ServeMux
+	httpAddress     string
+	memberListPort  int
+	transport       rpc.Transport
 }
 
 func NewCluster(ctx context.Context, host string, port string, options ...Option) *Cluster {
-	cacheConfig := cache.Config{}
+	// Draining cancels the context to stop the work of the node.
+	ctx, cancel := context.WithCancel(ctx)
+
+	// The key store reports removals from inside its own locks, so events are queued and dispatched separately.
+	events := make(chan Event, eventQueueSize)
+	clusterMetrics := newClusterMetrics(metrics.NewRegistry())
+	cacheConfig := cache.Config{
+		OnKeyExpired: func(key string, _ []byte) {
+			clusterMetrics.expirations.Inc()
+			enqueueEvent(events, Event{Key: key, Type: EventExpired})
+		},
+		OnKeyEvicted: func(key string, _ []byte) {
+			clusterMetrics.evictions.Inc()
+			enqueueEvent(events, Event{Key: key, Type: EventEvicted})
+		},
+		OnKeyDeleted: func(key string, _ []byte) {
+			enqueueEvent(events, Event{Key: key, Type: EventDeleted})
+		},
+	}
 	keyStore, err := cache.New(context.WithoutCancel(ctx), cacheConfig)
 	if err != nil {
 		log.Ctx(ctx).Err(err).Send()
 		panic(err)
 	}
 
-	const batchSize = 1000 // TODO: What is an optimal setting? Expose as config?
-
 	cluster := &Cluster{
+		ctx:          ctx,
+		cancel:       cancel,
 		clientsMutex: sync.RWMutex{},
 		clients:      []*rpc.Client{},
 		addresses: []Address{
@@ -80,40 +174,78 @@ func NewCluster(ctx context.Context, host string, port string, options ...Option
 		},
 		disco:          discovery.NewLocalhost([]string{}),
 		memberListPort: 7949,
-		keyStore:       keyStore,
+		transport:      rpc.TransportFramed,
+		peerBatchers:   newPeerBatchers(),
+		peerQueues:     newPeerQueues(),
+		batching: batchingConfig{
+			size:        defaultBatchSize,
+			linger:      defaultBatchLinger,
+			maxBytes:    defaultBatchMaxBytes,
+			concurrency: defaultBatchConcurrency,
+		},
+		keyStore: keyStore,
+		encoding: cache.Encoding{
+			Codec:                cache.Msgpack,
+			Compressor:           nil,
+			CompressionThreshold: 0,
+			MaxDecompressedSize:  0,
+			Fingerprint:          false,
+		},
+		locks:         newFencingLeaseTable(),
+		loads:         newLeaseTable(),
+		flights:       newFlightGroup(),
+		scans:         newScanSessions(),
+		watches:       newWatchRegistry(),
+		subscriptions: newSubscriptionRegistry(),
+		hotKeys:       newHotKeyTracker(),
+		replicas:      newReplicaStore(),
+		replicated:    map[string]struct{}{},
+		metrics:       clusterMetrics,
+		tracer:        trace.NewTracer(nil),
+		slowLog:       newSlowLog(defaultSlowLogThreshold, defaultSlowLogSize),
+		readyMembers:  1,
+		tombstones:    newDrainTombstones(),
+		httpMux:       http.NewServeMux(),
+		events:        events,
 	}
 
 	for index := range options {
 		options[index](cluster)
 	}
 
-	batchChannel := batcher.Run(batchSize, func(batch []*keyRequest) {
-		cluster.runBatch(ctx, batch)
-	})
-	cluster.batchChannel = batchChannel
+	cluster.registerStateMetrics()
+	cluster.httpMux.Handle("/metrics", clusterMetrics.registry.Handler())
+	cluster.httpMux.HandleFunc("/healthz", cluster.handleHealthz)
+	cluster.httpMux.HandleFunc("/readyz", cluster.handleReadyz)
+
+	go cluster.dispatchEvents(ctx, events)
+	go cluster.trackHotKeys(ctx)
 
 	clusterServer := rpc.NewServer(host, port)
+	clusterServer.SetTransport(cluster.transport)
 	listener, listenErr := clusterServer.Listen(ctx)
 	if listenErr.IsErr() {
 		log.Ctx(ctx).Err(listenErr).Send()
 		panic(listenErr)
 	}
 
-	handlerErr := clusterServer.RegisterHandler(ctx, ClusterCommandRpcHandlers{
+	handlerErr := clusterServer.RegisterHandlers(ctx, ClusterCommandRpcHandlers{
 		Cluster: cluster,
-		// getKeyHandler: cluster.getHandler,
-	})
+	}.rpcHandlers())
 	if handlerErr.IsErr() {
 		panic("failed registering cluster commands: " + handlerErr.Error())
 	}
+	clusterServer.RegisterService(&command.Cache_ServiceDesc, newCacheService(cluster))
 
 	go clusterServer.AcceptConnections(ctx, listener)
 
 	cluster.clusterServer = clusterServer
 
 	metadata, err := json.Marshal(clusterMetadata{
-		Host: host,
-		Port: port,
+		Host:      host,
+		Port:      port,
+		Leaving:   false,
+		Transport: cluster.transport,
 	})
 	if err != nil {
 		panic("failed marshalling metadata: " + err.Error())
@@ -122,14 +254,54 @@ func NewCluster(ctx context.Context, host string, port string, options ...Option
 	cluster.memberList = NewMemberList(ctx, cluster.disco, metadata,
 		MemberListOptionHost(host),
 		MemberListOptionPort(cluster.memberListPort),
-		MemberListOptionEventCallbacks(ctx, cluster.onJoin, cluster.onLeave, func(ctx context.Context, node *memberlist.Node) {}),
+		MemberListOptionEventCallbacks(ctx, cluster.onJoin, cluster.onLeave, cluster.onUpdate),
 	)
 
+	if cluster.httpAddress != "" {
+		cluster.serveHTTP(ctx)
+	}
+
 	return cluster
 }
 
+// Encoding returns how typed functions encode values.
+func (self *Cluster) Encoding() cache.Encoding {
+	return self.encoding
+}
+
+// Close leaves the cluster and stops everything the node runs: the rpc and http servers, the batchers, background work
+// and the connections to other nodes. Calls in flight on the rpc server finish first. Keys on the node are lost, so
+// use Drain to hand them off instead. Calls after the first do nothing.
 func (self *Cluster) Close() error {
-	return self.memberList.Shutdown(time.Second)
+	if !self.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	errs := []error{}
+
+	if err := self.memberList.Shutdown(leaveTimeout); err != nil {
+		errs = append(errs, err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(self.ctx), serverShutdownTimeout)
+	defer cancel()
+	if err := self.clusterServer.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+
+	self.cancel()
+
+	self.clientsMutex.Lock()
+	for index := range self.clients {
+		self.clients[index].Disconnect(self.ctx)
+	}
+	self.clientsMutex.Unlock()
+
+	if err := self.keyStore.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }
 
 func (self *Cluster) NumClients() int {
@@ -166,35 +338,94 @@ func (self *Cluster) onJoin(ctx context.Context, node *memberlist.Node) {
 	}
 
 	newClient := rpc.NewClient(host, port)
+	newClient.SetTransport(metadata.Transport)
 	if connectErr := newClient.Connect(ctx); connectErr.IsErr() {
 		// TODO: This needs retry logic to eventually self-heal.
 		log.Ctx(ctx).Err(connectErr).Msg("failed to connect new client")
 		return
 	}
 	log.Ctx(ctx).Info().Str("self", self.clusterServer.Address()).Str("host", host).Str("port", port).Msg("client connected")
+	self.metrics.observeClient(newClient)
+	self.metrics.joins.Inc()
 	self.clients = append(self.clients, newClient)
-	self.addresses = append(self.addresses, Address{
+	newAddress := Address{
 		Host: newClient.Host(),
 		Port: newClient.Port(),
 		Slot: Slot(newClient.Host() + ":" + newClient.Port()),
-	})
+	}
+	self.addresses = append(self.addresses, newAddress)
+
+	// Sending needs the clients lock that is held here.
+	go self.registerWatches(ctx, newAddress)
+	go self.syncLockTokens(ctx)
 }
 
 func (self *Cluster) onLeave(ctx context.Context, node *memberlist.Node) {
+	var metadata clusterMetadata
+	if err := json.Unmarshal(node.Meta, &metadata); err != nil {
+		log.Ctx(ctx).Err(err).Msg("failed to unmarshal cluster metadata")
+		return
+	}
+
+	host := metadata.Host
+	port := metadata.Port
+
 	self.clientsMutex.Lock()
 	defer self.clientsMutex.Unlock()
 
-	host := node.Addr.String()
-	port := strconv.Itoa(int(node.Port))
-
 	index := slices.IndexFunc(self.clients, func(client *rpc.Client) bool {
 		return client.Host() == host && client.Port() == port
 	})
 
 	if index >= 0 {
 		self.clients[index].Disconnect(ctx)
-		self.clients = slices.Delete(self.clients, index, index)
+		self.clients = slices.Delete(self.clients, index, index+1)
 	}
+
+	// Stop routing keys to the node that left. Its hash slots are picked up by the closest remaining addresses.
+	self.addresses = slices.DeleteFunc(self.addresses, func(address Address) bool {
+		return address.Host == host && address.Port == port
+	})
+
+	self.watches.removeAddress(Address{Host: host, Port: port})
+	self.stopBatcher(Address{Host: host, Port: port})
+	self.stopPeerQueue(Address{Host: host, Port: port})
+	self.tombstones.stop(Address{Host: host, Port: port})
+	self.metrics.leaves.Inc()
+
+	// The lock names of the node that left have new successors.
+	go self.syncLockTokens(ctx)
+
+	log.Ctx(ctx).Info().Str("self", self.clusterServer.Address()).Str("host", host).Str("port", port).Msg("client left")
+}
+
+// onUpdate stops routing keys to a node once it starts draining. The connection to it stays open until it leaves
+// since it still sends its keys to their new owners.
+func (self *Cluster) onUpdate(ctx context.Context, node *memberlist.Node) {
+	var metadata clusterMetadata
+	if err := json.Unmarshal(node.Meta, &metadata); err != nil {
+		log.Ctx(ctx).Err(err).Msg("failed to unmarshal cluster metadata")
+		return
+	}
+
+	if !metadata.Leaving || (metadata.Host == self.clusterServer.Host() && metadata.Port == self.clusterServer.Port()) {
+		return
+	}
+
+	self.removeAddress(metadata.Host, metadata.Port)
+	self.tombstones.start(Address{Host: metadata.Host, Port: metadata.Port})
+
+	log.Ctx(ctx).Info().Str("self", self.clusterServer.Address()).Str("host", metadata.Host).Str("port", metadata.Port).Msg("client draining")
+}
+
+// removeAddress stops routing keys to the node. Its hash slots are picked up by the closest remaining addresses.
+func (self *Cluster) removeAddress(host string, port string) {
+	self.clientsMutex.Lock()
+	defer self.clientsMutex.Unlock()
+
+	self.addresses = slices.DeleteFunc(self.addresses, func(address Address) bool {
+		return address.Host == host && address.Port == port
+	})
 }
 
 func (self *Cluster) getClientByHostPort(host string, port string) *rpc.Client {
@@ -207,3 +438,19 @@ func (self *Cluster) getClientByHostPort(host string, port string) *rpc.Client {
 	}
 	return nil
 }
+
+// sendToOwner runs the request on the node that owns the key. When this node is the owner, runLocal is called
+// instead of making a round trip through the rpc server.
+func (self *Cluster) sendToOwner(ctx context.Context, key string, request command.Request, runLocal func() error) error {
+	ownerAddress := self.getClosestAddress(key)
+	if ownerAddress.String() == self.clusterServer.Address() {
+		return runLocal()
+	}
+
+	clientKeyOwner := self.getClientByHostPort(ownerAddress.Host, ownerAddress.Port)
+	if clientKeyOwner == nil {
+		return fmt.Errorf("no connection to key owner %s", ownerAddress.String())
+	}
+
+	return clientKeyOwner.Send(ctx, request)
+}
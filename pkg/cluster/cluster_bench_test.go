@@ -101,13 +101,13 @@ func BenchmarkCache_Get_local(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		gotValue, exists := cluster.Get[BenchValue](caches[0], "key")
+		gotValue, exists := cluster.Get[BenchValue](ctx, caches[0], "key")
 		if gotValue != value || !exists {
 			panic("unexpected result")
 		}
@@ -127,13 +127,13 @@ func BenchmarkCache_Get_remote(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		gotValue, exists := cluster.Get[BenchValue](caches[1], "key")
+		gotValue, exists := cluster.Get[BenchValue](ctx, caches[1], "key")
 		if gotValue != value || !exists {
 			panic("unexpected result")
 		}
@@ -153,14 +153,14 @@ func BenchmarkCache_Get_remote_parallel(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
-			gotValue, exists := cluster.Get[BenchValue](caches[1], "key")
+			gotValue, exists := cluster.Get[BenchValue](ctx, caches[1], "key")
 			if gotValue != value || !exists {
 				panic(fmt.Sprintf("unexpected result: %+v, %t", gotValue, exists))
 			}
@@ -181,13 +181,13 @@ func BenchmarkCache_Set_local(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if err := cluster.Set(caches[0], "key", value); err != nil {
+		if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 			panic(err)
 		}
 	}
@@ -206,13 +206,13 @@ func BenchmarkCache_Set_remote(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if err := cluster.Set(caches[1], "key", value); err != nil {
+		if err := cluster.Set(ctx, caches[1], "key", value); err != nil {
 			panic(err)
 		}
 	}
@@ -234,12 +234,12 @@ func BenchmarkCache_Delete_local(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
-		if err := cluster.Set(caches[0], "key", value); err != nil {
+		if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 			panic(err)
 		}
 		b.StartTimer()
 
-		if err := cluster.Delete(caches[0], "key"); err != nil {
+		if err := cluster.Delete(ctx, caches[0], "key"); err != nil {
 			panic(err)
 		}
 	}
@@ -261,12 +261,12 @@ func BenchmarkCache_Delete_remote(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
-		if err := cluster.Set(caches[0], "key", value); err != nil {
+		if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 			panic(err)
 		}
 		b.StartTimer()
 
-		if err := cluster.Delete(caches[1], "key"); err != nil {
+		if err := cluster.Delete(ctx, caches[1], "key"); err != nil {
 			panic(err)
 		}
 	}
@@ -285,13 +285,13 @@ func BenchmarkCache_Get_local_100_nodes(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		gotValue, exists := cluster.Get[BenchValue](caches[0], "key")
+		gotValue, exists := cluster.Get[BenchValue](ctx, caches[0], "key")
 		if gotValue != value || !exists {
 			panic("unexpected result")
 		}
@@ -311,13 +311,13 @@ func BenchmarkCache_Get_remote_100_nodes(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		gotValue, exists := cluster.Get[BenchValue](caches[1], "key")
+		gotValue, exists := cluster.Get[BenchValue](ctx, caches[1], "key")
 		if gotValue != value || !exists {
 			panic("unexpected result")
 		}
@@ -337,13 +337,13 @@ func BenchmarkCache_Set_local_100_nodes(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if err := cluster.Set(caches[0], "key", value); err != nil {
+		if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 			panic(err)
 		}
 	}
@@ -362,13 +362,13 @@ func BenchmarkCache_Set_remote_100_nodes(b *testing.B) {
 		Bar: "bench",
 	}
 
-	if err := cluster.Set(caches[0], "key", value); err != nil {
+	if err := cluster.Set(ctx, caches[0], "key", value); err != nil {
 		panic(err)
 	}
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		if err := cluster.Set(caches[1], "key", value); err != nil {
+		if err := cluster.Set(ctx, caches[1], "key", value); err != nil {
 			panic(err)
 		}
 	}
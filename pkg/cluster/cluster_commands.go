@@ -3,249 +3,556 @@ package cluster
 import (
 	"context"
 	"errors"
-	"sync/atomic"
+	"fmt"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
+	"github.com/rs/zerolog/log"
 
 	"diskey/pkg/cache"
 	"diskey/pkg/command"
+	"diskey/pkg/rpc"
+	"diskey/pkg/trace"
 )
 
 type ClusterCommandRpcHandlers struct {
 	*Cluster
 }
 
+// rpcHandlers returns the handler of every cluster command by opcode.
+func (self ClusterCommandRpcHandlers) rpcHandlers() map[command.Opcode]rpc.Handler {
+	return map[command.Opcode]rpc.Handler{
+		command.OpcodeGet:              rpc.NewHandler(self.Get),
+		command.OpcodeSet:              rpc.NewHandler(self.Set),
+		command.OpcodeDelete:           rpc.NewHandler(self.Delete),
+		command.OpcodeBatch:            rpc.NewHandler(self.Batch),
+		command.OpcodeScan:             rpc.NewHandler(self.Scan),
+		command.OpcodeDeletePattern:    rpc.NewHandler(self.DeletePattern),
+		command.OpcodePublish:          rpc.NewHandler(self.Publish),
+		command.OpcodeEvents:           rpc.NewHandler(self.Events),
+		command.OpcodeWatch:            rpc.NewHandler(self.Watch),
+		command.OpcodeUnwatch:          rpc.NewHandler(self.Unwatch),
+		command.OpcodeHotKeys:          rpc.NewHandler(self.HotKeys),
+		command.OpcodeReplicate:        rpc.NewHandler(self.Replicate),
+		command.OpcodeDropReplicas:     rpc.NewHandler(self.DropReplicas),
+		command.OpcodeAcquireLock:      rpc.NewHandler(self.AcquireLock),
+		command.OpcodeRefreshLock:      rpc.NewHandler(self.RefreshLock),
+		command.OpcodeReleaseLock:      rpc.NewHandler(self.ReleaseLock),
+		command.OpcodeGetOrLease:       rpc.NewHandler(self.GetOrLease),
+		command.OpcodeReleaseLoadLease: rpc.NewHandler(self.ReleaseLoadLease),
+		command.OpcodeSlowLog:          rpc.NewHandler(self.SlowLog),
+		command.OpcodeMigrate:          rpc.NewHandler(self.Migrate),
+		command.OpcodeSyncLockTokens:   rpc.NewHandler(self.SyncLockTokens),
+	}
+}
+
 type GetArgs struct {
-	Key string
+	trace.Propagated `msgpack:"-"`
+	Key              string
 }
 
 type GetReply struct {
 	ValueBytes []byte
-	Exists     bool
+	// ExpiresAt is the unix nano time the key expires at, or zero if it never expires.
+	ExpiresAt int64
+	Exists    bool
 }
 
 func (self ClusterCommandRpcHandlers) Get(args GetArgs, reply *GetReply) error {
-	valueBytes, err := self.keyStore.Get(args.Key)
+	ctx, span := self.startSpan(args.SpanContext(), "Get", args.Key)
+	defer span.End()
+	defer self.recordSlow(time.Now(), "Get", args.Key, "", 0)
+
+	self.hotKeys.add(args.Key)
+
+	valueBytes, expiresAt, err := self.keyStore.GetWithExpiry(args.Key)
 	if err != nil {
 		if errors.Is(err, bigcache.ErrEntryNotFound) {
+			// A draining node serves the keys it still has. Anything else may already be on the new owner.
+			if forwarded, forwardErr := self.forwardWhileDraining(ctx, args.Key, newGetRequest(args.Key, reply)); forwarded {
+				return forwardErr
+			}
+			log.Ctx(ctx).Debug().Str("key", args.Key).Msg("get missed")
 			return nil
 		}
+		span.RecordError(err)
+		log.Ctx(ctx).Err(err).Str("key", args.Key).Msg("failed to get key")
 		return err // FIXME: generic error
 	}
 
+	log.Ctx(ctx).Debug().Str("key", args.Key).Msg("get hit")
+
 	reply.ValueBytes = valueBytes
 	reply.Exists = true
+	if !expiresAt.IsZero() {
+		reply.ExpiresAt = expiresAt.UnixNano()
+	}
 
 	return nil
 }
 
 func newGetRequest(key string, resp *GetReply) command.Request {
 	return command.Request{
-		Name:  "ClusterCommandRpcHandlers.Get",
-		Args:  GetArgs{Key: key},
-		Reply: resp,
+		Opcode: command.OpcodeGet,
+		Args:   GetArgs{Key: key, Propagated: trace.Propagated{}},
+		Reply:  resp,
 	}
 }
 
-func Get[T cache.Value](cluster *Cluster, key string) (T, bool) {
-	ownerAddress := cluster.getClosestAddress(key)
-	if cluster.clusterServer.Address() == ownerAddress.String() {
-		value, err := cache.Get[T](cluster.keyStore, key)
-		if err != nil {
-			if errors.Is(err, bigcache.ErrEntryNotFound) {
-				return value, false
-			}
-			return value, false // FIXME: generic error
-		}
-		return value, true
+func Get[T cache.Value](ctx context.Context, cluster *Cluster, key string) (T, bool) {
+	var value T
+
+	valueBytes, exists, err := cluster.GetBytes(ctx, key)
+	if err != nil || !exists {
+		return value, false // FIXME: generic error
 	}
 
-	response := &GetReply{}
-	request := &keyRequest{
-		key:     key,
-		request: newGetRequest(key, response),
-		done:    atomic.Bool{},
+	if err := cache.UnmarshalValueWith(cluster.encoding, valueBytes, &value); err != nil {
+		return value, false
 	}
 
-	sendRequest(cluster, request)
+	return value, true
+}
+
+// GetBytes gets the encoded value of the key from the node that owns it.
+func (self *Cluster) GetBytes(ctx context.Context, key string) ([]byte, bool, error) {
+	response, err := self.getReply(ctx, key)
+	return response.ValueBytes, response.Exists, err
+}
 
-	var value T
-	if err := cache.UnmarshalValue(response.ValueBytes, &value); err != nil {
-		return value, false
+func (self *Cluster) getReply(ctx context.Context, key string) (*GetReply, error) {
+	ctx, span := self.startKeySpan(ctx, "Get", key)
+	defer span.End()
+
+	response := &GetReply{}
+
+	ownerAddress := self.getClosestAddress(key)
+	if self.clusterServer.Address() == ownerAddress.String() {
+		args := GetArgs{Key: key, Propagated: trace.Propagated{}}
+		args.SetSpanContext(span.SpanContext())
+		err := ClusterCommandRpcHandlers{Cluster: self}.Get(args, response)
+		span.RecordError(err)
+		return response, err
+	}
+
+	if replica, exists := self.replicas.get(key); exists {
+		log.Ctx(ctx).Debug().Str("key", key).Msg("get served from replica")
+		span.SetAttribute("replica", "true")
+		response.ValueBytes = replica.valueBytes
+		response.ExpiresAt = replica.expiresAt.UnixNano()
+		response.Exists = true
+		return response, nil
 	}
 
-	return value, response.Exists
+	request := newKeyRequest(key, newGetRequest(key, response))
+	request.request.Trace = span.SpanContext()
+
+	log.Ctx(ctx).Debug().Str("key", key).Str("owner", ownerAddress.String()).Msg("forwarding get to key owner")
+	start := time.Now()
+	batchSize, err := sendRequest(ctx, self, ownerAddress, request)
+	self.recordSlow(start, "Get", key, ownerAddress.String(), batchSize)
+	span.RecordError(err)
+
+	return response, err
 }
 
 type SetArgs struct {
-	Key        string
-	ValueBytes []byte
+	trace.Propagated `msgpack:"-"`
+	Key              string
+	ValueBytes       []byte
+	TTL              time.Duration
 }
 
 type SetReply struct{}
 
 func (self ClusterCommandRpcHandlers) Set(args SetArgs, reply *SetReply) error {
-	return self.keyStore.Set(args.Key, args.ValueBytes)
+	ctx, span := self.startSpan(args.SpanContext(), "Set", args.Key)
+	defer span.End()
+	defer self.recordSlow(time.Now(), "Set", args.Key, "", 0)
+
+	if self.draining.Load() {
+		// Drop the old value so that it is neither served nor migrated over the new one.
+		self.dropWhileDraining(args.Key)
+	}
+	if forwarded, err := self.forwardWhileDraining(ctx, args.Key, newSetRequest(args.Key, args.ValueBytes, args.TTL, reply)); forwarded {
+		return err
+	}
+
+	if err := self.keyStore.SetWithTTL(args.Key, args.ValueBytes, args.TTL); err != nil {
+		span.RecordError(err)
+		log.Ctx(ctx).Err(err).Str("key", args.Key).Msg("failed to set key")
+		return err
+	}
+
+	log.Ctx(ctx).Debug().Str("key", args.Key).Msg("set")
+
+	// The value is now available, so anyone waiting on a load of the key can stop waiting.
+	self.loads.remove(args.Key)
+
+	enqueueEvent(self.events, Event{Key: args.Key, Type: EventSet})
+
+	return nil
 }
 
-func newSetRequest(key string, valueBytes []byte, resp *SetReply) command.Request {
+func newSetRequest(key string, valueBytes []byte, ttl time.Duration, resp *SetReply) command.Request {
 	return command.Request{
-		Name: "ClusterCommandRpcHandlers.Set",
+		Opcode: command.OpcodeSet,
 		Args: SetArgs{
+			Propagated: trace.Propagated{},
 			Key:        key,
 			ValueBytes: valueBytes,
+			TTL:        ttl,
 		},
 		Reply: resp,
 	}
 }
 
-func Set[T cache.Value](cluster *Cluster, key string, value T) error {
-	ownerAddress := cluster.getClosestAddress(key)
-	if cluster.clusterServer.Address() == ownerAddress.String() {
-		return cache.Set(cluster.keyStore, key, value)
-	}
+func Set[T cache.Value](ctx context.Context, cluster *Cluster, key string, value T) error {
+	return SetWithTTL(ctx, cluster, key, value, 0)
+}
 
-	valueBytes, err := cache.MarshalValue(value)
+// SetWithTTL sets the key and expires it after the ttl. A ttl of zero never expires the key.
+func SetWithTTL[T cache.Value](ctx context.Context, cluster *Cluster, key string, value T, ttl time.Duration) error {
+	valueBytes, err := cache.MarshalValueWith(cluster.encoding, value)
 	if err != nil {
 		return err
 	}
 
-	response := &SetReply{}
-	request := &keyRequest{
-		key:     key,
-		request: newSetRequest(key, valueBytes, response),
-		done:    atomic.Bool{},
+	return cluster.SetBytes(ctx, key, valueBytes, ttl)
+}
+
+// SetBytes sets the encoded value of the key on the node that owns it and expires it after the ttl. A ttl of zero
+// never expires the key.
+func (self *Cluster) SetBytes(ctx context.Context, key string, valueBytes []byte, ttl time.Duration) error {
+	ctx, span := self.startKeySpan(ctx, "Set", key)
+	defer span.End()
+
+	ownerAddress := self.getClosestAddress(key)
+	if self.clusterServer.Address() == ownerAddress.String() {
+		args := SetArgs{
+			Propagated: trace.Propagated{},
+			Key:        key,
+			ValueBytes: valueBytes,
+			TTL:        ttl,
+		}
+		args.SetSpanContext(span.SpanContext())
+		err := ClusterCommandRpcHandlers{Cluster: self}.Set(args, &SetReply{})
+		span.RecordError(err)
+		return err
 	}
 
-	sendRequest(cluster, request)
+	// Read your own writes instead of a copy of the old value.
+	self.replicas.remove(key)
 
-	return nil
+	response := &SetReply{}
+	request := newKeyRequest(key, newSetRequest(key, valueBytes, ttl, response))
+	request.request.Trace = span.SpanContext()
+
+	log.Ctx(ctx).Debug().Str("key", key).Str("owner", ownerAddress.String()).Msg("forwarding set to key owner")
+	start := time.Now()
+	batchSize, err := sendRequest(ctx, self, ownerAddress, request)
+	self.recordSlow(start, "Set", key, ownerAddress.String(), batchSize)
+	span.RecordError(err)
+
+	return err
 }
 
 type DeleteArgs struct {
-	Key string
+	trace.Propagated `msgpack:"-"`
+	Key              string
 }
 
 type DeleteReply struct{}
 
 func (self ClusterCommandRpcHandlers) Delete(args DeleteArgs, reply *DeleteReply) error {
+	ctx, span := self.startSpan(args.SpanContext(), "Delete", args.Key)
+	defer span.End()
+	defer self.recordSlow(time.Now(), "Delete", args.Key, "", 0)
+
+	if self.draining.Load() {
+		// Drop the value so that it is not migrated after the delete reaches the new owner.
+		self.dropWhileDraining(args.Key)
+	}
+	if forwarded, err := self.forwardWhileDraining(ctx, args.Key, newDeleteRequest(args.Key, reply)); forwarded {
+		return err
+	}
+
+	self.tombstones.record(args.Key)
 	err := self.keyStore.Delete(args.Key)
 	if err != nil {
 		if errors.Is(err, bigcache.ErrEntryNotFound) {
 			// Not found errors on delete are not an error. This is the desired case.
 			return nil
 		}
+		span.RecordError(err)
+		log.Ctx(ctx).Err(err).Str("key", args.Key).Msg("failed to delete key")
 		return err // FIXME: generic error
 	}
 
+	log.Ctx(ctx).Debug().Str("key", args.Key).Msg("deleted")
+
 	return nil
 }
 
 func newDeleteRequest(key string, resp *DeleteReply) command.Request {
 	return command.Request{
-		Name:  "ClusterCommandRpcHandlers.Delete",
-		Args:  GetArgs{Key: key},
-		Reply: resp,
+		Opcode: command.OpcodeDelete,
+		Args:   DeleteArgs{Key: key, Propagated: trace.Propagated{}},
+		Reply:  resp,
 	}
 }
 
-func Delete(cluster *Cluster, key string) error {
+func Delete(ctx context.Context, cluster *Cluster, key string) error {
+	ctx, span := cluster.startKeySpan(ctx, "Delete", key)
+	defer span.End()
+
 	ownerAddress := cluster.getClosestAddress(key)
 	if cluster.clusterServer.Address() == ownerAddress.String() {
-		err := cache.Delete(cluster.keyStore, key)
-		if err != nil {
-			if errors.Is(err, bigcache.ErrEntryNotFound) {
-				return nil
-			}
-			return err // FIXME: generic error
-		}
-		return nil
+		args := DeleteArgs{Key: key, Propagated: trace.Propagated{}}
+		args.SetSpanContext(span.SpanContext())
+		err := ClusterCommandRpcHandlers{Cluster: cluster}.Delete(args, &DeleteReply{})
+		span.RecordError(err)
+		return err
 	}
 
+	cluster.replicas.remove(key)
+
 	response := &DeleteReply{}
-	request := &keyRequest{
-		key:     key,
-		request: newDeleteRequest(key, response),
-		done:    atomic.Bool{},
+	request := newKeyRequest(key, newDeleteRequest(key, response))
+	request.request.Trace = span.SpanContext()
+
+	log.Ctx(ctx).Debug().Str("key", key).Str("owner", ownerAddress.String()).Msg("forwarding delete to key owner")
+	start := time.Now()
+	batchSize, err := sendRequest(ctx, cluster, ownerAddress, request)
+	cluster.recordSlow(start, "Delete", key, ownerAddress.String(), batchSize)
+	span.RecordError(err)
+
+	return err
+}
+
+// BatchStatus reports how one item of a batch went.
+type BatchStatus uint
+
+const (
+	BatchStatusOK = BatchStatus(iota + 1)
+	// BatchStatusFailed means the handler of the item returned an error.
+	BatchStatusFailed
+	// BatchStatusInvalid means the item did not hold exactly one set of args.
+	BatchStatusInvalid
+)
+
+func (self BatchStatus) String() string {
+	switch self {
+	case BatchStatusOK:
+		return "OK"
+	case BatchStatusFailed:
+		return "Failed"
+	case BatchStatusInvalid:
+		return "Invalid"
+	default:
+		return "BatchStatus"
 	}
+}
 
-	sendRequest(cluster, request)
+// BatchItem is one request of a batch. Exactly one of the args is set.
+type BatchItem struct {
+	Get    *GetArgs    `msgpack:",omitempty"`
+	Set    *SetArgs    `msgpack:",omitempty"`
+	Delete *DeleteArgs `msgpack:",omitempty"`
+	// Trace is the span the request belongs to. Each request of a batch continues its own trace.
+	Trace trace.SpanContext
+}
 
-	return nil
+// BatchResult is the result of the item at the same index of a batch. The reply matching the args of the item is set
+// when the status is BatchStatusOK, and Error otherwise.
+type BatchResult struct {
+	Get    *GetReply    `msgpack:",omitempty"`
+	Set    *SetReply    `msgpack:",omitempty"`
+	Delete *DeleteReply `msgpack:",omitempty"`
+	Error  string       `msgpack:",omitempty"`
+	Status BatchStatus
 }
 
 type BatchArgs struct {
-	Requests []*command.Request
+	Items []BatchItem
 }
 
 type BatchReply struct {
-	Responses []any
+	Results []BatchResult
 }
 
+// Batch runs every item of the batch. An item that fails reports it in its result and does not fail the others.
 func (self ClusterCommandRpcHandlers) Batch(args BatchArgs, reply *BatchReply) error {
-	reply.Responses = make([]any, len(args.Requests))
-	for index := range args.Requests {
-		if err := runBatchRequest(self, args.Requests[index].Name, args.Requests[index].Args, &reply.Responses[index]); err != nil {
-			return err
-		}
+	reply.Results = make([]BatchResult, len(args.Items))
+	for index := range args.Items {
+		reply.Results[index] = runBatchItem(self, args.Items[index])
 	}
 
 	return nil
 }
 
-func newBatchRequest(requests []*command.Request, responses *BatchReply) command.Request {
+func newBatchRequest(items []BatchItem, responses *BatchReply) command.Request {
 	return command.Request{
-		Name: "ClusterCommandRpcHandlers.Batch",
+		Opcode: command.OpcodeBatch,
 		Args: BatchArgs{
-			Requests: requests,
+			Items: items,
 		},
 		Reply: responses,
 	}
 }
 
+// keyRequestTimeout is how long a caller waits for the batch holding its request to finish.
+const keyRequestTimeout = 5 * time.Second
+
+// keyRequest is a request handed to the batcher of its key owner. Its caller waits on done.
 type keyRequest struct {
-	key     string
+	key string
+	// request is what the batch runs. Its reply belongs to the keyRequest, so a batch that finishes after its caller
+	// gave up does not write into the reply of the caller.
 	request command.Request
-	done    atomic.Bool
+	// reply is the reply of the caller. It gets a copy of the reply of the request once the request is done.
+	reply any
+	// err is the error of the request. It is set before done is closed.
+	err error
+	// batchSize is the size of the batch the request was sent to its owner in. It is set before done is closed.
+	batchSize int
+	done      chan struct{}
 }
 
-func runBatchRequest(handlers ClusterCommandRpcHandlers, name string, args any, reply *any) error {
-	switch name {
-	case "ClusterCommandRpcHandlers.Get":
-		args := GetArgs{
-			Key: args.(map[string]any)["Key"].(string),
-		}
-		getReply := &GetReply{}
-		if err := handlers.Get(args, getReply); err != nil {
-			return err
-		}
-		*reply = getReply
-	case "ClusterCommandRpcHandlers.Set":
-		args := SetArgs{
-			Key:        args.(map[string]any)["Key"].(string),
-			ValueBytes: args.(map[string]any)["ValueBytes"].([]byte),
-		}
-		setReply := &SetReply{}
-		if err := handlers.Set(args, setReply); err != nil {
-			return err
-		}
-		*reply = setReply
-	case "ClusterCommandRpcHandlers.Delete":
-		args := DeleteArgs{
-			Key: args.(map[string]any)["Key"].(string),
-		}
-		deleteReply := &DeleteReply{}
-		if err := handlers.Delete(args, deleteReply); err != nil {
-			return err
+func newKeyRequest(key string, request command.Request) *keyRequest {
+	reply := request.Reply
+	switch reply.(type) {
+	case *GetReply:
+		request.Reply = &GetReply{}
+	case *SetReply:
+		request.Reply = &SetReply{}
+	case *DeleteReply:
+		request.Reply = &DeleteReply{}
+	}
+
+	return &keyRequest{
+		key:       key,
+		request:   request,
+		reply:     reply,
+		err:       nil,
+		batchSize: 0,
+		done:      make(chan struct{}),
+	}
+}
+
+// finish hands the error of the request to its caller.
+func (self *keyRequest) finish(err error) {
+	self.err = err
+	close(self.done)
+}
+
+// copyReply copies the reply of the request into the reply of the caller. It must only be called once done is closed.
+func (self *keyRequest) copyReply() {
+	switch reply := self.reply.(type) {
+	case *GetReply:
+		*reply = *self.request.Reply.(*GetReply)
+	case *SetReply:
+		// SetReply is an empty body.
+	case *DeleteReply:
+		// DeleteReply is an empty body.
+	}
+}
+
+// runBatchItem runs one item of a batch.
+func runBatchItem(handlers ClusterCommandRpcHandlers, item BatchItem) BatchResult {
+	result := BatchResult{
+		Get:    nil,
+		Set:    nil,
+		Delete: nil,
+		Error:  "",
+		Status: BatchStatusOK,
+	}
+
+	var err error
+	switch {
+	case item.Get != nil && item.Set == nil && item.Delete == nil:
+		item.Get.SetSpanContext(item.Trace)
+		result.Get = &GetReply{}
+		err = handlers.Get(*item.Get, result.Get)
+	case item.Set != nil && item.Get == nil && item.Delete == nil:
+		item.Set.SetSpanContext(item.Trace)
+		result.Set = &SetReply{}
+		err = handlers.Set(*item.Set, result.Set)
+	case item.Delete != nil && item.Get == nil && item.Set == nil:
+		item.Delete.SetSpanContext(item.Trace)
+		result.Delete = &DeleteReply{}
+		err = handlers.Delete(*item.Delete, result.Delete)
+	default:
+		result.Status = BatchStatusInvalid
+		result.Error = "batch item must hold exactly one of get, set or delete args"
+		return result
+	}
+
+	if err != nil {
+		return BatchResult{
+			Get:    nil,
+			Set:    nil,
+			Delete: nil,
+			Error:  err.Error(),
+			Status: BatchStatusFailed,
 		}
-		*reply = deleteReply
+	}
+	return result
+}
+
+// newBatchItem wraps a get, set or delete request for sending in a batch.
+func newBatchItem(request command.Request) (BatchItem, error) {
+	item := BatchItem{
+		Get:    nil,
+		Set:    nil,
+		Delete: nil,
+		Trace:  request.Trace,
+	}
+
+	switch args := request.Args.(type) {
+	case GetArgs:
+		item.Get = &args
+	case SetArgs:
+		item.Set = &args
+	case DeleteArgs:
+		item.Delete = &args
+	default:
+		return item, fmt.Errorf("%s requests cannot be batched", request.Opcode)
+	}
+
+	return item, nil
+}
+
+// BatchItemError is the error of one item of a batch, reported by the node that ran it.
+type BatchItemError struct {
+	Message string
+	Status  BatchStatus
+}
+
+func (self BatchItemError) Error() string {
+	return fmt.Sprintf("batch item %s: %s", self.Status, self.Message)
+}
+
+// runLocalRequest executes a batchable request against this node's key store.
+func runLocalRequest(handlers ClusterCommandRpcHandlers, request command.Request) error {
+	switch args := request.Args.(type) {
+	case GetArgs:
+		args.SetSpanContext(request.Trace)
+		return handlers.Get(args, request.Reply.(*GetReply))
+	case SetArgs:
+		args.SetSpanContext(request.Trace)
+		return handlers.Set(args, request.Reply.(*SetReply))
+	case DeleteArgs:
+		args.SetSpanContext(request.Trace)
+		return handlers.Delete(args, request.Reply.(*DeleteReply))
 	}
 	return nil
 }
 
-func (self *Cluster) runBatch(ctx context.Context, keyRequests []*keyRequest) error {
-	requestsByClient := map[Address][]*command.Request{}
+// runBatch runs each request on the owner of its key and hands every request its own error, so a request that fails
+// does not fail the others in the batch.
+func (self *Cluster) runBatch(ctx context.Context, keyRequests []*keyRequest) {
+	keyRequestsByClient := map[Address][]*keyRequest{}
 
 	handlers := ClusterCommandRpcHandlers{
 		Cluster: self,
@@ -255,59 +562,120 @@ func (self *Cluster) runBatch(ctx context.Context, keyRequests []*keyRequest) er
 		ownerAddress := self.getClosestAddress(keyRequests[index].key)
 
 		if ownerAddress.String() == self.clusterServer.Address() {
-			if err := runBatchRequest(handlers, keyRequests[index].request.Name, keyRequests[index].request.Args, &keyRequests[index].request.Reply); err != nil {
-				return err
-			}
+			keyRequests[index].finish(runLocalRequest(handlers, keyRequests[index].request))
 		} else {
-			requestsByClient[ownerAddress] = append(requestsByClient[ownerAddress], &keyRequests[index].request)
+			keyRequestsByClient[ownerAddress] = append(keyRequestsByClient[ownerAddress], keyRequests[index])
 		}
 	}
 
-	for address, requests := range requestsByClient {
+	for address, clientKeyRequests := range keyRequestsByClient {
+		requests := make([]*command.Request, len(clientKeyRequests))
+		for index := range clientKeyRequests {
+			clientKeyRequests[index].batchSize = len(clientKeyRequests)
+			requests[index] = &clientKeyRequests[index].request
+		}
+
+		var errs []error
 		clientKeyOwner := self.getClientByHostPort(address.Host, address.Port)
-		if clientKeyOwner != nil {
-			response := &BatchReply{}
-			request := newBatchRequest(requests, response)
-			if err := clientKeyOwner.Send(ctx, request); err != nil {
-				return err
-			}
+		if clientKeyOwner == nil {
+			errs = failAll(len(requests), fmt.Errorf("no connection to key owner %s", address.String()))
+		} else {
+			errs = sendBatch(ctx, clientKeyOwner, requests)
+		}
 
-			for index := range requests {
-				commandRequest := &(requests[index])
-
-				switch requests[index].Name {
-				case "ClusterCommandRpcHandlers.Get":
-					valueBytes := response.Responses[index].(map[string]any)["ValueBytes"]
-					if valueBytes != nil {
-						(*commandRequest).Reply.(*GetReply).ValueBytes = valueBytes.([]byte)
-					}
-					(*commandRequest).Reply.(*GetReply).Exists = response.Responses[index].(map[string]any)["Exists"].(bool)
-				case "ClusterCommandRpcHandlers.Set":
-					// SetReply is an empty body.
-				case "ClusterCommandRpcHandlers.Delete":
-					// DeleteReply is an empty body.
-				}
-			}
+		for index := range clientKeyRequests {
+			clientKeyRequests[index].finish(errs[index])
 		}
 	}
+}
 
-	for index := range keyRequests {
-		keyRequests[index].done.Store(true)
+// sendBatch sends the requests to the client in a single batch call and copies each result into the reply of the
+// matching request. It returns the error of each request in order. Requests that failed are left with an empty reply,
+// and every request fails if the call does.
+func sendBatch(ctx context.Context, client *rpc.Client, requests []*command.Request) []error {
+	items := make([]BatchItem, len(requests))
+	errs := make([]error, len(requests))
+	for index := range requests {
+		items[index], errs[index] = newBatchItem(*requests[index])
 	}
 
-	return nil
-}
+	response := &BatchReply{}
+	if err := client.Send(ctx, newBatchRequest(items, response)); err != nil {
+		return failAll(len(requests), err)
+	}
+	if len(response.Results) != len(requests) {
+		return failAll(len(requests), fmt.Errorf("batch of %d requests returned %d results", len(requests), len(response.Results)))
+	}
 
-func sendRequest(cluster *Cluster, request *keyRequest) {
-	cluster.batchChannel <- request
+	for index := range requests {
+		if errs[index] != nil {
+			// The item was sent empty, so the owner reports it as invalid. Keep the reason it could not be batched.
+			continue
+		}
 
-	deadline := time.Now().Add(5 * time.Second)
-	for {
-		if request.done.Load() {
-			break
+		result := response.Results[index]
+		if result.Status != BatchStatusOK {
+			errs[index] = BatchItemError{Message: result.Error, Status: result.Status}
+			continue
 		}
-		if time.Now().After(deadline) {
-			break
+
+		switch reply := requests[index].Reply.(type) {
+		case *GetReply:
+			if result.Get != nil {
+				*reply = *result.Get
+			}
+		case *SetReply:
+			// SetReply is an empty body.
+		case *DeleteReply:
+			// DeleteReply is an empty body.
 		}
 	}
+
+	return errs
+}
+
+// failAll returns the error for each of the requests.
+func failAll(requests int, err error) []error {
+	errs := make([]error, requests)
+	for index := range errs {
+		errs[index] = err
+	}
+	return errs
+}
+
+// sendRequest hands the request to the batcher of the key owner and waits for the batch holding it to finish, or for
+// ctx to be done. It returns the size of the batch the request was sent in, or zero if the request did not finish.
+func sendRequest(ctx context.Context, cluster *Cluster, ownerAddress Address, request *keyRequest) (int, error) {
+	// Draining waits for every request handed to a batcher to finish.
+	cluster.pendingRequests.Add(1)
+	defer cluster.pendingRequests.Add(-1)
+
+	// The batcher stops once the node is closed or the owner leaves.
+	peer := cluster.batcherFor(ownerAddress)
+	select {
+	case peer.batchChannel <- request:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-cluster.ctx.Done():
+		return 0, fmt.Errorf("node is closed: %w", cluster.ctx.Err())
+	case <-peer.ctx.Done():
+		return 0, fmt.Errorf("key owner %s left", ownerAddress.String())
+	}
+
+	timer := time.NewTimer(keyRequestTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-request.done:
+		request.copyReply()
+		return request.batchSize, request.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-cluster.ctx.Done():
+		return 0, fmt.Errorf("node is closed: %w", cluster.ctx.Err())
+	case <-peer.ctx.Done():
+		return 0, fmt.Errorf("key owner %s left", ownerAddress.String())
+	case <-timer.C:
+		return 0, fmt.Errorf("no reply for key %s after %s", request.key, keyRequestTimeout)
+	}
 }
@@ -2,38 +2,38 @@ package cluster_test
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"diskey/pkg/cache"
 	"diskey/pkg/cluster"
+	"diskey/pkg/command"
+	"diskey/pkg/errors/errorstest"
+	"diskey/pkg/rpc"
+	"diskey/pkg/trace"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func waitForCluster(caches ...*cluster.Cluster) {
-	timeout := time.Tick(30 * time.Second)
-	for {
-		select {
-		case <-timeout:
-			panic("timed out waiting for cluster")
-		default:
-		}
-
-		var waiting bool
-		for index := range caches {
-			if caches[index].NumClients() != len(caches)-1 {
-				waiting = true
-				break
-			}
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-		if waiting {
-			time.Sleep(100 * time.Millisecond)
-			continue
+	for index := range caches {
+		if err := caches[index].WaitReady(ctx, len(caches)); err != nil {
+			panic("timed out waiting for cluster: " + err.Error())
 		}
-
-		// fmt.Println("cluster ready")
-		break
 	}
 }
 
@@ -51,11 +51,11 @@ func TestCluster_Set_Get_Delete(t *testing.T) {
 	cache2 := cluster.NewCluster(ctx, "localhost", "7001", cluster.OptionMemberListPort("7951"), cluster.OptionLocalhostDiscovery([]string{"7950", "7951"}))
 	waitForCluster(cache1, cache2)
 
-	value, exists := cluster.Get[MyValue](cache1, "key")
+	value, exists := cluster.Get[MyValue](ctx, cache1, "key")
 	assert.Equal(t, MyValue{}, value)
 	assert.Equal(t, false, exists)
 
-	value, exists = cluster.Get[MyValue](cache2, "key")
+	value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 	assert.Equal(t, MyValue{}, value)
 	assert.Equal(t, false, exists)
 
@@ -66,14 +66,14 @@ func TestCluster_Set_Get_Delete(t *testing.T) {
 			Bar: "test1",
 		}
 
-		err := cluster.Set(cache1, "key", expectedValue)
+		err := cluster.Set(ctx, cache1, "key", expectedValue)
 		assert.NoError(t, err)
 
-		value, exists := cluster.Get[MyValue](cache1, "key")
+		value, exists := cluster.Get[MyValue](ctx, cache1, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 
-		value, exists = cluster.Get[MyValue](cache2, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 	}
@@ -85,14 +85,14 @@ func TestCluster_Set_Get_Delete(t *testing.T) {
 			Bar: "test2",
 		}
 
-		err := cluster.Set(cache2, "key", expectedValue)
+		err := cluster.Set(ctx, cache2, "key", expectedValue)
 		assert.NoError(t, err)
 
-		value, exists := cluster.Get[MyValue](cache1, "key")
+		value, exists := cluster.Get[MyValue](ctx, cache1, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 
-		value, exists = cluster.Get[MyValue](cache2, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 	}
@@ -114,49 +114,1137 @@ func TestCluster_Delete(t *testing.T) {
 
 	// Deletes on cache1.
 	{
-		err := cluster.Set(cache1, "key", expectedValue)
+		err := cluster.Set(ctx, cache1, "key", expectedValue)
 		assert.NoError(t, err)
 
-		value, exists := cluster.Get[MyValue](cache1, "key")
+		value, exists := cluster.Get[MyValue](ctx, cache1, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 
-		value, exists = cluster.Get[MyValue](cache2, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 
-		assert.NoError(t, cluster.Delete(cache1, "key"))
+		assert.NoError(t, cluster.Delete(ctx, cache1, "key"))
 
-		value, exists = cluster.Get[MyValue](cache1, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache1, "key")
 		assert.Equal(t, MyValue{}, value)
 		assert.Equal(t, false, exists)
 
-		value, exists = cluster.Get[MyValue](cache2, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 		assert.Equal(t, MyValue{}, value)
 		assert.Equal(t, false, exists)
 	}
 
 	// Deletes on cache2.
 	{
-		err := cluster.Set(cache1, "key", expectedValue)
+		err := cluster.Set(ctx, cache1, "key", expectedValue)
 		assert.NoError(t, err)
 
-		value, exists := cluster.Get[MyValue](cache1, "key")
+		value, exists := cluster.Get[MyValue](ctx, cache1, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 
-		value, exists = cluster.Get[MyValue](cache2, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 		assert.Equal(t, expectedValue, value)
 		assert.Equal(t, true, exists)
 
-		assert.NoError(t, cluster.Delete(cache2, "key"))
+		assert.NoError(t, cluster.Delete(ctx, cache2, "key"))
 
-		value, exists = cluster.Get[MyValue](cache1, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache1, "key")
 		assert.Equal(t, MyValue{}, value)
 		assert.Equal(t, false, exists)
 
-		value, exists = cluster.Get[MyValue](cache2, "key")
+		value, exists = cluster.Get[MyValue](ctx, cache2, "key")
 		assert.Equal(t, MyValue{}, value)
 		assert.Equal(t, false, exists)
 	}
 }
+
+func TestCluster_MGet_MSet_MDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9100", cluster.OptionMemberListPort("9600"), cluster.OptionLocalhostDiscovery([]string{"9600", "9601"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9101", cluster.OptionMemberListPort("9601"), cluster.OptionLocalhostDiscovery([]string{"9600", "9601"}))
+	waitForCluster(cache1, cache2)
+
+	// Enough keys that both nodes own some of them.
+	keys := make([]string, 20)
+	entries := make([]cluster.Entry[MyValue], len(keys))
+	for index := range keys {
+		keys[index] = "key" + strconv.Itoa(index)
+		entries[index] = cluster.Entry[MyValue]{
+			Key: keys[index],
+			Value: MyValue{
+				Foo: index,
+				Bar: keys[index],
+			},
+		}
+	}
+
+	for _, err := range cluster.MSet(ctx, cache1, entries...) {
+		assert.NoError(t, err)
+	}
+
+	for _, cache := range []*cluster.Cluster{cache1, cache2} {
+		results := cluster.MGet[MyValue](ctx, cache, keys...)
+		assert.Len(t, results, len(keys))
+		for index := range results {
+			assert.NoError(t, results[index].Err)
+			assert.True(t, results[index].Exists)
+			assert.Equal(t, entries[index].Value, results[index].Value)
+		}
+	}
+
+	for _, err := range cluster.MDelete(ctx, cache2, keys[:10]...) {
+		assert.NoError(t, err)
+	}
+
+	results := cluster.MGet[MyValue](ctx, cache1, keys...)
+	for index := range results {
+		assert.NoError(t, results[index].Err)
+		assert.Equal(t, index >= 10, results[index].Exists, keys[index])
+	}
+}
+
+func TestCluster_GetOrLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9102", cluster.OptionMemberListPort("9602"), cluster.OptionLocalhostDiscovery([]string{"9602", "9603"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9103", cluster.OptionMemberListPort("9603"), cluster.OptionLocalhostDiscovery([]string{"9602", "9603"}))
+	waitForCluster(cache1, cache2)
+
+	expectedValue := MyValue{
+		Foo: 10,
+		Bar: "loaded",
+	}
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (MyValue, error) {
+		loads.Add(1)
+		// Slow enough for every caller to miss while the value is being loaded.
+		time.Sleep(100 * time.Millisecond)
+		return expectedValue, nil
+	}
+
+	waitGroup := &sync.WaitGroup{}
+	for index := range 20 {
+		waitGroup.Add(1)
+		go func(cache *cluster.Cluster) {
+			defer waitGroup.Done()
+
+			value, err := cluster.GetOrLoad(ctx, cache, "key", time.Minute, loader)
+			assert.NoError(t, err)
+			assert.Equal(t, expectedValue, value)
+		}([]*cluster.Cluster{cache1, cache2}[index%2])
+	}
+	waitGroup.Wait()
+
+	assert.Equal(t, int32(1), loads.Load())
+
+	value, exists := cluster.Get[MyValue](ctx, cache1, "key")
+	assert.True(t, exists)
+	assert.Equal(t, expectedValue, value)
+}
+
+func TestCluster_GetOrLoad_loader_error(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9104", cluster.OptionMemberListPort("9604"), cluster.OptionLocalhostDiscovery([]string{"9604"}))
+
+	loadErr := errors.New("load failed")
+	_, err := cluster.GetOrLoad(ctx, cache1, "key", time.Minute, func(ctx context.Context) (MyValue, error) {
+		return MyValue{}, loadErr
+	})
+	assert.ErrorIs(t, err, loadErr)
+
+	// The failed loader released its lease, so the next caller loads right away.
+	value, err := cluster.GetOrLoad(ctx, cache1, "key", time.Minute, func(ctx context.Context) (MyValue, error) {
+		return MyValue{Foo: 1}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, MyValue{Foo: 1}, value)
+}
+
+func TestCluster_GetOrLoad_caller_cancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9136", cluster.OptionMemberListPort("9636"), cluster.OptionLocalhostDiscovery([]string{"9636"}))
+
+	var loads atomic.Int32
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (MyValue, error) {
+		loads.Add(1)
+		started <- struct{}{}
+		<-release
+		return MyValue{Foo: 1}, ctx.Err()
+	}
+
+	// The first caller gives up while its load is running. Callers waiting on the same load still get the value.
+	firstCtx, firstCancel := context.WithCancel(ctx)
+	firstErr := make(chan error)
+	go func() {
+		_, err := cluster.GetOrLoad(firstCtx, cache1, "key", time.Minute, loader)
+		firstErr <- err
+	}()
+	<-started
+
+	secondValue := make(chan MyValue)
+	go func() {
+		value, err := cluster.GetOrLoad(ctx, cache1, "key", time.Minute, loader)
+		assert.NoError(t, err)
+		secondValue <- value
+	}()
+
+	// Give the second caller time to join the load.
+	time.Sleep(50 * time.Millisecond)
+	firstCancel()
+	assert.ErrorIs(t, <-firstErr, context.Canceled)
+
+	close(release)
+	assert.Equal(t, MyValue{Foo: 1}, <-secondValue)
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestCluster_GetOrLoad_loader_cancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9142", cluster.OptionMemberListPort("9642"), cluster.OptionLocalhostDiscovery([]string{"9642", "9643"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9143", cluster.OptionMemberListPort("9643"), cluster.OptionLocalhostDiscovery([]string{"9642", "9643"}))
+	waitForCluster(cache1, cache2)
+
+	key := ""
+	for index := 0; key == ""; index++ {
+		if candidate := "loader-cancelled:" + strconv.Itoa(index); cache1.OwnerAddress(candidate) == cache2.Address() {
+			key = candidate
+		}
+	}
+
+	// The caller gives up while loading, which fails the load with a cancelled context.
+	loadCtx, loadCancel := context.WithCancel(ctx)
+	_, err := cluster.GetOrLoad(loadCtx, cache1, key, time.Minute, func(ctx context.Context) (MyValue, error) {
+		loadCancel()
+		<-ctx.Done()
+		return MyValue{}, ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The lease was still released on the owner, so the next caller loads right away.
+	nextCtx, nextCancel := context.WithTimeout(ctx, time.Second)
+	defer nextCancel()
+	value, err := cluster.GetOrLoad(nextCtx, cache1, key, time.Minute, func(ctx context.Context) (MyValue, error) {
+		return MyValue{Foo: 1}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, MyValue{Foo: 1}, value)
+}
+
+func TestCluster_Scan(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9105", cluster.OptionMemberListPort("9605"), cluster.OptionLocalhostDiscovery([]string{"9605", "9606"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9106", cluster.OptionMemberListPort("9606"), cluster.OptionLocalhostDiscovery([]string{"9605", "9606"}))
+	waitForCluster(cache1, cache2)
+
+	expectedKeys := []string{}
+	for index := range 30 {
+		key := "scan:" + strconv.Itoa(index)
+		expectedKeys = append(expectedKeys, key)
+		assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: index}))
+		assert.NoError(t, cluster.Set(ctx, cache1, "other:"+strconv.Itoa(index), MyValue{Foo: index}))
+	}
+
+	scannedKeys := []string{}
+	scanner := cluster.NewScanner(cache2, "scan:*", 7)
+	for scanner.Next(ctx) {
+		assert.LessOrEqual(t, len(scanner.Keys()), 7)
+		scannedKeys = append(scannedKeys, scanner.Keys()...)
+	}
+	assert.NoError(t, scanner.Err())
+	assert.ElementsMatch(t, expectedKeys, scannedKeys)
+
+	keys, cursor, err := cache1.ScanPage(ctx, "scan:1?", "", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "", cursor)
+	assert.ElementsMatch(t, []string{"scan:10", "scan:11", "scan:12", "scan:13", "scan:14", "scan:15", "scan:16", "scan:17", "scan:18", "scan:19"}, keys)
+
+	// A cursor holds the position of each node by address, so it can be resumed from any node.
+	keys, cursor, err = cache1.ScanPage(ctx, "scan:*", "", 10)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cursor)
+	for cursor != "" {
+		var page []string
+		page, cursor, err = cache2.ScanPage(ctx, "scan:*", cursor, 10)
+		assert.NoError(t, err)
+		keys = append(keys, page...)
+	}
+	assert.ElementsMatch(t, expectedKeys, keys)
+
+	// A page stops early when its node examined too many keys without a match, rather than walk the whole key store.
+	keys, cursor, err = cache1.ScanPage(ctx, "missing:*", "", 1)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.NotEmpty(t, cursor)
+	for cursor != "" {
+		var page []string
+		page, cursor, err = cache1.ScanPage(ctx, "missing:*", cursor, 1)
+		assert.NoError(t, err)
+		assert.Empty(t, page)
+	}
+
+	_, _, err = cache1.ScanPage(ctx, "scan:*", "not a cursor", 10)
+	assert.ErrorIs(t, err, cluster.ErrInvalidCursor)
+}
+
+func TestCluster_DeleteByPattern(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9107", cluster.OptionMemberListPort("9607"), cluster.OptionLocalhostDiscovery([]string{"9607", "9608"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9108", cluster.OptionMemberListPort("9608"), cluster.OptionLocalhostDiscovery([]string{"9607", "9608"}))
+	waitForCluster(cache1, cache2)
+
+	for index := range 20 {
+		assert.NoError(t, cluster.Set(ctx, cache1, "user:123:"+strconv.Itoa(index), MyValue{Foo: index}))
+		assert.NoError(t, cluster.Set(ctx, cache1, "user:456:"+strconv.Itoa(index), MyValue{Foo: index}))
+		assert.NoError(t, cluster.Set(ctx, cache1, "{session:1}:"+strconv.Itoa(index), MyValue{Foo: index}))
+	}
+
+	deleted, err := cluster.DeleteByPrefix(ctx, cache2, "user:123:")
+	assert.NoError(t, err)
+	assert.Equal(t, 20, deleted)
+
+	deleted, err = cluster.DeleteByPattern(ctx, cache2, "{session:1}:1?")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, deleted)
+
+	for index := range 20 {
+		_, exists := cluster.Get[MyValue](ctx, cache1, "user:123:"+strconv.Itoa(index))
+		assert.False(t, exists)
+
+		value, exists := cluster.Get[MyValue](ctx, cache1, "user:456:"+strconv.Itoa(index))
+		assert.True(t, exists)
+		assert.Equal(t, index, value.Foo)
+
+		_, exists = cluster.Get[MyValue](ctx, cache1, "{session:1}:"+strconv.Itoa(index))
+		assert.Equal(t, index < 10, exists)
+	}
+}
+
+func TestCluster_Watch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9109", cluster.OptionMemberListPort("9609"), cluster.OptionLocalhostDiscovery([]string{"9609", "9610"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9110", cluster.OptionMemberListPort("9610"), cluster.OptionLocalhostDiscovery([]string{"9609", "9610"}))
+	waitForCluster(cache1, cache2)
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	events, err := cluster.Watch(watchCtx, cache2, "watch:*")
+	assert.NoError(t, err)
+
+	// Keys are spread over both nodes, so events arrive both locally and from the other node.
+	expected := []cluster.Event{}
+	for index := range 10 {
+		key := "watch:" + strconv.Itoa(index)
+		assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: index}))
+		assert.NoError(t, cluster.Set(ctx, cache1, "ignored:"+strconv.Itoa(index), MyValue{Foo: index}))
+		assert.NoError(t, cluster.Delete(ctx, cache1, key))
+		expected = append(expected, cluster.Event{Key: key, Type: cluster.EventSet}, cluster.Event{Key: key, Type: cluster.EventDeleted})
+	}
+
+	assert.NoError(t, cluster.SetWithTTL(ctx, cache1, "watch:expiring", MyValue{Foo: 1}, time.Millisecond))
+	expected = append(expected, cluster.Event{Key: "watch:expiring", Type: cluster.EventSet}, cluster.Event{Key: "watch:expiring", Type: cluster.EventExpired})
+	time.Sleep(5 * time.Millisecond)
+	_, exists := cluster.Get[MyValue](ctx, cache1, "watch:expiring")
+	assert.False(t, exists)
+
+	received := []cluster.Event{}
+	timeout := time.After(5 * time.Second)
+	for len(received) < len(expected) {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-timeout:
+			t.Fatalf("received %d of %d events", len(received), len(expected))
+		}
+	}
+	assert.ElementsMatch(t, expected, received)
+
+	stopWatching()
+	for range events {
+		// Wait for the channel to close.
+	}
+}
+
+func TestCluster_Publish_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9111", cluster.OptionMemberListPort("9611"), cluster.OptionLocalhostDiscovery([]string{"9611", "9612"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9112", cluster.OptionMemberListPort("9612"), cluster.OptionLocalhostDiscovery([]string{"9611", "9612"}))
+	waitForCluster(cache1, cache2)
+
+	subscription := cluster.Subscribe(ctx, cache2, "invalidate:user")
+	patternSubscription := cluster.PSubscribe(ctx, cache1, "invalidate:*")
+	slowSubscription := cluster.Subscribe(ctx, cache2, "invalidate:user", cluster.SubscribeOptionBufferSize(1))
+
+	receivers, err := cluster.Publish(ctx, cache1, "invalidate:user", []byte("123"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, receivers)
+
+	receivers, err = cluster.Publish(ctx, cache2, "invalidate:order", []byte("456"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, receivers)
+
+	receivers, err = cluster.Publish(ctx, cache1, "invalidate:user", []byte("789"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, receivers)
+
+	assert.Equal(t, cluster.Message{Channel: "invalidate:user", Payload: []byte("123")}, <-subscription.Messages())
+	assert.Equal(t, cluster.Message{Channel: "invalidate:user", Payload: []byte("789")}, <-subscription.Messages())
+
+	assert.Equal(t, cluster.Message{Channel: "invalidate:user", Payload: []byte("123")}, <-patternSubscription.Messages())
+	assert.Equal(t, cluster.Message{Channel: "invalidate:order", Payload: []byte("456")}, <-patternSubscription.Messages())
+	assert.Equal(t, cluster.Message{Channel: "invalidate:user", Payload: []byte("789")}, <-patternSubscription.Messages())
+
+	assert.Equal(t, cluster.Message{Channel: "invalidate:user", Payload: []byte("123")}, <-slowSubscription.Messages())
+	assert.Equal(t, uint64(1), slowSubscription.Dropped())
+
+	cancel()
+	for range subscription.Messages() {
+		// Wait for the subscription to close.
+	}
+}
+
+func TestCluster_Publish_blocking_subscriber(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9137", cluster.OptionMemberListPort("9637"), cluster.OptionLocalhostDiscovery([]string{"9637"}))
+
+	blockingCtx, cancelBlocking := context.WithCancel(ctx)
+	blockingSubscription := cluster.Subscribe(blockingCtx, cache1, "events", cluster.SubscribeOptionBufferSize(1), cluster.SubscribeOptionBlock(time.Hour))
+
+	receivers, err := cluster.Publish(ctx, cache1, "events", []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, receivers)
+
+	published := make(chan int)
+	go func() {
+		receivers, err := cluster.Publish(ctx, cache1, "events", []byte("2"))
+		assert.NoError(t, err)
+		published <- receivers
+	}()
+
+	// Subscribing and unsubscribing are not held up by the publish waiting for room in the full buffer.
+	otherCtx, cancelOther := context.WithCancel(ctx)
+	otherSubscription := cluster.Subscribe(otherCtx, cache1, "other")
+	cancelOther()
+	for range otherSubscription.Messages() {
+		// Wait for the subscription to close.
+	}
+
+	// Unsubscribing stops the waiting delivery.
+	cancelBlocking()
+	assert.Equal(t, 0, <-published)
+	for range blockingSubscription.Messages() {
+		// Wait for the subscription to close.
+	}
+}
+
+func TestCluster_NearCache(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9113", cluster.OptionMemberListPort("9613"), cluster.OptionLocalhostDiscovery([]string{"9613", "9614"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9114", cluster.OptionMemberListPort("9614"), cluster.OptionLocalhostDiscovery([]string{"9613", "9614"}))
+	waitForCluster(cache1, cache2)
+
+	nearCache, err := cluster.NewNearCache(cache2, "near:")
+	assert.NoError(t, err)
+
+	// Use a key owned by the other node so that misses make a round trip.
+	key := "near:0"
+	for index := 1; cache2.OwnerAddress(key) != cache1.Address(); index++ {
+		key = "near:" + strconv.Itoa(index)
+	}
+
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 1}))
+
+	// Reads made before the event from the set arrives are not cached, in case the event was for a newer value.
+	var value MyValue
+	assert.Eventually(t, func() bool {
+		valueBytes, exists, err := nearCache.GetBytes(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.NoError(t, cache.UnmarshalValue(valueBytes, &value))
+		assert.Equal(t, 1, value.Foo)
+		return nearCache.Stats().Hits > 0
+	}, 5*time.Second, time.Millisecond)
+
+	// A write on the owner invalidates the near cache.
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 2}))
+	assert.Eventually(t, func() bool {
+		return nearCache.Stats().Invalidations == 1
+	}, 5*time.Second, time.Millisecond)
+
+	valueBytes, exists, err := nearCache.GetBytes(ctx, key)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.NoError(t, cache.UnmarshalValue(valueBytes, &value))
+	assert.Equal(t, 2, value.Foo)
+
+	// The near cache never serves a key past its ttl.
+	assert.NoError(t, cluster.SetWithTTL(ctx, cache1, key, MyValue{Foo: 3}, time.Second))
+	assert.Eventually(t, func() bool {
+		valueBytes, exists, err := nearCache.GetBytes(ctx, key)
+		return err == nil && exists && cache.UnmarshalValue(valueBytes, &value) == nil && value.Foo == 3
+	}, 5*time.Second, time.Millisecond)
+	time.Sleep(time.Second)
+	_, exists, err = nearCache.GetBytes(ctx, key)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// A closed near cache stops caching, so every read goes to the owner.
+	nearCache.Close()
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 4}))
+	hits := nearCache.Stats().Hits
+	for range 2 {
+		valueBytes, exists, err = nearCache.GetBytes(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.NoError(t, cache.UnmarshalValue(valueBytes, &value))
+		assert.Equal(t, 4, value.Foo)
+	}
+	assert.Equal(t, hits, nearCache.Stats().Hits)
+}
+
+func TestCluster_HotKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9115", cluster.OptionMemberListPort("9615"), cluster.OptionLocalhostDiscovery([]string{"9615", "9616"}), cluster.OptionHotKeyReplication(10, 2*time.Second))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9116", cluster.OptionMemberListPort("9616"), cluster.OptionLocalhostDiscovery([]string{"9615", "9616"}), cluster.OptionHotKeyReplication(10, 2*time.Second))
+	waitForCluster(cache1, cache2)
+
+	// Use a key owned by the first node so that the second node reads it remotely.
+	key := "hot:0"
+	for index := 1; cache1.OwnerAddress(key) != cache1.Address(); index++ {
+		key = "hot:" + strconv.Itoa(index)
+	}
+
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 1}))
+	assert.NoError(t, cluster.Set(ctx, cache1, "cold", MyValue{Foo: 1}))
+	for range 50 {
+		_, exists := cluster.Get[MyValue](ctx, cache1, key)
+		assert.True(t, exists)
+	}
+	_, _ = cluster.Get[MyValue](ctx, cache1, "cold")
+
+	hotKeys, err := cluster.HotKeys(ctx, cache2, 1)
+	assert.NoError(t, err)
+	assert.Len(t, hotKeys, 1)
+	assert.Equal(t, key, hotKeys[0].Key)
+	assert.GreaterOrEqual(t, hotKeys[0].Count, uint64(50))
+
+	// Once the hot key is copied to the second node, its reads no longer reach the owner.
+	assert.Eventually(t, func() bool {
+		before := cache1.LocalHotKeys(1)[0].Count
+		for range 10 {
+			value, exists := cluster.Get[MyValue](ctx, cache2, key)
+			assert.True(t, exists)
+			assert.Equal(t, 1, value.Foo)
+		}
+		return cache1.LocalHotKeys(1)[0].Count <= before
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// Changing the key drops the copies long before they expire.
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 2}))
+	assert.Eventually(t, func() bool {
+		value, exists := cluster.Get[MyValue](ctx, cache2, key)
+		return exists && value.Foo == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCluster_metrics(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9117", cluster.OptionMemberListPort("9617"), cluster.OptionLocalhostDiscovery([]string{"9617", "9618"}), cluster.OptionHTTPAddress("localhost:9800"))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9118", cluster.OptionMemberListPort("9618"), cluster.OptionLocalhostDiscovery([]string{"9617", "9618"}))
+	waitForCluster(cache1, cache2)
+
+	// Use a key owned by the other node so that the request is batched and sent over rpc.
+	key := "metrics:0"
+	for index := 1; cache1.OwnerAddress(key) != cache2.Address(); index++ {
+		key = "metrics:" + strconv.Itoa(index)
+	}
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 1}))
+	_, exists := cluster.Get[MyValue](ctx, cache1, key)
+	assert.True(t, exists)
+
+	response, err := http.Get("http://localhost:9800/metrics")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, string(body), "# TYPE diskey_cache_hits_total counter\n")
+	assert.Contains(t, string(body), "diskey_cluster_members 2\n")
+	assert.Contains(t, string(body), "diskey_cluster_joins_total 1\n")
+	assert.Contains(t, string(body), `diskey_rpc_client_calls_total{peer="localhost:9118",method="Batch"} 2`+"\n")
+	assert.Contains(t, string(body), `diskey_batch_size_count{peer="localhost:9118"} 2`+"\n")
+	assert.Contains(t, string(body), `diskey_batch_wait_seconds_count{peer="localhost:9118"} 2`+"\n")
+	assert.Regexp(t, `diskey_cluster_owned_slots \d+\n`, string(body))
+}
+
+func TestCluster_tracing(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exporter1 := trace.NewInMemoryExporter()
+	exporter2 := trace.NewInMemoryExporter()
+	cache1 := cluster.NewCluster(ctx, "localhost", "9119", cluster.OptionMemberListPort("9619"), cluster.OptionLocalhostDiscovery([]string{"9619", "9620"}), cluster.OptionTracer(trace.NewTracer(exporter1)))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9120", cluster.OptionMemberListPort("9620"), cluster.OptionLocalhostDiscovery([]string{"9619", "9620"}), cluster.OptionTracer(trace.NewTracer(exporter2)))
+	waitForCluster(cache1, cache2)
+
+	// Use a key owned by the first node so that the second node sends the request over rpc.
+	key := "trace:0"
+	for index := 1; cache1.OwnerAddress(key) != cache1.Address(); index++ {
+		key = "trace:" + strconv.Itoa(index)
+	}
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 1}))
+
+	requestCtx, requestSpan := trace.NewTracer(trace.NewInMemoryExporter()).Start(ctx, "request", trace.SpanKindServer)
+	_, exists := cluster.Get[MyValue](requestCtx, cache2, key)
+	assert.True(t, exists)
+
+	// Spans are exported in the background, so wait for the ones of the request's trace.
+	spansOf := func(exporter *trace.InMemoryExporter) []trace.SpanData {
+		spans := []trace.SpanData{}
+		for _, span := range exporter.Spans() {
+			if span.SpanContext.TraceID == requestSpan.SpanContext().TraceID {
+				spans = append(spans, span)
+			}
+		}
+		return spans
+	}
+	assert.Eventually(t, func() bool {
+		return len(spansOf(exporter1)) == 1 && len(spansOf(exporter2)) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	callerSpans := spansOf(exporter2)
+	assert.Len(t, callerSpans, 1)
+	assert.Equal(t, "Get", callerSpans[0].Name)
+	assert.Equal(t, trace.SpanKindClient, callerSpans[0].Kind)
+	assert.Equal(t, requestSpan.SpanContext().SpanID, callerSpans[0].ParentSpanID)
+	assert.Equal(t, key, callerSpans[0].Attributes["key"])
+
+	// The owner continues the trace of the caller.
+	ownerSpans := spansOf(exporter1)
+	assert.Len(t, ownerSpans, 1)
+	assert.Equal(t, "Get", ownerSpans[0].Name)
+	assert.Equal(t, trace.SpanKindServer, ownerSpans[0].Kind)
+	assert.Equal(t, callerSpans[0].SpanContext.SpanID, ownerSpans[0].ParentSpanID)
+}
+
+func TestCluster_SlowLog(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A threshold of zero records every operation.
+	cache1 := cluster.NewCluster(ctx, "localhost", "9121", cluster.OptionMemberListPort("9621"), cluster.OptionLocalhostDiscovery([]string{"9621", "9622"}), cluster.OptionSlowLog(0, 2))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9122", cluster.OptionMemberListPort("9622"), cluster.OptionLocalhostDiscovery([]string{"9621", "9622"}), cluster.OptionSlowLog(0, 2))
+	waitForCluster(cache1, cache2)
+
+	// Use a key owned by the first node so that the second node sends the request over rpc.
+	key := "slow:0"
+	for index := 1; cache1.OwnerAddress(key) != cache1.Address(); index++ {
+		key = "slow:" + strconv.Itoa(index)
+	}
+	assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: 1}))
+	_, exists := cluster.Get[MyValue](ctx, cache2, key)
+	assert.True(t, exists)
+
+	callerEntries := cache2.LocalSlowLog(0)
+	assert.Len(t, callerEntries, 1)
+	assert.Equal(t, "Get", callerEntries[0].Command)
+	assert.Equal(t, key, callerEntries[0].Key)
+	assert.Equal(t, cache1.Address().String(), callerEntries[0].Peer)
+	assert.Equal(t, 1, callerEntries[0].BatchSize)
+	assert.Positive(t, callerEntries[0].Duration)
+
+	// The owner recorded the set and the get, newest first, and is read over rpc.
+	ownerEntries, err := cluster.NodeSlowLog(ctx, cache2, cache1.Address(), 0)
+	assert.NoError(t, err)
+	assert.Len(t, ownerEntries, 2)
+	assert.Equal(t, "Get", ownerEntries[0].Command)
+	assert.Equal(t, "Set", ownerEntries[1].Command)
+	assert.Empty(t, ownerEntries[0].Peer)
+	assert.Equal(t, ownerEntries[1].ID+1, ownerEntries[0].ID)
+
+	// Once full, the oldest entries are overwritten.
+	assert.NoError(t, cluster.Delete(ctx, cache1, key))
+	ownerEntries = cache1.LocalSlowLog(0)
+	assert.Len(t, ownerEntries, 2)
+	assert.Equal(t, "Delete", ownerEntries[0].Command)
+	assert.Equal(t, "Get", ownerEntries[1].Command)
+	assert.Len(t, cache1.LocalSlowLog(1), 1)
+
+	cache1.ResetSlowLog()
+	assert.Empty(t, cache1.LocalSlowLog(0))
+}
+
+func TestCluster_Ready(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	snapshotLoaded := &atomic.Bool{}
+	cache1 := cluster.NewCluster(ctx, "localhost", "9123", cluster.OptionMemberListPort("9623"), cluster.OptionLocalhostDiscovery([]string{"9623", "9624"}), cluster.OptionHTTPAddress("localhost:9801"),
+		cluster.OptionReadyMembers(2),
+		cluster.OptionReadinessCheck("snapshot", func() error {
+			if !snapshotLoaded.Load() {
+				return errors.New("not loaded")
+			}
+			return nil
+		}),
+	)
+
+	err := cache1.Ready()
+	assert.ErrorContains(t, err, "members: 1 of 2 joined")
+	assert.ErrorContains(t, err, "snapshot: not loaded")
+
+	getStatus := func(path string) (int, string) {
+		response, err := http.Get("http://localhost:9801" + path)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		assert.NoError(t, err)
+		return response.StatusCode, string(body)
+	}
+
+	// The node is alive long before it is ready.
+	status, _ := getStatus("/healthz")
+	assert.Equal(t, http.StatusOK, status)
+	status, body := getStatus("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Contains(t, body, "snapshot: not loaded")
+
+	cache2 := cluster.NewCluster(ctx, "localhost", "9124", cluster.OptionMemberListPort("9624"), cluster.OptionLocalhostDiscovery([]string{"9623", "9624"}))
+	snapshotLoaded.Store(true)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 30*time.Second)
+	defer waitCancel()
+	assert.NoError(t, cache1.WaitReady(waitCtx, 2))
+	assert.NoError(t, cache2.WaitReady(waitCtx, 2))
+
+	status, body = getStatus("/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok\n", body)
+
+	// Waiting gives up with the reasons the node is not ready.
+	expiredCtx, expiredCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer expiredCancel()
+	err = cache2.WaitReady(expiredCtx, 3)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "members: 2 of 3 joined")
+}
+
+func TestCluster_Drain(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9125", cluster.OptionMemberListPort("9625"), cluster.OptionLocalhostDiscovery([]string{"9625", "9626"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9126", cluster.OptionMemberListPort("9626"), cluster.OptionLocalhostDiscovery([]string{"9625", "9626"}))
+	waitForCluster(cache1, cache2)
+
+	// Store keys on the node that drains.
+	keys := []string{}
+	for index := 0; len(keys) < 20; index++ {
+		key := "drain:" + strconv.Itoa(index)
+		if cache2.OwnerAddress(key) == cache2.Address() {
+			keys = append(keys, key)
+		}
+	}
+	for index := range keys {
+		assert.NoError(t, cluster.SetWithTTL(ctx, cache2, keys[index], MyValue{Foo: index}, time.Minute))
+	}
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer drainCancel()
+	assert.NoError(t, cache2.Drain(drainCtx))
+	assert.ErrorContains(t, cache2.Ready(), "draining")
+	assert.Error(t, cache2.Drain(drainCtx))
+
+	// The remaining node owns every key and has a copy of each.
+	assert.NoError(t, cache1.WaitReady(drainCtx, 1))
+	for index := range keys {
+		assert.Equal(t, cache1.Address(), cache1.OwnerAddress(keys[index]))
+
+		value, exists := cluster.Get[MyValue](ctx, cache1, keys[index])
+		assert.True(t, exists)
+		assert.Equal(t, index, value.Foo)
+	}
+}
+
+func TestCluster_Drain_delete(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9138", cluster.OptionMemberListPort("9638"), cluster.OptionLocalhostDiscovery([]string{"9638", "9639"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9139", cluster.OptionMemberListPort("9639"), cluster.OptionLocalhostDiscovery([]string{"9638", "9639"}))
+	waitForCluster(cache1, cache2)
+
+	// Enough keys on the node that drains for the migration to take several batches.
+	keys := []string{}
+	for index := 0; len(keys) < 3000; index++ {
+		key := "drain-delete:" + strconv.Itoa(index)
+		if cache2.OwnerAddress(key) == cache2.Address() {
+			keys = append(keys, key)
+		}
+	}
+	for index := range keys {
+		assert.NoError(t, cluster.SetWithTTL(ctx, cache2, keys[index], MyValue{Foo: index}, time.Minute))
+	}
+
+	// Delete the keys while they are being migrated, through the node that does not drain. Deletes reach the draining
+	// node until it is known to be leaving, and the new owner afterwards.
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		for index := len(keys) - 1; index >= 0; index-- {
+			assert.NoError(t, cluster.Delete(ctx, cache1, keys[index]))
+		}
+	}()
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer drainCancel()
+	assert.NoError(t, cache2.Drain(drainCtx))
+	<-deleted
+
+	// No deleted key is brought back by the migration.
+	for index := range keys {
+		_, exists := cluster.Get[MyValue](ctx, cache1, keys[index])
+		assert.False(t, exists, keys[index])
+	}
+}
+
+// Not parallel so that no other test starts goroutines while counting them.
+func TestCluster_Close(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	goroutines := runtime.NumGoroutine()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9127", cluster.OptionMemberListPort("9627"), cluster.OptionLocalhostDiscovery([]string{"9627", "9628"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9128", cluster.OptionMemberListPort("9628"), cluster.OptionLocalhostDiscovery([]string{"9627", "9628"}))
+	waitForCluster(cache1, cache2)
+
+	for index := 0; index < 10; index++ {
+		key := "close:" + strconv.Itoa(index)
+		assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: index}))
+
+		value, exists := cluster.Get[MyValue](ctx, cache2, key)
+		assert.True(t, exists)
+		assert.Equal(t, index, value.Foo)
+	}
+
+	assert.NoError(t, cache1.Close())
+	assert.NoError(t, cache2.Close())
+	assert.NoError(t, cache2.Close())
+
+	// Polled here rather than with assert.Eventually, which checks from a goroutine of its own.
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "goroutines leaked")
+}
+
+func TestCluster_TransportGRPC(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Nodes connect to each other with the transport the other node advertises, so a cluster can mix transports.
+	cache1 := cluster.NewCluster(ctx, "localhost", "9129", cluster.OptionMemberListPort("9629"), cluster.OptionLocalhostDiscovery([]string{"9629", "9630"}), cluster.OptionTransport(rpc.TransportGRPC))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9130", cluster.OptionMemberListPort("9630"), cluster.OptionLocalhostDiscovery([]string{"9629", "9630"}))
+	waitForCluster(cache1, cache2)
+
+	for index := 0; index < 10; index++ {
+		key := "grpc:" + strconv.Itoa(index)
+		assert.NoError(t, cluster.Set(ctx, cache1, key, MyValue{Foo: index}))
+
+		value, exists := cluster.Get[MyValue](ctx, cache2, key)
+		assert.True(t, exists)
+		assert.Equal(t, index, value.Foo)
+	}
+
+	// Callers that are not nodes use the Cache service of a node serving gRPC.
+	clientConnection, err := grpc.NewClient("localhost:9129", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer clientConnection.Close()
+	cacheClient := command.NewCacheClient(clientConnection)
+
+	valueBytes, err := cache.MarshalValue(MyValue{Foo: 100})
+	assert.NoError(t, err)
+	for index := 0; index < 10; index++ {
+		_, err := cacheClient.Set(ctx, &command.SetArgs{Key: "grpc:service:" + strconv.Itoa(index), Value: valueBytes, Ttl: durationpb.New(time.Minute)})
+		assert.NoError(t, err)
+	}
+
+	getReply, err := cacheClient.Get(ctx, &command.GetArgs{Key: "grpc:3"})
+	assert.NoError(t, err)
+	assert.True(t, getReply.GetExists())
+	var value MyValue
+	assert.NoError(t, cache.UnmarshalValue(getReply.GetValue(), &value))
+	assert.Equal(t, 3, value.Foo)
+
+	value, exists := cluster.Get[MyValue](ctx, cache2, "grpc:service:7")
+	assert.True(t, exists)
+	assert.Equal(t, 100, value.Foo)
+
+	batchReply, err := cacheClient.Batch(ctx, &command.BatchArgs{Items: []*command.BatchItem{
+		{Args: &command.BatchItem_Get{Get: &command.GetArgs{Key: "grpc:service:1"}}},
+		{Args: &command.BatchItem_Delete{Delete: &command.DeleteArgs{Key: "grpc:service:1"}}},
+		{Args: nil},
+		{Args: &command.BatchItem_Get{Get: &command.GetArgs{Key: "grpc:service:1"}}},
+	}})
+	assert.NoError(t, err)
+	assert.Len(t, batchReply.GetResults(), 4)
+	assert.True(t, batchReply.GetResults()[0].GetGet().GetExists())
+	assert.NotNil(t, batchReply.GetResults()[1].GetDelete())
+	assert.Contains(t, batchReply.GetResults()[2].GetError(), "no args")
+	assert.False(t, batchReply.GetResults()[3].GetGet().GetExists())
+
+	// Streamed items are answered in order, without waiting for the stream to end.
+	stream, err := cacheClient.BatchStream(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&command.BatchItem{Args: &command.BatchItem_Get{Get: &command.GetArgs{Key: "grpc:service:2"}}}))
+	result, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.True(t, result.GetGet().GetExists())
+
+	assert.NoError(t, stream.Send(&command.BatchItem{Args: &command.BatchItem_Delete{Delete: &command.DeleteArgs{Key: "grpc:service:2"}}}))
+	assert.NoError(t, stream.Send(&command.BatchItem{Args: nil}))
+	assert.NoError(t, stream.Send(&command.BatchItem{Args: &command.BatchItem_Get{Get: &command.GetArgs{Key: "grpc:service:2"}}}))
+	assert.NoError(t, stream.CloseSend())
+
+	results := []*command.BatchResult{}
+	for {
+		result, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		results = append(results, result)
+	}
+	assert.Len(t, results, 3)
+	assert.NotNil(t, results[0].GetDelete())
+	assert.Contains(t, results[1].GetError(), "no args")
+	assert.False(t, results[2].GetGet().GetExists())
+}
+
+func TestCluster_Batch(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node := cluster.NewCluster(ctx, "localhost", "9131", cluster.OptionMemberListPort("9631"), cluster.OptionLocalhostDiscovery([]string{"9631"}))
+	waitForCluster(node)
+
+	client := rpc.NewClient("localhost", "9131")
+	errorstest.NoError(t, client.Connect(ctx))
+	defer client.Disconnect(ctx)
+
+	valueBytes, err := cache.MarshalValue(MyValue{Foo: 1})
+	assert.NoError(t, err)
+
+	// An invalid item fails on its own and the items around it still run.
+	reply := &cluster.BatchReply{}
+	assert.NoError(t, client.Send(ctx, command.Request{
+		Opcode: command.OpcodeBatch,
+		Args: cluster.BatchArgs{Items: []cluster.BatchItem{
+			{Set: &cluster.SetArgs{Key: "batch", ValueBytes: valueBytes, TTL: time.Minute}},
+			{},
+			{Get: &cluster.GetArgs{Key: "batch"}, Delete: &cluster.DeleteArgs{Key: "batch"}},
+			{Get: &cluster.GetArgs{Key: "batch"}},
+			{Delete: &cluster.DeleteArgs{Key: "batch"}},
+			{Get: &cluster.GetArgs{Key: "batch"}},
+		}},
+		Reply: reply,
+	}))
+
+	assert.Len(t, reply.Results, 6)
+	assert.Equal(t, cluster.BatchStatusOK, reply.Results[0].Status)
+	assert.NotNil(t, reply.Results[0].Set)
+	assert.Equal(t, cluster.BatchStatusInvalid, reply.Results[1].Status)
+	assert.NotEmpty(t, reply.Results[1].Error)
+	assert.Equal(t, cluster.BatchStatusInvalid, reply.Results[2].Status)
+	assert.Equal(t, cluster.BatchStatusOK, reply.Results[3].Status)
+	assert.True(t, reply.Results[3].Get.Exists)
+	assert.Equal(t, valueBytes, reply.Results[3].Get.ValueBytes)
+	assert.Equal(t, cluster.BatchStatusOK, reply.Results[4].Status)
+	assert.Equal(t, cluster.BatchStatusOK, reply.Results[5].Status)
+	assert.False(t, reply.Results[5].Get.Exists)
+}
+
+func TestCluster_Batch_itemErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cache1 := cluster.NewCluster(ctx, "localhost", "9132", cluster.OptionMemberListPort("9632"), cluster.OptionLocalhostDiscovery([]string{"9632", "9633"}))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9133", cluster.OptionMemberListPort("9633"), cluster.OptionLocalhostDiscovery([]string{"9632", "9633"}))
+	waitForCluster(cache1, cache2)
+
+	keys := []string{}
+	for index := 0; len(keys) < 10; index++ {
+		key := "items:" + strconv.Itoa(index)
+		if cache1.OwnerAddress(key) == cache2.Address() {
+			keys = append(keys, key)
+		}
+	}
+
+	// The oversized value does not fit in a shard of the owner's key store. Its caller sees the error while the
+	// callers batched with it succeed.
+	oversized := make([]byte, 2<<20)
+	errs := make([]error, len(keys))
+	waitGroup := sync.WaitGroup{}
+	for index := range keys {
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+
+			valueBytes := []byte("value")
+			if index == 0 {
+				valueBytes = oversized
+			}
+			errs[index] = cache1.SetBytes(ctx, keys[index], valueBytes, time.Minute)
+		}(index)
+	}
+	waitGroup.Wait()
+
+	var itemErr cluster.BatchItemError
+	assert.ErrorAs(t, errs[0], &itemErr)
+	assert.Equal(t, cluster.BatchStatusFailed, itemErr.Status)
+	for index := 1; index < len(keys); index++ {
+		assert.NoError(t, errs[index])
+
+		valueBytes, exists, err := cache1.GetBytes(ctx, keys[index])
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte("value"), valueBytes)
+	}
+}
+
+func TestCluster_batching(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The linger gives every set time to reach the batcher, so batches are only cut short by the batch size.
+	cache1 := cluster.NewCluster(ctx, "localhost", "9134", cluster.OptionMemberListPort("9634"), cluster.OptionLocalhostDiscovery([]string{"9634", "9635"}),
+		cluster.OptionBatchSize(4), cluster.OptionBatchLinger(200*time.Millisecond), cluster.OptionBatchMaxBytes(1<<20), cluster.OptionBatchConcurrency(1))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9135", cluster.OptionMemberListPort("9635"), cluster.OptionLocalhostDiscovery([]string{"9634", "9635"}))
+	waitForCluster(cache1, cache2)
+
+	keys := []string{}
+	for index := 0; len(keys) < 10; index++ {
+		key := "batching:" + strconv.Itoa(index)
+		if cache1.OwnerAddress(key) == cache2.Address() {
+			keys = append(keys, key)
+		}
+	}
+
+	waitGroup := sync.WaitGroup{}
+	for index := range keys {
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+			assert.NoError(t, cluster.Set(ctx, cache1, keys[index], MyValue{Foo: index}))
+		}(index)
+	}
+	waitGroup.Wait()
+
+	for index := range keys {
+		value, exists := cluster.Get[MyValue](ctx, cache2, keys[index])
+		assert.True(t, exists)
+		assert.Equal(t, index, value.Foo)
+	}
+
+	metricsText := strings.Builder{}
+	assert.NoError(t, cache1.Metrics().WriteText(&metricsText))
+	assert.Contains(t, metricsText.String(), `diskey_batch_size_count{peer="localhost:9135"} 3`+"\n")
+	assert.Contains(t, metricsText.String(), `diskey_batch_size_sum{peer="localhost:9135"} 10`+"\n")
+}
+
+func TestCluster_batching_caller_cancelled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The linger holds every request in the batcher for longer than the caller waits.
+	cache1 := cluster.NewCluster(ctx, "localhost", "9140", cluster.OptionMemberListPort("9640"), cluster.OptionLocalhostDiscovery([]string{"9640", "9641"}),
+		cluster.OptionBatchSize(100), cluster.OptionBatchLinger(time.Second))
+	cache2 := cluster.NewCluster(ctx, "localhost", "9141", cluster.OptionMemberListPort("9641"), cluster.OptionLocalhostDiscovery([]string{"9640", "9641"}))
+	waitForCluster(cache1, cache2)
+
+	key := ""
+	for index := 0; key == ""; index++ {
+		if candidate := "cancelled:" + strconv.Itoa(index); cache1.OwnerAddress(candidate) == cache2.Address() {
+			key = candidate
+		}
+	}
+
+	callCtx, callCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer callCancel()
+
+	start := time.Now()
+	_, _, err := cache1.GetBytes(callCtx, key)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"diskey/pkg/cluster/internal/glob"
+	"diskey/pkg/command"
+)
+
+type DeletePatternArgs struct {
+	Pattern string
+}
+
+type DeletePatternReply struct {
+	Deleted int
+}
+
+func (self ClusterCommandRpcHandlers) DeletePattern(args DeletePatternArgs, reply *DeletePatternReply) error {
+	deleted, err := self.deletePatternLocal(args.Pattern)
+	reply.Deleted = deleted
+	return err
+}
+
+func newDeletePatternRequest(args DeletePatternArgs, resp *DeletePatternReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeDeletePattern,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// deletePatternLocal deletes every key on this node matching the pattern and returns how many were deleted.
+func (self *Cluster) deletePatternLocal(pattern string) (int, error) {
+	prefix := glob.Prefix(pattern)
+
+	// Collect the keys first so that the deletes do not shift entries under the iterator.
+	keys := []string{}
+	self.keyStore.Range(func(key string, _ []byte) bool {
+		if strings.HasPrefix(key, prefix) && glob.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: self,
+	}
+
+	deleted := 0
+	for index := range keys {
+		if err := handlers.Delete(DeleteArgs{Key: keys[index]}, &DeleteReply{}); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// DeleteByPrefix deletes every key in the cluster starting with the prefix. Returns the number of keys deleted.
+func DeleteByPrefix(ctx context.Context, cluster *Cluster, prefix string) (int, error) {
+	return DeleteByPattern(ctx, cluster, glob.QuoteMeta(prefix)+"*")
+}
+
+// DeleteByPattern deletes every key in the cluster matching the glob pattern. Returns the number of keys deleted.
+//
+// The pattern is sent to every node, which each delete their own matching keys. When the literal start of the pattern
+// holds a complete hash tag, such as "{user:123}:*", every matching key lives in the same hash slot and only the owner
+// of that slot is asked. If some nodes fail, the keys deleted by the other nodes are still counted and the errors are
+// joined.
+func DeleteByPattern(ctx context.Context, cluster *Cluster, pattern string) (int, error) {
+	addresses := cluster.sortedAddresses()
+	if tagKey, pinned := hashTagKey(glob.Prefix(pattern)); pinned {
+		addresses = []Address{cluster.getClosestAddress(tagKey)}
+	}
+
+	deleted := make([]int, len(addresses))
+	errs := make([]error, len(addresses))
+
+	waitGroup := &sync.WaitGroup{}
+	for index := range addresses {
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+			deleted[index], errs[index] = cluster.deletePatternOnNode(ctx, addresses[index], pattern)
+		}(index)
+	}
+	waitGroup.Wait()
+
+	total := 0
+	for index := range deleted {
+		total += deleted[index]
+	}
+
+	return total, errors.Join(errs...)
+}
+
+func (self *Cluster) deletePatternOnNode(ctx context.Context, address Address, pattern string) (int, error) {
+	if address.String() == self.clusterServer.Address() {
+		return self.deletePatternLocal(pattern)
+	}
+
+	client := self.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return 0, fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	reply := &DeletePatternReply{}
+	if err := client.Send(ctx, newDeletePatternRequest(DeletePatternArgs{Pattern: pattern}, reply)); err != nil {
+		return 0, err
+	}
+
+	return reply.Deleted, nil
+}
+
+// hashTagKey reports whether the literal prefix pins every matching key to one hash slot. That is the case when the
+// prefix contains the first, non empty, "{...}" of the key. Returns the prefix up to the end of the tag, which hashes
+// to the same slot as any key sharing the prefix.
+func hashTagKey(prefix string) (string, bool) {
+	begin := strings.IndexByte(prefix, '{')
+	if begin < 0 {
+		return "", false
+	}
+
+	end := strings.IndexByte(prefix[begin+1:], '}')
+	if end <= 0 {
+		return "", false
+	}
+
+	return prefix[:begin+1+end+1], true
+}
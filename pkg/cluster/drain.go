@@ -0,0 +1,283 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/command"
+	"diskey/pkg/rpc"
+)
+
+const (
+	// migrateBatchSize bounds how many keys are sent to a new owner in one call.
+	migrateBatchSize = 1000
+	// leaveTimeout bounds how long gossip about this node leaving may take.
+	leaveTimeout = time.Second
+	// serverShutdownTimeout bounds how long calls in flight on the rpc server may take to finish when closing.
+	serverShutdownTimeout = 5 * time.Second
+
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// Drain removes the node from the cluster without losing its keys:
+//
+//  1. The node is marked as leaving in gossip, so other nodes stop routing keys to it.
+//  2. The node stops owning hash slots. Writes that still reach it are forwarded to the new owners.
+//  3. Its keys are copied to their new owners, along with the time they have left to live. Keys written to the new
+//     owner in the meantime are not overwritten, and keys deleted there in the meantime are not brought back. The
+//     fencing tokens of its locks are handed over too.
+//  4. Requests already handed to the batchers are allowed to finish.
+//  5. The node leaves the memberlist, stops its listeners and background work, and disconnects from its peers.
+//
+// A node that is alone has nowhere to send its keys and only shuts down. The node is closed after draining, even when
+// an error is returned for keys that failed to migrate.
+func (self *Cluster) Drain(ctx context.Context) error {
+	if !self.draining.CompareAndSwap(false, true) {
+		return errors.New("node is already draining")
+	}
+
+	address := self.Address()
+
+	metadata, err := json.Marshal(clusterMetadata{
+		Host:      address.Host,
+		Port:      address.Port,
+		Leaving:   true,
+		Transport: self.transport,
+	})
+	if err != nil {
+		return err
+	}
+	if err := self.memberList.UpdateMetadata(metadata, leaveTimeout); err != nil {
+		// Nodes that miss the update keep sending requests here, which are forwarded until the node leaves.
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to gossip that the node is leaving")
+	}
+
+	errs := []error{}
+
+	if len(self.sortedAddresses()) > 1 {
+		self.removeAddress(address.Host, address.Port)
+
+		migrated, err := self.migrateKeys(ctx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		log.Ctx(ctx).Info().Int("keys", migrated).Msg("migrated keys to new owners")
+
+		self.syncLockTokens(ctx)
+	}
+
+	if err := self.waitForPendingRequests(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := self.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// migrateKeys copies every key on this node to its new owner and returns how many keys were copied.
+func (self *Cluster) migrateKeys(ctx context.Context) (int, error) {
+	keysByOwner := map[Address][]string{}
+	self.keyStore.Range(func(key string, _ []byte) bool {
+		owner := self.getClosestAddress(key)
+		keysByOwner[owner] = append(keysByOwner[owner], key)
+		return true
+	})
+
+	migrated := 0
+	errs := []error{}
+	for owner, keys := range keysByOwner {
+		client := self.getClientByHostPort(owner.Host, owner.Port)
+		if client == nil {
+			errs = append(errs, fmt.Errorf("no connection to node %s", owner.String()))
+			continue
+		}
+
+		for start := 0; start < len(keys); start += migrateBatchSize {
+			count, err := self.migrateBatch(ctx, client, keys[start:min(start+migrateBatchSize, len(keys))])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to migrate %d keys to %s: %w", count, owner.String(), err))
+				continue
+			}
+			migrated += count
+		}
+	}
+
+	return migrated, errors.Join(errs...)
+}
+
+// migrateBatch reads the keys and copies them to their new owner. Writes forwarded while draining wait for the batch
+// to be copied, so that they reach the new owner after it, and the keys they dropped are not read into the batch.
+func (self *Cluster) migrateBatch(ctx context.Context, client *rpc.Client, keys []string) (int, error) {
+	self.migrateMutex.Lock()
+	defer self.migrateMutex.Unlock()
+
+	now := time.Now()
+	entries := make([]ReplicaEntry, 0, len(keys))
+	for _, key := range keys {
+		valueBytes, expiresAt, err := self.keyStore.GetWithExpiry(key)
+		if err != nil {
+			// The key expired or was removed since ranging.
+			continue
+		}
+
+		var ttl time.Duration
+		if !expiresAt.IsZero() {
+			ttl = expiresAt.Sub(now)
+			if ttl <= 0 {
+				continue
+			}
+		}
+
+		entries = append(entries, ReplicaEntry{
+			Key:        key,
+			ValueBytes: valueBytes,
+			TTL:        ttl,
+		})
+	}
+
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	return len(entries), client.Send(ctx, newMigrateRequest(MigrateArgs{Entries: entries}, &MigrateReply{}))
+}
+
+// dropWhileDraining removes the key from a draining node before a write to it is forwarded to the new owner. It waits
+// for a batch being migrated, which may hold the old value, to reach the new owner first.
+func (self *Cluster) dropWhileDraining(key string) {
+	self.migrateMutex.Lock()
+	defer self.migrateMutex.Unlock()
+
+	_ = self.keyStore.Delete(key)
+}
+
+// drainTombstones remembers the keys deleted on this node while other nodes drain, so that the copies migrated by
+// a draining node do not bring them back. Tombstones are kept until no node is draining anymore.
+type drainTombstones struct {
+	draining map[string]struct{}
+	keys     map[string]struct{}
+	mutex    sync.Mutex
+}
+
+func newDrainTombstones() *drainTombstones {
+	return &drainTombstones{
+		draining: map[string]struct{}{},
+		keys:     map[string]struct{}{},
+		mutex:    sync.Mutex{},
+	}
+}
+
+// start records deletes from now on, until the node at the address left.
+func (self *drainTombstones) start(address Address) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.draining[address.String()] = struct{}{}
+}
+
+// stop stops recording deletes for the node at the address, dropping the tombstones once no node is draining.
+func (self *drainTombstones) stop(address Address) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	delete(self.draining, address.String())
+	if len(self.draining) == 0 {
+		clear(self.keys)
+	}
+}
+
+func (self *drainTombstones) record(key string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if len(self.draining) > 0 {
+		self.keys[key] = struct{}{}
+	}
+}
+
+func (self *drainTombstones) deleted(key string) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	_, exists := self.keys[key]
+	return exists
+}
+
+// waitForPendingRequests waits until every request handed to a batcher has finished.
+func (self *Cluster) waitForPendingRequests(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for self.pendingRequests.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d pending requests: %w", self.pendingRequests.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+type MigrateArgs struct {
+	Entries []ReplicaEntry
+}
+
+type MigrateReply struct{}
+
+// Migrate receives keys from a draining node. Keys that already exist here were written after this node took over
+// their slots, so they are newer and kept. Keys deleted here since are not brought back.
+func (self ClusterCommandRpcHandlers) Migrate(args MigrateArgs, reply *MigrateReply) error {
+	for index := range args.Entries {
+		entry := args.Entries[index]
+
+		if _, err := self.keyStore.Get(entry.Key); err == nil || self.tombstones.deleted(entry.Key) {
+			continue
+		}
+
+		if err := self.keyStore.SetWithTTL(entry.Key, entry.ValueBytes, entry.TTL); err != nil {
+			return err
+		}
+
+		enqueueEvent(self.events, Event{Key: entry.Key, Type: EventSet})
+	}
+
+	return nil
+}
+
+func newMigrateRequest(args MigrateArgs, resp *MigrateReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeMigrate,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// forwardWhileDraining sends a request that reached this node after it stopped owning hash slots to the new owner of
+// the key. It reports false when the request should run here instead.
+func (self ClusterCommandRpcHandlers) forwardWhileDraining(ctx context.Context, key string, request command.Request) (bool, error) {
+	if !self.draining.Load() {
+		return false, nil
+	}
+
+	ownerAddress := self.getClosestAddress(key)
+	if ownerAddress.String() == self.clusterServer.Address() {
+		return false, nil
+	}
+
+	client := self.getClientByHostPort(ownerAddress.Host, ownerAddress.Port)
+	if client == nil {
+		return true, fmt.Errorf("no connection to key owner %s", ownerAddress.String())
+	}
+
+	return true, client.Send(ctx, request)
+}
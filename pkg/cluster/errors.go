@@ -74,3 +74,27 @@ func (self KeyOwnerError) String() string {
 		return "KeyOwnerError"
 	}
 }
+
+type LockError uint
+
+const (
+	LockErrorBlankName = LockError(iota + 1)
+	LockErrorHeld
+	LockErrorLost
+	LockErrorOwnerUnavailable
+)
+
+func (self LockError) String() string {
+	switch self {
+	case LockErrorBlankName:
+		return "BlankName"
+	case LockErrorHeld:
+		return "Held"
+	case LockErrorLost:
+		return "Lost"
+	case LockErrorOwnerUnavailable:
+		return "OwnerUnavailable"
+	default:
+		return "LockError"
+	}
+}
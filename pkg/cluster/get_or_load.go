@@ -0,0 +1,277 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/cache"
+	"diskey/pkg/command"
+)
+
+const (
+	// loadLeaseTTL bounds how long other callers wait on a loader that never finishes, for example because the
+	// node running it died. After it expires, the next caller is granted the lease and runs its own loader.
+	loadLeaseTTL = 10 * time.Second
+	// releaseLoadLeaseTimeout bounds how long releasing a lease after a failed load waits for the key owner.
+	releaseLoadLeaseTimeout = time.Second
+
+	minLoadWaitInterval = time.Millisecond
+	maxLoadWaitInterval = 100 * time.Millisecond
+)
+
+type flight struct {
+	done       chan struct{}
+	cancel     context.CancelFunc
+	err        error
+	valueBytes []byte
+	// waiters counts the callers waiting on the flight. The load is cancelled once all of them gave up.
+	waiters int
+}
+
+// flightGroup coalesces concurrent loads of the same key on this node so that only one of them goes to the key
+// owner. The others wait for its result.
+type flightGroup struct {
+	flights map[string]*flight
+	mutex   sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: map[string]*flight{},
+		mutex:   sync.Mutex{},
+	}
+}
+
+// do runs the load once for all concurrent callers of the key. The load runs on a context of its own, so a caller
+// giving up only fails that caller. The load is cancelled once every caller gave up.
+func (self *flightGroup) do(ctx context.Context, key string, load func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	self.mutex.Lock()
+	inFlight, exists := self.flights[key]
+	if !exists {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		inFlight = &flight{
+			done:       make(chan struct{}),
+			cancel:     cancel,
+			err:        nil,
+			valueBytes: nil,
+			waiters:    0,
+		}
+		self.flights[key] = inFlight
+
+		go func() {
+			defer cancel()
+
+			inFlight.valueBytes, inFlight.err = load(loadCtx)
+
+			self.mutex.Lock()
+			if self.flights[key] == inFlight {
+				delete(self.flights, key)
+			}
+			self.mutex.Unlock()
+			close(inFlight.done)
+		}()
+	}
+	inFlight.waiters++
+	self.mutex.Unlock()
+
+	select {
+	case <-inFlight.done:
+		return inFlight.valueBytes, inFlight.err
+	case <-ctx.Done():
+		self.mutex.Lock()
+		inFlight.waiters--
+		if inFlight.waiters == 0 {
+			// Callers arriving from now on start a load of their own.
+			if self.flights[key] == inFlight {
+				delete(self.flights, key)
+			}
+			inFlight.cancel()
+		}
+		self.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+type GetOrLeaseArgs struct {
+	Key      string
+	Holder   string
+	LeaseTTL time.Duration
+}
+
+type GetOrLeaseReply struct {
+	ValueBytes []byte
+	Token      uint64
+	Exists     bool
+	Leased     bool
+}
+
+// GetOrLease returns the value if it exists. Otherwise, the first caller is granted a lease to load the value while
+// everyone else is told to wait for it.
+func (self ClusterCommandRpcHandlers) GetOrLease(args GetOrLeaseArgs, reply *GetOrLeaseReply) error {
+	getReply := &GetReply{}
+	if err := self.Get(GetArgs{Key: args.Key}, getReply); err != nil {
+		return err
+	}
+
+	if getReply.Exists {
+		reply.ValueBytes = getReply.ValueBytes
+		reply.Exists = true
+		return nil
+	}
+
+	// Check again while granting the lease, since a loader may have set the key and ended its lease since the get.
+	reply.Token, reply.Leased = self.loads.acquireIf(args.Key, args.Holder, args.LeaseTTL, func() bool {
+		valueBytes, err := self.keyStore.Get(args.Key)
+		if err != nil {
+			return true
+		}
+		reply.ValueBytes = valueBytes
+		reply.Exists = true
+		return false
+	})
+
+	return nil
+}
+
+func newGetOrLeaseRequest(args GetOrLeaseArgs, resp *GetOrLeaseReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeGetOrLease,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+func (self ClusterCommandRpcHandlers) ReleaseLoadLease(args ReleaseLockArgs, reply *ReleaseLockReply) error {
+	reply.Released = self.loads.release(args.Name, args.Holder, args.Token)
+	return nil
+}
+
+func newReleaseLoadLeaseRequest(args ReleaseLockArgs, resp *ReleaseLockReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeReleaseLoadLease,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// GetOrLoad gets the key, calling the loader and setting the key with the ttl if the key does not exist.
+//
+// Concurrent misses for the same key are coalesced across the whole cluster. Callers on the same node share a single
+// request to the key owner, and the key owner grants a loading lease to only one node at a time. Every other caller
+// waits for the loaded value to be set instead of running its own loader, which keeps hot keys from stampeding the
+// backing store whenever they expire.
+func GetOrLoad[T cache.Value](ctx context.Context, cluster *Cluster, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	var value T
+
+	valueBytes, err := cluster.flights.do(ctx, key, func(ctx context.Context) ([]byte, error) {
+		return cluster.getOrLoad(ctx, key, ttl, func(ctx context.Context) ([]byte, error) {
+			loadedValue, err := loader(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return cache.MarshalValueWith(cluster.encoding, loadedValue)
+		})
+	})
+	if err != nil {
+		return value, err
+	}
+
+	if err := cache.UnmarshalValueWith(cluster.encoding, valueBytes, &value); err != nil {
+		return value, err
+	}
+
+	return value, nil
+}
+
+func (self *Cluster) getOrLoad(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: self,
+	}
+
+	args := GetOrLeaseArgs{
+		Key:      key,
+		Holder:   Uuid(),
+		LeaseTTL: loadLeaseTTL,
+	}
+
+	waitInterval := minLoadWaitInterval
+	for {
+		reply := &GetOrLeaseReply{}
+		if err := self.sendToOwner(ctx, key, newGetOrLeaseRequest(args, reply), func() error {
+			return handlers.GetOrLease(args, reply)
+		}); err != nil {
+			return nil, err
+		}
+
+		if reply.Exists {
+			return reply.ValueBytes, nil
+		}
+
+		if reply.Leased {
+			return self.load(ctx, key, ttl, args.Holder, reply.Token, loader)
+		}
+
+		// Someone else is loading the value. Back off until it is set or their lease runs out.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(waitInterval):
+		}
+
+		waitInterval = min(2*waitInterval, maxLoadWaitInterval)
+	}
+}
+
+// load runs the loader while holding the loading lease. Setting the loaded value ends the lease on the owner. If the
+// loader or the set fails, the lease is released early so that the next waiter can try its own loader.
+func (self *Cluster) load(ctx context.Context, key string, ttl time.Duration, holder string, token uint64, loader func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: self,
+	}
+
+	valueBytes, err := loader(ctx)
+	if err != nil {
+		self.releaseLoadLease(ctx, key, holder, token)
+		return nil, err
+	}
+
+	setArgs := SetArgs{
+		Key:        key,
+		ValueBytes: valueBytes,
+		TTL:        ttl,
+	}
+	if err := self.sendToOwner(ctx, key, newSetRequest(key, valueBytes, ttl, &SetReply{}), func() error {
+		return handlers.Set(setArgs, &SetReply{})
+	}); err != nil {
+		self.releaseLoadLease(ctx, key, holder, token)
+		return nil, err
+	}
+
+	return valueBytes, nil
+}
+
+// releaseLoadLease ends the loading lease of the key. The load may have failed because ctx is done, so the release
+// runs on a context of its own. Otherwise the waiters would poll until the lease runs out.
+func (self *Cluster) releaseLoadLease(ctx context.Context, key string, holder string, token uint64) {
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: self,
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseLoadLeaseTimeout)
+	defer cancel()
+
+	releaseArgs := ReleaseLockArgs{
+		Name:   key,
+		Holder: holder,
+		Token:  token,
+	}
+	releaseReply := &ReleaseLockReply{}
+	if err := self.sendToOwner(ctx, key, newReleaseLoadLeaseRequest(releaseArgs, releaseReply), func() error {
+		return handlers.ReleaseLoadLease(releaseArgs, releaseReply)
+	}); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("key", key).Msg("failed to release load lease")
+	}
+}
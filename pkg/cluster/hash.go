@@ -41,17 +41,51 @@ func (self Address) String() string {
 	return self.Host + ":" + self.Port
 }
 
+// OwnerAddress returns the address of the node that currently owns the key.
+func (self *Cluster) OwnerAddress(key string) Address {
+	return self.getClosestAddress(key)
+}
+
+// Address returns the server to server address of this node.
+func (self *Cluster) Address() Address {
+	return Address{
+		Host: self.clusterServer.Host(),
+		Port: self.clusterServer.Port(),
+		Slot: Slot(self.clusterServer.Address()),
+	}
+}
+
 func (self *Cluster) getClosestAddress(key string) Address {
-	keySlot := int(Slot(key))
+	self.clientsMutex.RLock()
+	defer self.clientsMutex.RUnlock()
+
+	return closestAddressToSlot(self.addresses, Slot(key))
+}
+
+// ownedSlots counts the hash slots this node owns.
+func (self *Cluster) ownedSlots() int {
+	self.clientsMutex.RLock()
+	defer self.clientsMutex.RUnlock()
+
+	owned := 0
+	for slot := range MaxHashSlot {
+		if closestAddressToSlot(self.addresses, slot).String() == self.clusterServer.Address() {
+			owned++
+		}
+	}
+
+	return owned
+}
+
+func closestAddressToSlot(addresses []Address, slot HashSlot) Address {
+	keySlot := int(slot)
 
 	var closestAddress Address
 	var closestAddressSlot int
 	var closestDistance int
 
-	self.clientsMutex.RLock()
-	defer self.clientsMutex.RUnlock()
-	for index := range self.addresses {
-		addressSlot := int(self.addresses[index].Slot)
+	for index := range addresses {
+		addressSlot := int(addresses[index].Slot)
 
 		// Calculate the "wraparound" distance. For example 0 and 16384 are distance 1 from each other.
 		distance := abs(keySlot - addressSlot)
@@ -60,7 +94,7 @@ func (self *Cluster) getClosestAddress(key string) Address {
 		}
 
 		if closestAddress.Host == "" && closestAddress.Port == "" {
-			closestAddress = self.addresses[index]
+			closestAddress = addresses[index]
 			closestAddressSlot = addressSlot
 			closestDistance = distance
 			continue
@@ -70,13 +104,13 @@ func (self *Cluster) getClosestAddress(key string) Address {
 			if distance == closestDistance {
 				// Lowest address hash slot wins.
 				if addressSlot < closestAddressSlot {
-					closestAddress = self.addresses[index]
+					closestAddress = addresses[index]
 					closestAddressSlot = addressSlot
 					closestDistance = distance
 				}
 				continue
 			}
-			closestAddress = self.addresses[index]
+			closestAddress = addresses[index]
 			closestAddressSlot = addressSlot
 			closestDistance = distance
 		}
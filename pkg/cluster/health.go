@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const readyPollInterval = 50 * time.Millisecond
+
+// ReadinessCheck reports why a node is not ready to serve, or nil once it is.
+type ReadinessCheck func() error
+
+type readinessCheck struct {
+	check ReadinessCheck
+	name  string
+}
+
+// OptionReadinessCheck adds a check that must pass before the node is ready, such as loading a snapshot.
+func OptionReadinessCheck(name string, check ReadinessCheck) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.readinessChecks = append(clusterClient.readinessChecks, readinessCheck{
+			check: check,
+			name:  name,
+		})
+	}
+}
+
+// OptionReadyMembers holds off readiness until the node sees at least the number of members, including itself.
+// Defaults to one, so a node that finds no other nodes serves alone.
+func OptionReadyMembers(members int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.readyMembers = members
+	}
+}
+
+// Ready returns nil when the node is ready to serve, or an error for every reason it is not. A node is ready once it
+// sees enough members, is connected to every member, and routes keys to exactly those members.
+func (self *Cluster) Ready() error {
+	errs := []error{}
+
+	if self.draining.Load() {
+		errs = append(errs, errors.New("draining: node is leaving the cluster"))
+	}
+
+	members := self.memberAddresses()
+	if len(members) < self.readyMembers {
+		errs = append(errs, fmt.Errorf("members: %d of %d joined", len(members), self.readyMembers))
+	}
+
+	for _, address := range members {
+		if address.String() == self.clusterServer.Address() {
+			continue
+		}
+		if client := self.getClientByHostPort(address.Host, address.Port); client == nil || !client.Connected() {
+			errs = append(errs, fmt.Errorf("peers: not connected to %s", address.String()))
+		}
+	}
+
+	// Keys are routed by the addresses, so they must converge on the members before every node agrees on the owners.
+	routed := self.sortedAddresses()
+	if !slices.EqualFunc(routed, members, func(routedAddress Address, member Address) bool {
+		return routedAddress.String() == member.String()
+	}) {
+		errs = append(errs, fmt.Errorf("slots: routing to %d nodes, %d members", len(routed), len(members)))
+	}
+
+	for index := range self.readinessChecks {
+		if err := self.readinessChecks[index].check(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", self.readinessChecks[index].name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// memberAddresses returns the rpc addresses of the memberlist members that are not leaving, sorted like
+// sortedAddresses.
+func (self *Cluster) memberAddresses() []Address {
+	nodes := self.memberList.Members()
+
+	addresses := make([]Address, 0, len(nodes))
+	for index := range nodes {
+		var metadata clusterMetadata
+		if err := json.Unmarshal(nodes[index].Meta, &metadata); err != nil || metadata.Leaving {
+			continue
+		}
+		addresses = append(addresses, Address{
+			Host: metadata.Host,
+			Port: metadata.Port,
+			Slot: Slot(metadata.Host + ":" + metadata.Port),
+		})
+	}
+
+	slices.SortFunc(addresses, func(a Address, b Address) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	return addresses
+}
+
+// WaitReady waits until the node is ready and sees at least the number of members, including itself. It returns the
+// reasons the node is not ready if the context is done first.
+func (self *Cluster) WaitReady(ctx context.Context, members int) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := self.Ready()
+		if err == nil && len(self.memberAddresses()) < members {
+			err = fmt.Errorf("members: %d of %d joined", len(self.memberAddresses()), members)
+		}
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleHealthz reports that the node is alive. It does not depend on other nodes, so a failing peer never gets a
+// healthy node restarted.
+func (self *Cluster) handleHealthz(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = writer.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the node is ready to serve, and why not when it is not.
+func (self *Cluster) handleReadyz(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err := self.Ready(); err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = writer.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	_, _ = writer.Write([]byte("ok\n"))
+}
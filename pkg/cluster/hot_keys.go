@@ -0,0 +1,488 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/cluster/internal/lru"
+	"diskey/pkg/cluster/internal/sketch"
+	"diskey/pkg/command"
+)
+
+const (
+	hotKeySketchWidth = 4096
+	hotKeySketchDepth = 4
+	// hotKeyCandidates is how many of the most read keys are tracked by name.
+	hotKeyCandidates = 100
+	// hotKeyDecayInterval is how often counts are halved, so that counts reflect recent reads.
+	hotKeyDecayInterval = 10 * time.Second
+	// maxReplicas bounds how many copies of other nodes' hot keys a node holds.
+	maxReplicas = 1000
+)
+
+// OptionHotKeyReplication pushes read only copies of hot keys to every other node, which then serve reads of those
+// keys locally for up to the ttl. A key is hot once it is read at least threshold times within roughly the last ten
+// seconds.
+//
+// Copies are refreshed while the key stays hot and dropped as soon as the key changes on its owner. A short ttl bounds
+// how stale a copy can be if a drop is lost.
+func OptionHotKeyReplication(threshold uint64, ttl time.Duration) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.hotKeyThreshold = threshold
+		clusterClient.replicaTTL = ttl
+	}
+}
+
+// HotKey is a key and an estimate of how often it was read recently.
+type HotKey struct {
+	Key   string
+	Count uint64
+}
+
+// hotKeyTracker counts reads of the keys this node owns and keeps track of the most read ones.
+//
+// Reads are counted without locking. Only a read that may move a key into the candidates takes the lock, which in
+// the steady state is rare: candidates are found in a snapshot, and other keys are skipped while their count is not
+// above the smallest count among the candidates.
+type hotKeyTracker struct {
+	countMin *sketch.CountMin
+	// candidates is a snapshot of the tracked keys, replaced whenever they change.
+	candidates atomic.Pointer[map[string]struct{}]
+	// threshold is the count a key must exceed to become a candidate once there are hotKeyCandidates of them.
+	threshold atomic.Uint64
+	mutex     sync.Mutex
+}
+
+func newHotKeyTracker() *hotKeyTracker {
+	tracker := &hotKeyTracker{
+		countMin:   sketch.NewCountMin(hotKeySketchWidth, hotKeySketchDepth),
+		candidates: atomic.Pointer[map[string]struct{}]{},
+		threshold:  atomic.Uint64{},
+		mutex:      sync.Mutex{},
+	}
+	tracker.candidates.Store(&map[string]struct{}{})
+	return tracker
+}
+
+func (self *hotKeyTracker) add(key string) {
+	count := self.countMin.Add(key)
+
+	if _, exists := (*self.candidates.Load())[key]; exists || count <= self.threshold.Load() {
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	current := *self.candidates.Load()
+	if _, exists := current[key]; exists {
+		return
+	}
+
+	counts := self.counts(current)
+	if len(counts) >= hotKeyCandidates {
+		minKey, minCount := minHotKey(counts)
+		if count <= minCount {
+			self.threshold.Store(minCount)
+			return
+		}
+		delete(counts, minKey)
+	}
+	counts[key] = count
+
+	self.publish(counts)
+}
+
+// counts estimates the count of every candidate. Must be called with the mutex held.
+func (self *hotKeyTracker) counts(candidates map[string]struct{}) map[string]uint64 {
+	counts := make(map[string]uint64, len(candidates)+1)
+	for key := range candidates {
+		counts[key] = self.countMin.Estimate(key)
+	}
+	return counts
+}
+
+// publish replaces the candidates and the threshold. Must be called with the mutex held.
+func (self *hotKeyTracker) publish(counts map[string]uint64) {
+	candidates := make(map[string]struct{}, len(counts))
+	for key := range counts {
+		candidates[key] = struct{}{}
+	}
+
+	threshold := uint64(0)
+	if len(counts) >= hotKeyCandidates {
+		_, threshold = minHotKey(counts)
+	}
+
+	self.candidates.Store(&candidates)
+	self.threshold.Store(threshold)
+}
+
+func minHotKey(counts map[string]uint64) (string, uint64) {
+	minKey := ""
+	minCount := uint64(0)
+	for key, count := range counts {
+		if minKey == "" || count < minCount {
+			minKey = key
+			minCount = count
+		}
+	}
+	return minKey, minCount
+}
+
+func (self *hotKeyTracker) decay() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.countMin.Halve()
+
+	counts := self.counts(*self.candidates.Load())
+	for key, count := range counts {
+		if count == 0 {
+			delete(counts, key)
+		}
+	}
+	self.publish(counts)
+}
+
+// hotKeys returns up to limit of the most read keys, most read first.
+func (self *hotKeyTracker) hotKeys(limit int) []HotKey {
+	candidates := *self.candidates.Load()
+
+	hotKeys := make([]HotKey, 0, len(candidates))
+	for key := range candidates {
+		hotKeys = append(hotKeys, HotKey{
+			Key:   key,
+			Count: self.countMin.Estimate(key),
+		})
+	}
+
+	sortHotKeys(hotKeys)
+
+	return hotKeys[:min(limit, len(hotKeys))]
+}
+
+func sortHotKeys(hotKeys []HotKey) {
+	slices.SortFunc(hotKeys, func(a HotKey, b HotKey) int {
+		if a.Count != b.Count {
+			if a.Count > b.Count {
+				return -1
+			}
+			return 1
+		}
+		if a.Key < b.Key {
+			return -1
+		}
+		if a.Key > b.Key {
+			return 1
+		}
+		return 0
+	})
+}
+
+type replica struct {
+	expiresAt  time.Time
+	valueBytes []byte
+}
+
+// replicaStore holds copies of hot keys owned by other nodes.
+type replicaStore struct {
+	entries *lru.Cache[string, replica]
+	mutex   sync.Mutex
+}
+
+func newReplicaStore() *replicaStore {
+	return &replicaStore{
+		entries: lru.New[string, replica](maxReplicas),
+		mutex:   sync.Mutex{},
+	}
+}
+
+func (self *replicaStore) get(key string) (replica, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	entry, exists := self.entries.Get(key)
+	if !exists {
+		return replica{}, false
+	}
+
+	if !time.Now().Before(entry.expiresAt) {
+		self.entries.Remove(key)
+		return replica{}, false
+	}
+
+	return entry, true
+}
+
+func (self *replicaStore) put(key string, entry replica) {
+	self.mutex.Lock()
+	self.entries.Add(key, entry)
+	self.mutex.Unlock()
+}
+
+func (self *replicaStore) remove(keys ...string) {
+	self.mutex.Lock()
+	for index := range keys {
+		self.entries.Remove(keys[index])
+	}
+	self.mutex.Unlock()
+}
+
+type HotKeysArgs struct {
+	Limit int
+}
+
+type HotKeysReply struct {
+	HotKeys []HotKey
+}
+
+func (self ClusterCommandRpcHandlers) HotKeys(args HotKeysArgs, reply *HotKeysReply) error {
+	reply.HotKeys = self.LocalHotKeys(args.Limit)
+	return nil
+}
+
+func newHotKeysRequest(args HotKeysArgs, resp *HotKeysReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeHotKeys,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+type ReplicaEntry struct {
+	Key        string
+	ValueBytes []byte
+	TTL        time.Duration
+}
+
+type ReplicateArgs struct {
+	Entries []ReplicaEntry
+}
+
+type ReplicateReply struct{}
+
+// Replicate stores copies of another node's hot keys.
+func (self ClusterCommandRpcHandlers) Replicate(args ReplicateArgs, reply *ReplicateReply) error {
+	now := time.Now()
+	for index := range args.Entries {
+		self.replicas.put(args.Entries[index].Key, replica{
+			expiresAt:  now.Add(args.Entries[index].TTL),
+			valueBytes: args.Entries[index].ValueBytes,
+		})
+	}
+	return nil
+}
+
+func newReplicateRequest(args ReplicateArgs, resp *ReplicateReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeReplicate,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+type DropReplicasArgs struct {
+	Keys []string
+}
+
+type DropReplicasReply struct{}
+
+func (self ClusterCommandRpcHandlers) DropReplicas(args DropReplicasArgs, reply *DropReplicasReply) error {
+	self.replicas.remove(args.Keys...)
+	return nil
+}
+
+func newDropReplicasRequest(args DropReplicasArgs, resp *DropReplicasReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeDropReplicas,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// LocalHotKeys returns up to limit of the most read keys owned by this node, most read first.
+func (self *Cluster) LocalHotKeys(limit int) []HotKey {
+	return self.hotKeys.hotKeys(limit)
+}
+
+// HotKeys returns up to limit of the most read keys in the cluster, most read first. If some nodes cannot be asked,
+// the hot keys of the other nodes are still returned and the errors are joined.
+func HotKeys(ctx context.Context, cluster *Cluster, limit int) ([]HotKey, error) {
+	hotKeys := []HotKey{}
+	errs := []error{}
+
+	for _, address := range cluster.sortedAddresses() {
+		nodeHotKeys, err := NodeHotKeys(ctx, cluster, address, limit)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		hotKeys = append(hotKeys, nodeHotKeys...)
+	}
+
+	sortHotKeys(hotKeys)
+
+	return hotKeys[:min(limit, len(hotKeys))], errors.Join(errs...)
+}
+
+// NodeHotKeys returns up to limit of the most read keys owned by the node at the address, most read first.
+func NodeHotKeys(ctx context.Context, cluster *Cluster, address Address, limit int) ([]HotKey, error) {
+	if address.String() == cluster.clusterServer.Address() {
+		return cluster.LocalHotKeys(limit), nil
+	}
+
+	client := cluster.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return nil, fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	reply := &HotKeysReply{}
+	if err := client.Send(ctx, newHotKeysRequest(HotKeysArgs{Limit: limit}, reply)); err != nil {
+		return nil, err
+	}
+
+	return reply.HotKeys, nil
+}
+
+// trackHotKeys decays the read counts and, when hot key replication is enabled, keeps the copies of hot keys on the
+// other nodes fresh until the context is done.
+func (self *Cluster) trackHotKeys(ctx context.Context) {
+	decayTicker := time.NewTicker(hotKeyDecayInterval)
+	defer decayTicker.Stop()
+
+	// A nil channel never fires, which leaves replication off.
+	var replicateTick <-chan time.Time
+	if self.replicaTTL > 0 {
+		// Refresh copies well before they expire.
+		replicateTicker := time.NewTicker(self.replicaTTL / 2)
+		defer replicateTicker.Stop()
+		replicateTick = replicateTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-decayTicker.C:
+			self.hotKeys.decay()
+		case <-replicateTick:
+			self.replicateHotKeys(ctx)
+		}
+	}
+}
+
+// replicateHotKeys pushes copies of the hot keys owned by this node to every other node.
+func (self *Cluster) replicateHotKeys(ctx context.Context) {
+	now := time.Now()
+
+	args := ReplicateArgs{
+		Entries: []ReplicaEntry{},
+	}
+	for _, hotKey := range self.hotKeys.hotKeys(hotKeyCandidates) {
+		if hotKey.Count < self.hotKeyThreshold {
+			break
+		}
+
+		valueBytes, expiresAt, err := self.keyStore.GetWithExpiry(hotKey.Key)
+		if err != nil {
+			continue
+		}
+
+		ttl := self.replicaTTL
+		if !expiresAt.IsZero() {
+			ttl = min(ttl, expiresAt.Sub(now))
+		}
+
+		args.Entries = append(args.Entries, ReplicaEntry{
+			Key:        hotKey.Key,
+			ValueBytes: valueBytes,
+			TTL:        ttl,
+		})
+	}
+
+	replicated := make(map[string]struct{}, len(args.Entries))
+	for index := range args.Entries {
+		replicated[args.Entries[index].Key] = struct{}{}
+	}
+
+	// Record the keys before pushing them, so that a change made after the values were read still drops the copies.
+	// A drop can still overtake the push it races with, which leaves a stale copy for at most the ttl.
+	self.replicatedMutex.Lock()
+	self.replicated = replicated
+	self.replicatedMutex.Unlock()
+
+	if len(args.Entries) == 0 {
+		return
+	}
+
+	for _, address := range self.sortedAddresses() {
+		if address.String() == self.clusterServer.Address() {
+			continue
+		}
+
+		client := self.getClientByHostPort(address.Host, address.Port)
+		if client == nil {
+			continue
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, watchRequestTimeout)
+		if err := client.Send(requestCtx, newReplicateRequest(args, &ReplicateReply{})); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("address", address.String()).Msg("failed to replicate hot keys")
+		}
+		cancel()
+	}
+}
+
+// dropReplicas tells every other node to drop its copies of the replicated keys that changed.
+func (self *Cluster) dropReplicas(ctx context.Context, events []Event) {
+	keys := []string{}
+
+	self.replicatedMutex.Lock()
+	for index := range events {
+		if _, exists := self.replicated[events[index].Key]; exists {
+			delete(self.replicated, events[index].Key)
+			keys = append(keys, events[index].Key)
+		}
+	}
+	self.replicatedMutex.Unlock()
+
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, address := range self.sortedAddresses() {
+		if address.String() == self.clusterServer.Address() {
+			continue
+		}
+
+		queue := self.peerQueueFor(address)
+		for index := range keys {
+			select {
+			case queue.dropKeys <- keys[index]:
+			default:
+				// The copy expires with the replica ttl.
+				log.Ctx(ctx).Warn().Str("address", address.String()).Str("key", keys[index]).Msg("replica drop queue full, dropping replica drop")
+			}
+		}
+	}
+}
+
+func (self *Cluster) sendDropReplicas(ctx context.Context, address Address, keys []string) error {
+	client := self.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, watchRequestTimeout)
+	defer cancel()
+
+	return client.Send(ctx, newDropReplicasRequest(DropReplicasArgs{Keys: keys}, &DropReplicasReply{}))
+}
@@ -0,0 +1,133 @@
+// Package glob matches keys against redis style glob patterns.
+//
+//	pattern  matches
+//	*        any sequence of characters, including none
+//	?        any single character
+//	[abc]    one of the characters in the brackets
+//	[^abc]   any character not in the brackets
+//	[a-z]    one character in the range
+//	\x       x literally
+package glob
+
+import (
+	"strings"
+)
+
+const metaCharacters = `*?[\`
+
+// Match reports whether the whole key matches the pattern.
+func Match(pattern string, key string) bool {
+	var patternIndex, keyIndex int
+
+	// Position to resume from when a mismatch happens after a '*'.
+	starPatternIndex, starKeyIndex := -1, -1
+
+	for keyIndex < len(key) {
+		if patternIndex < len(pattern) {
+			switch pattern[patternIndex] {
+			case '*':
+				starPatternIndex = patternIndex
+				starKeyIndex = keyIndex
+				patternIndex++
+				continue
+			case '?':
+				patternIndex++
+				keyIndex++
+				continue
+			case '[':
+				if matched, width := matchClass(pattern[patternIndex:], key[keyIndex]); matched {
+					patternIndex += width
+					keyIndex++
+					continue
+				}
+			case '\\':
+				if patternIndex+1 < len(pattern) && pattern[patternIndex+1] == key[keyIndex] {
+					patternIndex += 2
+					keyIndex++
+					continue
+				}
+			default:
+				if pattern[patternIndex] == key[keyIndex] {
+					patternIndex++
+					keyIndex++
+					continue
+				}
+			}
+		}
+
+		if starPatternIndex < 0 {
+			return false
+		}
+
+		// Let the last '*' swallow one more character and try again.
+		starKeyIndex++
+		patternIndex = starPatternIndex + 1
+		keyIndex = starKeyIndex
+	}
+
+	// The key is used up, so only trailing '*' can remain in the pattern.
+	for patternIndex < len(pattern) && pattern[patternIndex] == '*' {
+		patternIndex++
+	}
+
+	return patternIndex == len(pattern)
+}
+
+// matchClass matches the character against the bracket expression at the start of the pattern. Returns whether the
+// character matched and the width of the bracket expression.
+func matchClass(pattern string, character byte) (bool, int) {
+	index := 1
+	negate := false
+	if index < len(pattern) && (pattern[index] == '^' || pattern[index] == '!') {
+		negate = true
+		index++
+	}
+
+	matched := false
+	for index < len(pattern) && pattern[index] != ']' {
+		low := pattern[index]
+		if low == '\\' && index+1 < len(pattern) {
+			index++
+			low = pattern[index]
+		}
+
+		high := low
+		if index+2 < len(pattern) && pattern[index+1] == '-' && pattern[index+2] != ']' {
+			high = pattern[index+2]
+			index += 2
+		}
+
+		if low <= character && character <= high {
+			matched = true
+		}
+		index++
+	}
+
+	if index >= len(pattern) {
+		// Unterminated bracket. Treat the '[' as a literal.
+		return character == '[', 1
+	}
+
+	return matched != negate, index + 1
+}
+
+// Prefix returns the literal prefix of the pattern, up to the first meta character.
+func Prefix(pattern string) string {
+	if index := strings.IndexAny(pattern, metaCharacters); index >= 0 {
+		return pattern[:index]
+	}
+	return pattern
+}
+
+// QuoteMeta escapes every meta character in the text so that the returned pattern matches the text literally.
+func QuoteMeta(text string) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	for index := range len(text) {
+		if strings.IndexByte(metaCharacters, text[index]) >= 0 {
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(text[index])
+	}
+	return builder.String()
+}
@@ -0,0 +1,66 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/cluster/internal/glob"
+)
+
+func Test_Match(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		pattern  string
+		key      string
+		expected bool
+	}{
+		{pattern: "*", key: "", expected: true},
+		{pattern: "*", key: "anything", expected: true},
+		{pattern: "user:*", key: "user:123", expected: true},
+		{pattern: "user:*", key: "users:123", expected: false},
+		{pattern: "user:*:name", key: "user:123:name", expected: true},
+		{pattern: "user:*:name", key: "user:123:email", expected: false},
+		{pattern: "*:*:name", key: "user:1:2:name", expected: true},
+		{pattern: "h?llo", key: "hello", expected: true},
+		{pattern: "h?llo", key: "hllo", expected: false},
+		{pattern: "h[ae]llo", key: "hallo", expected: true},
+		{pattern: "h[ae]llo", key: "hillo", expected: false},
+		{pattern: "h[^e]llo", key: "hallo", expected: true},
+		{pattern: "h[^e]llo", key: "hello", expected: false},
+		{pattern: "h[a-c]llo", key: "hbllo", expected: true},
+		{pattern: "h[a-c]llo", key: "hdllo", expected: false},
+		{pattern: `h\*llo`, key: "h*llo", expected: true},
+		{pattern: `h\*llo`, key: "hello", expected: false},
+		{pattern: "h[llo", key: "h[llo", expected: true},
+		{pattern: "exact", key: "exact", expected: true},
+		{pattern: "exact", key: "exactly", expected: false},
+	}
+	for index := range testCases {
+		testCase := testCases[index]
+		t.Run(testCase.pattern+" "+testCase.key, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, testCase.expected, glob.Match(testCase.pattern, testCase.key))
+		})
+	}
+}
+
+func Test_Prefix(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "user:", glob.Prefix("user:*"))
+	assert.Equal(t, "user:", glob.Prefix("user:[12]"))
+	assert.Equal(t, "exact", glob.Prefix("exact"))
+	assert.Equal(t, "", glob.Prefix("*"))
+}
+
+func Test_QuoteMeta(t *testing.T) {
+	t.Parallel()
+
+	pattern := glob.QuoteMeta("user:[1]*?") + "*"
+	assert.Equal(t, `user:\[1]\*\?*`, pattern)
+	assert.True(t, glob.Match(pattern, "user:[1]*?:name"))
+	assert.False(t, glob.Match(pattern, "user:1:name"))
+}
@@ -0,0 +1,86 @@
+// Package lru is a fixed size, least recently used cache. It is not safe for concurrent use.
+package lru
+
+import (
+	"container/list"
+)
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+type Cache[K comparable, V any] struct {
+	entries  map[K]*list.Element
+	order    *list.List
+	capacity int
+}
+
+// New creates a cache holding up to capacity entries. The capacity must be greater than zero.
+func New[K comparable, V any](capacity int) *Cache[K, V] {
+	return &Cache[K, V]{
+		entries:  make(map[K]*list.Element, capacity),
+		order:    list.New(),
+		capacity: capacity,
+	}
+}
+
+// Get returns the value of the key and marks it as the most recently used.
+func (self *Cache[K, V]) Get(key K) (V, bool) {
+	element, exists := self.entries[key]
+	if !exists {
+		var value V
+		return value, false
+	}
+
+	self.order.MoveToFront(element)
+
+	return element.Value.(*entry[K, V]).value, true
+}
+
+// Add sets the value of the key. Reports whether the least recently used entry was evicted to make room.
+func (self *Cache[K, V]) Add(key K, value V) bool {
+	if element, exists := self.entries[key]; exists {
+		element.Value.(*entry[K, V]).value = value
+		self.order.MoveToFront(element)
+		return false
+	}
+
+	self.entries[key] = self.order.PushFront(&entry[K, V]{
+		key:   key,
+		value: value,
+	})
+
+	if self.order.Len() <= self.capacity {
+		return false
+	}
+
+	oldest := self.order.Back()
+	self.order.Remove(oldest)
+	delete(self.entries, oldest.Value.(*entry[K, V]).key)
+
+	return true
+}
+
+// Remove deletes the key. Reports whether the key existed.
+func (self *Cache[K, V]) Remove(key K) bool {
+	element, exists := self.entries[key]
+	if !exists {
+		return false
+	}
+
+	self.order.Remove(element)
+	delete(self.entries, key)
+
+	return true
+}
+
+// Purge deletes every entry.
+func (self *Cache[K, V]) Purge() {
+	clear(self.entries)
+	self.order.Init()
+}
+
+func (self *Cache[K, V]) Len() int {
+	return self.order.Len()
+}
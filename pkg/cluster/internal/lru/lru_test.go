@@ -0,0 +1,38 @@
+package lru_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/cluster/internal/lru"
+)
+
+func Test_Cache(t *testing.T) {
+	t.Parallel()
+
+	cache := lru.New[string, int](2)
+
+	assert.False(t, cache.Add("a", 1))
+	assert.False(t, cache.Add("b", 2))
+
+	// Reading "a" makes "b" the least recently used.
+	value, exists := cache.Get("a")
+	assert.True(t, exists)
+	assert.Equal(t, 1, value)
+
+	assert.True(t, cache.Add("c", 3))
+	_, exists = cache.Get("b")
+	assert.False(t, exists)
+	assert.Equal(t, 2, cache.Len())
+
+	assert.False(t, cache.Add("a", 10))
+	value, _ = cache.Get("a")
+	assert.Equal(t, 10, value)
+
+	assert.True(t, cache.Remove("a"))
+	assert.False(t, cache.Remove("a"))
+
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+}
@@ -0,0 +1,76 @@
+// Package sketch estimates how often keys are seen using a count-min sketch.
+//
+// A count-min sketch counts into a few rows of counters, using a different hash of the key for each row. Collisions
+// only ever add to a counter, so the smallest of the key's counters is an estimate that is never too low. Memory use
+// is fixed no matter how many distinct keys are counted.
+package sketch
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+)
+
+// CountMin is safe for concurrent use. Counting does not lock.
+type CountMin struct {
+	seeds    []maphash.Seed
+	counters [][]atomic.Uint64
+}
+
+// NewCountMin creates a sketch with depth rows of width counters. Wider rows make collisions less likely, and more
+// rows make it less likely that every counter of a key collides.
+func NewCountMin(width int, depth int) *CountMin {
+	sketch := &CountMin{
+		seeds:    make([]maphash.Seed, depth),
+		counters: make([][]atomic.Uint64, depth),
+	}
+
+	for row := range depth {
+		sketch.seeds[row] = maphash.MakeSeed()
+		sketch.counters[row] = make([]atomic.Uint64, width)
+	}
+
+	return sketch
+}
+
+// Add counts the key and returns its new estimate.
+func (self *CountMin) Add(key string) uint64 {
+	estimate := uint64(0)
+	for row := range self.counters {
+		count := self.counters[row][self.column(row, key)].Add(1)
+		if row == 0 || count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// Estimate returns how many times the key was counted. It may be too high, but never too low.
+func (self *CountMin) Estimate(key string) uint64 {
+	estimate := uint64(0)
+	for row := range self.counters {
+		count := self.counters[row][self.column(row, key)].Load()
+		if row == 0 || count < estimate {
+			estimate = count
+		}
+	}
+	return estimate
+}
+
+// Halve halves every counter so that older counts matter less than recent ones.
+func (self *CountMin) Halve() {
+	for row := range self.counters {
+		for column := range self.counters[row] {
+			counter := &self.counters[row][column]
+			for {
+				count := counter.Load()
+				if count == 0 || counter.CompareAndSwap(count, count/2) {
+					break
+				}
+			}
+		}
+	}
+}
+
+func (self *CountMin) column(row int, key string) int {
+	return int(maphash.String(self.seeds[row], key) % uint64(len(self.counters[row])))
+}
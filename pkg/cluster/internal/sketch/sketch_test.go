@@ -0,0 +1,54 @@
+package sketch_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/cluster/internal/sketch"
+)
+
+func Test_CountMin(t *testing.T) {
+	t.Parallel()
+
+	countMin := sketch.NewCountMin(1024, 4)
+
+	for range 1000 {
+		countMin.Add("hot")
+	}
+	for index := range 1000 {
+		countMin.Add("cold:" + strconv.Itoa(index))
+	}
+
+	// Estimates are never too low and, with this much room, collisions barely add to them.
+	assert.GreaterOrEqual(t, countMin.Estimate("hot"), uint64(1000))
+	assert.Less(t, countMin.Estimate("hot"), uint64(1010))
+	assert.GreaterOrEqual(t, countMin.Estimate("cold:1"), uint64(1))
+	assert.Less(t, countMin.Estimate("cold:1"), uint64(10))
+
+	countMin.Halve()
+	assert.GreaterOrEqual(t, countMin.Estimate("hot"), uint64(500))
+	assert.Less(t, countMin.Estimate("hot"), uint64(505))
+}
+
+func Test_CountMin_concurrent(t *testing.T) {
+	t.Parallel()
+
+	countMin := sketch.NewCountMin(1024, 4)
+
+	waitGroup := sync.WaitGroup{}
+	for range 8 {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for range 1000 {
+				countMin.Add("hot")
+			}
+		}()
+	}
+	waitGroup.Wait()
+
+	assert.Equal(t, uint64(8000), countMin.Estimate("hot"))
+}
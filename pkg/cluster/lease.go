@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+type lease struct {
+	expires time.Time
+	holder  string
+	token   uint64
+}
+
+// leaseTable holds the named leases owned by this node. Leases are expired lazily whenever they are looked up,
+// so there is no background goroutine to clean up after.
+type leaseTable struct {
+	leases map[string]lease
+	// tokens holds the last token issued for each name when tokens fence writes. It outlives the leases so that the
+	// tokens of a name keep increasing, and is handed to the other nodes that may own the name next. Nil when tokens
+	// only tell holders apart.
+	tokens    map[string]uint64
+	mutex     sync.Mutex
+	lastToken uint64
+}
+
+func newLeaseTable() *leaseTable {
+	return &leaseTable{
+		leases:    map[string]lease{},
+		tokens:    nil,
+		mutex:     sync.Mutex{},
+		lastToken: 0,
+	}
+}
+
+// newFencingLeaseTable returns a lease table whose tokens increase for each name across owners, as long as the tokens
+// of a name are handed to its next owner with observeTokens.
+func newFencingLeaseTable() *leaseTable {
+	table := newLeaseTable()
+	table.tokens = map[string]uint64{}
+	return table
+}
+
+// nextToken returns a token for the name that is strictly greater than any token previously issued for it by this
+// node or handed to it with observeTokens.
+// Must be called with the mutex held.
+func (self *leaseTable) nextToken(name string) uint64 {
+	if self.tokens == nil {
+		self.lastToken++
+		return self.lastToken
+	}
+
+	token := self.tokens[name] + 1
+	self.tokens[name] = token
+	return token
+}
+
+// observeTokens records tokens issued by other nodes, so that tokens issued here for the same names are greater.
+func (self *leaseTable) observeTokens(tokens map[string]uint64) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for name, token := range tokens {
+		self.tokens[name] = max(self.tokens[name], token)
+	}
+}
+
+// lastTokens returns the last token of every name.
+func (self *leaseTable) lastTokens() map[string]uint64 {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return maps.Clone(self.tokens)
+}
+
+// current returns the unexpired lease for the name.
+// Must be called with the mutex held.
+func (self *leaseTable) current(name string, now time.Time) (lease, bool) {
+	current, exists := self.leases[name]
+	if !exists {
+		return lease{}, false
+	}
+	if !now.Before(current.expires) {
+		delete(self.leases, name)
+		return lease{}, false
+	}
+	return current, true
+}
+
+// acquire grants the lease to the holder if no one else currently holds it.
+func (self *leaseTable) acquire(name string, holder string, ttl time.Duration) (uint64, bool) {
+	return self.acquireIf(name, holder, ttl, func() bool { return true })
+}
+
+// acquireIf grants the lease to the holder if no one else currently holds it and allowed returns true. allowed is
+// called with the mutex held, so that checking and granting happen in one step for everyone acquiring or removing
+// the lease.
+func (self *leaseTable) acquireIf(name string, holder string, ttl time.Duration, allowed func() bool) (uint64, bool) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	now := time.Now()
+	if _, held := self.current(name, now); held {
+		return 0, false
+	}
+	if !allowed() {
+		return 0, false
+	}
+
+	token := self.nextToken(name)
+	self.leases[name] = lease{
+		expires: now.Add(ttl),
+		holder:  holder,
+		token:   token,
+	}
+
+	return token, true
+}
+
+// refresh extends the lease if the holder still owns it under the given token.
+func (self *leaseTable) refresh(name string, holder string, token uint64, ttl time.Duration) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	now := time.Now()
+	current, held := self.current(name, now)
+	if !held || current.holder != holder || current.token != token {
+		return false
+	}
+
+	current.expires = now.Add(ttl)
+	self.leases[name] = current
+
+	return true
+}
+
+// release gives up the lease if the holder still owns it under the given token.
+func (self *leaseTable) release(name string, holder string, token uint64) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	current, held := self.current(name, time.Now())
+	if !held || current.holder != holder || current.token != token {
+		return false
+	}
+
+	delete(self.leases, name)
+
+	return true
+}
+
+// remove drops the lease regardless of who holds it.
+func (self *leaseTable) remove(name string) {
+	self.mutex.Lock()
+	delete(self.leases, name)
+	self.mutex.Unlock()
+}
@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"diskey/pkg/command"
+	"diskey/pkg/errors"
+)
+
+type AcquireLockArgs struct {
+	Name     string
+	Holder   string
+	LeaseTTL time.Duration
+}
+
+type AcquireLockReply struct {
+	Token    uint64
+	Acquired bool
+}
+
+// lockTokenSyncTimeout bounds how long an acquire served for another node waits for the successor to record the token.
+// The caller on the other node stops waiting on its own context, which does not reach this node.
+const lockTokenSyncTimeout = time.Second
+
+func (self ClusterCommandRpcHandlers) AcquireLock(args AcquireLockArgs, reply *AcquireLockReply) error {
+	ctx, cancel := context.WithTimeout(self.ctx, lockTokenSyncTimeout)
+	defer cancel()
+
+	return self.acquireLock(ctx, args, reply)
+}
+
+// acquireLock takes the lock on this node. The token is only handed out once the node that takes over the name if this
+// one fails knows about it, so that tokens keep increasing across owners.
+func (self ClusterCommandRpcHandlers) acquireLock(ctx context.Context, args AcquireLockArgs, reply *AcquireLockReply) error {
+	reply.Token, reply.Acquired = self.locks.acquire(args.Name, args.Holder, args.LeaseTTL)
+	if !reply.Acquired {
+		return nil
+	}
+
+	_, successor := self.lockNodes(args.Name)
+	if err := self.sendLockTokens(ctx, successor, map[string]uint64{args.Name: reply.Token}); err != nil {
+		self.locks.release(args.Name, args.Holder, reply.Token)
+		reply.Token, reply.Acquired = 0, false
+		return err
+	}
+
+	return nil
+}
+
+func newAcquireLockRequest(args AcquireLockArgs, resp *AcquireLockReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeAcquireLock,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// AcquireLock attempts to take the named lock on the node that owns the name. The lease expires on the owner
+// after leaseTTL unless it is refreshed.
+//
+// The returned fencing token increases monotonically each time the lock changes hands. Only the token reaches the node
+// that takes the name over if the owner fails, not the lease. That node grants the lock again right away while the
+// old holder may still believe it holds it, so the lock alone does not guarantee mutual exclusion across owner
+// failures. Pass the token to the protected resource and have it reject tokens lower than the last one it saw.
+func AcquireLock(ctx context.Context, cluster *Cluster, name string, holder string, leaseTTL time.Duration) (uint64, errors.Error[LockError]) {
+	if name == "" {
+		return 0, errors.New(LockErrorBlankName, "lock name cannot be blank")
+	}
+
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: cluster,
+	}
+
+	args := AcquireLockArgs{
+		Name:     name,
+		Holder:   holder,
+		LeaseTTL: leaseTTL,
+	}
+	reply := &AcquireLockReply{}
+
+	if err := cluster.sendToOwner(ctx, name, newAcquireLockRequest(args, reply), func() error {
+		return handlers.acquireLock(ctx, args, reply)
+	}); err != nil {
+		return 0, errors.NewWithErr(LockErrorOwnerUnavailable, err)
+	}
+
+	if !reply.Acquired {
+		return 0, errors.New(LockErrorHeld, "lock %s is held", name)
+	}
+
+	return reply.Token, errors.Ok[LockError]()
+}
+
+type RefreshLockArgs struct {
+	Name     string
+	Holder   string
+	Token    uint64
+	LeaseTTL time.Duration
+}
+
+type RefreshLockReply struct {
+	Refreshed bool
+}
+
+func (self ClusterCommandRpcHandlers) RefreshLock(args RefreshLockArgs, reply *RefreshLockReply) error {
+	reply.Refreshed = self.locks.refresh(args.Name, args.Holder, args.Token, args.LeaseTTL)
+	return nil
+}
+
+func newRefreshLockRequest(args RefreshLockArgs, resp *RefreshLockReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeRefreshLock,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// RefreshLock extends the lease on a held lock. Fails with LockErrorLost if the lease expired or the lock is now
+// held by someone else, including when the owning node changed since the lock was acquired.
+func RefreshLock(ctx context.Context, cluster *Cluster, name string, holder string, token uint64, leaseTTL time.Duration) errors.Error[LockError] {
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: cluster,
+	}
+
+	args := RefreshLockArgs{
+		Name:     name,
+		Holder:   holder,
+		Token:    token,
+		LeaseTTL: leaseTTL,
+	}
+	reply := &RefreshLockReply{}
+
+	if err := cluster.sendToOwner(ctx, name, newRefreshLockRequest(args, reply), func() error {
+		return handlers.RefreshLock(args, reply)
+	}); err != nil {
+		return errors.NewWithErr(LockErrorOwnerUnavailable, err)
+	}
+
+	if !reply.Refreshed {
+		return errors.New(LockErrorLost, "lock %s is no longer held with token %d", name, token)
+	}
+
+	return errors.Ok[LockError]()
+}
+
+type ReleaseLockArgs struct {
+	Name   string
+	Holder string
+	Token  uint64
+}
+
+type ReleaseLockReply struct {
+	Released bool
+}
+
+func (self ClusterCommandRpcHandlers) ReleaseLock(args ReleaseLockArgs, reply *ReleaseLockReply) error {
+	reply.Released = self.locks.release(args.Name, args.Holder, args.Token)
+	return nil
+}
+
+func newReleaseLockRequest(args ReleaseLockArgs, resp *ReleaseLockReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeReleaseLock,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// ReleaseLock gives up a held lock. Fails with LockErrorLost if the lock was no longer held under the token.
+func ReleaseLock(ctx context.Context, cluster *Cluster, name string, holder string, token uint64) errors.Error[LockError] {
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: cluster,
+	}
+
+	args := ReleaseLockArgs{
+		Name:   name,
+		Holder: holder,
+		Token:  token,
+	}
+	reply := &ReleaseLockReply{}
+
+	if err := cluster.sendToOwner(ctx, name, newReleaseLockRequest(args, reply), func() error {
+		return handlers.ReleaseLock(args, reply)
+	}); err != nil {
+		return errors.NewWithErr(LockErrorOwnerUnavailable, err)
+	}
+
+	if !reply.Released {
+		return errors.New(LockErrorLost, "lock %s is no longer held with token %d", name, token)
+	}
+
+	return errors.Ok[LockError]()
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/command"
+)
+
+type SyncLockTokensArgs struct {
+	// Tokens is the last fencing token issued for each lock name.
+	Tokens map[string]uint64
+}
+
+type SyncLockTokensReply struct{}
+
+// SyncLockTokens records the fencing tokens issued by another node, so that locks this node takes over continue from
+// them.
+func (self ClusterCommandRpcHandlers) SyncLockTokens(args SyncLockTokensArgs, reply *SyncLockTokensReply) error {
+	self.locks.observeTokens(args.Tokens)
+	return nil
+}
+
+func newSyncLockTokensRequest(args SyncLockTokensArgs, resp *SyncLockTokensReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeSyncLockTokens,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// lockNodes returns the node that owns the lock name and the node that owns it once the owner is gone. The successor
+// is the owner when the owner is alone.
+func (self *Cluster) lockNodes(name string) (Address, Address) {
+	self.clientsMutex.RLock()
+	defer self.clientsMutex.RUnlock()
+
+	owner := closestAddressToSlot(self.addresses, Slot(name))
+	others := slices.DeleteFunc(slices.Clone(self.addresses), func(address Address) bool {
+		return address.String() == owner.String()
+	})
+	if len(others) == 0 {
+		return owner, owner
+	}
+
+	return owner, closestAddressToSlot(others, Slot(name))
+}
+
+// sendLockTokens hands the tokens to the node. Tokens for this node are already known.
+func (self *Cluster) sendLockTokens(ctx context.Context, address Address, tokens map[string]uint64) error {
+	if address.String() == self.clusterServer.Address() {
+		return nil
+	}
+
+	client := self.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	return client.Send(ctx, newSyncLockTokensRequest(SyncLockTokensArgs{Tokens: tokens}, &SyncLockTokensReply{}))
+}
+
+// syncLockTokens hands the fencing tokens this node knows about to the current owner and successor of each lock name.
+// Called when membership changes, since the owner of a name may change with it. Tokens only increase, so handing the
+// same tokens over again is harmless.
+func (self *Cluster) syncLockTokens(ctx context.Context) {
+	tokensByAddress := map[Address]map[string]uint64{}
+	for name, token := range self.locks.lastTokens() {
+		owner, successor := self.lockNodes(name)
+		for _, address := range []Address{owner, successor} {
+			if tokensByAddress[address] == nil {
+				tokensByAddress[address] = map[string]uint64{}
+			}
+			tokensByAddress[address][name] = token
+		}
+	}
+
+	errs := []error{}
+	for address, tokens := range tokensByAddress {
+		if err := self.sendLockTokens(ctx, address, tokens); err != nil {
+			errs = append(errs, fmt.Errorf("failed to sync %d lock tokens to %s: %w", len(tokens), address.String(), err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to sync lock tokens")
+	}
+}
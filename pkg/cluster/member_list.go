@@ -6,6 +6,7 @@ import (
 	"io"
 	"math/rand"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"diskey/pkg/discovery"
@@ -40,8 +41,9 @@ type MemberList struct {
 // used when creating the MemberList.
 func NewMemberList(ctx context.Context, disco discovery.Discovery, metadata []byte, options ...MemberListOption) MemberList {
 	memberDelegate := MemberDelegate{
-		metadata: metadata,
+		metadata: &atomic.Pointer[[]byte]{},
 	}
+	memberDelegate.metadata.Store(&metadata)
 
 	// Create the initial memberlist from a safe configuration.
 	// Please reference the godoc for other default config types.
@@ -156,6 +158,13 @@ func (self MemberList) Shutdown(timeout time.Duration) error {
 	return self.memberList.Shutdown()
 }
 
+// UpdateMetadata replaces the metadata of this node and gossips it to the other nodes, waiting up to the timeout for
+// them to receive it.
+func (self MemberList) UpdateMetadata(metadata []byte, timeout time.Duration) error {
+	self.memberDelegate.metadata.Store(&metadata)
+	return self.memberList.UpdateNode(timeout)
+}
+
 func (self MemberList) Node() *memberlist.Node {
 	return self.memberList.LocalNode()
 }
@@ -183,7 +192,8 @@ func Uuid() string {
 }
 
 type MemberDelegate struct {
-	metadata []byte
+	// metadata is shared by every copy of the delegate so that it can be updated after the memberlist is created.
+	metadata *atomic.Pointer[[]byte]
 }
 
 // NodeMeta is used to retrieve meta-data about the current node
@@ -191,7 +201,7 @@ type MemberDelegate struct {
 // the given byte size. This metadata is available in the Node structure.
 func (self MemberDelegate) NodeMeta(limit int) []byte {
 	// fmt.Printf("node meta: %d\n", limit)
-	return self.metadata
+	return *self.metadata.Load()
 }
 
 // NotifyMsg is called when a user-data message is received.
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/metrics"
+	"diskey/pkg/rpc"
+)
+
+const httpShutdownTimeout = 5 * time.Second
+
+// OptionHTTPAddress serves the node's http endpoints at the address: metrics on /metrics, in the Prometheus text
+// format, liveness on /healthz and readiness on /readyz.
+func OptionHTTPAddress(address string) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.httpAddress = address
+	}
+}
+
+var batchSizeBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+type clusterMetrics struct {
+	registry *metrics.Registry
+
+	expirations metrics.Counter
+	evictions   metrics.Counter
+
+	rpcCalls    metrics.Counter
+	rpcErrors   metrics.Counter
+	rpcDuration metrics.Histogram
+
+	batchSize metrics.Histogram
+	batchWait metrics.Histogram
+
+	joins  metrics.Counter
+	leaves metrics.Counter
+}
+
+func newClusterMetrics(registry *metrics.Registry) *clusterMetrics {
+	return &clusterMetrics{
+		registry: registry,
+
+		expirations: registry.NewCounter("diskey_cache_expirations_total", "Keys removed because their ttl ran out."),
+		evictions:   registry.NewCounter("diskey_cache_evictions_total", "Keys removed to make room for new keys."),
+
+		rpcCalls:    registry.NewCounter("diskey_rpc_client_calls_total", "Requests sent to other nodes.", "peer", "method"),
+		rpcErrors:   registry.NewCounter("diskey_rpc_client_errors_total", "Requests sent to other nodes that failed.", "peer", "method"),
+		rpcDuration: registry.NewHistogram("diskey_rpc_client_duration_seconds", "Time taken by requests sent to other nodes.", metrics.DefaultLatencyBuckets, "peer", "method"),
+
+		batchSize: registry.NewHistogram("diskey_batch_size", "Requests per batch flushed to other nodes.", batchSizeBuckets, "peer"),
+		batchWait: registry.NewHistogram("diskey_batch_wait_seconds", "Time the first request of a batch waited before the batch was flushed.", metrics.DefaultLatencyBuckets, "peer"),
+
+		joins:  registry.NewCounter("diskey_cluster_joins_total", "Nodes that joined the cluster."),
+		leaves: registry.NewCounter("diskey_cluster_leaves_total", "Nodes that left the cluster."),
+	}
+}
+
+// registerStateMetrics registers the metrics read from the state of the cluster on every scrape.
+func (self *Cluster) registerStateMetrics() {
+	registry := self.metrics.registry
+
+	registry.NewCounterFunc("diskey_cache_hits_total", "Reads of keys that exist.", func() float64 {
+		return float64(self.keyStore.Stats().Hits)
+	})
+	registry.NewCounterFunc("diskey_cache_misses_total", "Reads of keys that do not exist.", func() float64 {
+		return float64(self.keyStore.Stats().Misses)
+	})
+	registry.NewCounterFunc("diskey_cache_delete_hits_total", "Deletes of keys that exist.", func() float64 {
+		return float64(self.keyStore.Stats().DeleteHits)
+	})
+	registry.NewCounterFunc("diskey_cache_delete_misses_total", "Deletes of keys that do not exist.", func() float64 {
+		return float64(self.keyStore.Stats().DeleteMisses)
+	})
+	registry.NewCounterFunc("diskey_cache_collisions_total", "Keys that hashed to the same value as a different key.", func() float64 {
+		return float64(self.keyStore.Stats().Collisions)
+	})
+	registry.NewGaugeFunc("diskey_cache_entries", "Keys stored on this node.", func() float64 {
+		return float64(self.keyStore.Len())
+	})
+	registry.NewGaugeFunc("diskey_cache_capacity_bytes", "Bytes allocated to store keys on this node.", func() float64 {
+		return float64(self.keyStore.Capacity())
+	})
+
+	registry.NewGaugeFunc("diskey_cluster_members", "Nodes in the cluster, including this one.", func() float64 {
+		self.clientsMutex.RLock()
+		defer self.clientsMutex.RUnlock()
+		return float64(len(self.addresses))
+	})
+	registry.NewGaugeFunc("diskey_cluster_owned_slots", "Hash slots owned by this node.", func() float64 {
+		return float64(self.ownedSlots())
+	})
+}
+
+// observeClient records the requests the client sends.
+func (self *clusterMetrics) observeClient(client *rpc.Client) {
+	peer := client.Address()
+	client.SetObserver(func(method string, duration time.Duration, err error) {
+		self.rpcCalls.Inc(peer, method)
+		self.rpcDuration.Observe(duration.Seconds(), peer, method)
+		if err != nil {
+			self.rpcErrors.Inc(peer, method)
+		}
+	})
+}
+
+func (self *clusterMetrics) observeFlush(peer string, batchSize int, wait time.Duration) {
+	self.batchSize.Observe(float64(batchSize), peer)
+	self.batchWait.Observe(wait.Seconds(), peer)
+}
+
+// Metrics returns the registry holding the node's metrics. Applications may register their own metrics with it to
+// have them served alongside.
+func (self *Cluster) Metrics() *metrics.Registry {
+	return self.metrics.registry
+}
+
+// serveHTTP serves the http endpoints of the node until the context is done.
+func (self *Cluster) serveHTTP(ctx context.Context) {
+	listener, err := net.Listen("tcp", self.httpAddress)
+	if err != nil {
+		log.Ctx(ctx).Err(err).Str("address", self.httpAddress).Send()
+		panic(err)
+	}
+
+	server := &http.Server{
+		Handler:           self.httpMux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Ctx(ctx).Err(err).Msg("http server failed")
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), httpShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Ctx(ctx).Err(err).Msg("failed to shut down http server")
+		}
+	}()
+}
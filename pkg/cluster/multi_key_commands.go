@@ -0,0 +1,187 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/cache"
+	"diskey/pkg/command"
+	"diskey/pkg/trace"
+)
+
+type GetResult[T cache.Value] struct {
+	Value  T
+	Err    error
+	Exists bool
+}
+
+type Entry[T cache.Value] struct {
+	Value T
+	Key   string
+}
+
+// MGet gets many keys at once. Keys are grouped by the node that owns them and each node receives a single batch.
+// Batches to different nodes are sent in parallel.
+//
+// Results are returned in the same order as the keys.
+func MGet[T cache.Value](ctx context.Context, cluster *Cluster, keys ...string) []GetResult[T] {
+	byteResults := cluster.MGetBytes(ctx, keys...)
+
+	results := make([]GetResult[T], len(keys))
+	for index := range results {
+		if byteResults[index].Err != nil || !byteResults[index].Exists {
+			results[index].Err = byteResults[index].Err
+			continue
+		}
+		if err := cache.UnmarshalValueWith(cluster.encoding, byteResults[index].Value, &results[index].Value); err != nil {
+			results[index].Err = err
+			continue
+		}
+		results[index].Exists = true
+	}
+
+	return results
+}
+
+// MGetBytes gets the encoded values of many keys at once. See MGet.
+func (self *Cluster) MGetBytes(ctx context.Context, keys ...string) []GetResult[[]byte] {
+	replies := make([]GetReply, len(keys))
+	requests := make([]command.Request, len(keys))
+	for index := range keys {
+		requests[index] = newGetRequest(keys[index], &replies[index])
+	}
+
+	errs := self.runMulti(ctx, keys, requests)
+
+	results := make([]GetResult[[]byte], len(keys))
+	for index := range results {
+		results[index] = GetResult[[]byte]{
+			Value:  replies[index].ValueBytes,
+			Err:    errs[index],
+			Exists: errs[index] == nil && replies[index].Exists,
+		}
+	}
+
+	return results
+}
+
+// MSet sets many keys at once. See MGet for how the keys are distributed.
+//
+// Errors are returned in the same order as the entries. A nil error means the key was set.
+func MSet[T cache.Value](ctx context.Context, cluster *Cluster, entries ...Entry[T]) []error {
+	errs := make([]error, len(entries))
+	keys := make([]string, len(entries))
+	replies := make([]SetReply, len(entries))
+	requests := make([]command.Request, len(entries))
+	for index := range entries {
+		keys[index] = entries[index].Key
+
+		valueBytes, err := cache.MarshalValueWith(cluster.encoding, entries[index].Value)
+		if err != nil {
+			errs[index] = err
+			continue
+		}
+		requests[index] = newSetRequest(entries[index].Key, valueBytes, 0, &replies[index])
+	}
+
+	multiErrs := cluster.runMulti(ctx, keys, requests)
+	for index := range errs {
+		if errs[index] == nil {
+			errs[index] = multiErrs[index]
+		}
+	}
+
+	return errs
+}
+
+// MDelete deletes many keys at once. See MGet for how the keys are distributed.
+//
+// Errors are returned in the same order as the keys. A nil error means the key no longer exists.
+func MDelete(ctx context.Context, cluster *Cluster, keys ...string) []error {
+	replies := make([]DeleteReply, len(keys))
+	requests := make([]command.Request, len(keys))
+	for index := range keys {
+		requests[index] = newDeleteRequest(keys[index], &replies[index])
+	}
+
+	return cluster.runMulti(ctx, keys, requests)
+}
+
+// runMulti runs each request on the owner of the matching key. Requests without a name are skipped.
+//
+// Requests owned by this node run directly against the key store. All other requests are grouped by owner and each
+// owner receives one batch call. The batch calls are made in parallel so the caller only waits on the slowest node.
+func (self *Cluster) runMulti(ctx context.Context, keys []string, requests []command.Request) []error {
+	ctx, span := self.tracer.Start(ctx, "Multi", trace.SpanKindClient)
+	defer span.End()
+	span.SetAttribute("keys", strconv.Itoa(len(keys)))
+
+	errs := make([]error, len(requests))
+
+	handlers := ClusterCommandRpcHandlers{
+		Cluster: self,
+	}
+
+	indexesByOwner := map[Address][]int{}
+	for index := range requests {
+		if requests[index].Opcode == 0 {
+			continue
+		}
+		requests[index].Trace = span.SpanContext()
+
+		ownerAddress := self.getClosestAddress(keys[index])
+		if ownerAddress.String() == self.clusterServer.Address() {
+			errs[index] = runLocalRequest(handlers, requests[index])
+			continue
+		}
+
+		indexesByOwner[ownerAddress] = append(indexesByOwner[ownerAddress], index)
+	}
+
+	waitGroup := &sync.WaitGroup{}
+	for ownerAddress, indexes := range indexesByOwner {
+		waitGroup.Add(1)
+		go func(ownerAddress Address, indexes []int) {
+			defer waitGroup.Done()
+
+			var batchErrs []error
+			clientKeyOwner := self.getClientByHostPort(ownerAddress.Host, ownerAddress.Port)
+			if clientKeyOwner == nil {
+				batchErrs = failAll(len(indexes), fmt.Errorf("no connection to key owner %s", ownerAddress.String()))
+			} else {
+				batch := make([]*command.Request, len(indexes))
+				for batchIndex := range indexes {
+					batch[batchIndex] = &requests[indexes[batchIndex]]
+				}
+				start := time.Now()
+				batchErrs = sendBatch(ctx, clientKeyOwner, batch)
+				self.recordSlow(start, "Batch", keys[indexes[0]], ownerAddress.String(), len(indexes))
+			}
+
+			// Each index belongs to exactly one owner, so there is no contention writing the errors.
+			var firstErr error
+			failed := 0
+			for batchIndex, index := range indexes {
+				errs[index] = batchErrs[batchIndex]
+				if batchErrs[batchIndex] != nil {
+					failed++
+					if firstErr == nil {
+						firstErr = batchErrs[batchIndex]
+					}
+				}
+			}
+			if firstErr != nil {
+				span.RecordError(firstErr)
+				log.Ctx(ctx).Err(firstErr).Str("owner", ownerAddress.String()).Int("keys", len(indexes)).Int("failed", failed).Msg("failed to run batch on key owner")
+			}
+		}(ownerAddress, indexes)
+	}
+	waitGroup.Wait()
+
+	return errs
+}
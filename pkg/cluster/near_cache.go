@@ -0,0 +1,189 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"diskey/pkg/cluster/internal/glob"
+	"diskey/pkg/cluster/internal/lru"
+)
+
+type nearCacheOptions struct {
+	size   int
+	maxAge time.Duration
+}
+
+type NearCacheOption func(options *nearCacheOptions)
+
+// NearCacheOptionSize sets how many keys the near cache holds before evicting the least recently used. Defaults to
+// 10000.
+func NearCacheOptionSize(size int) NearCacheOption {
+	return func(options *nearCacheOptions) {
+		options.size = size
+	}
+}
+
+// NearCacheOptionMaxAge bounds how long a key is served from the near cache before it is read from its owner again.
+// It limits how stale a key can get if an invalidation is lost. Defaults to one minute.
+func NearCacheOptionMaxAge(maxAge time.Duration) NearCacheOption {
+	return func(options *nearCacheOptions) {
+		options.maxAge = maxAge
+	}
+}
+
+type NearCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+	Evictions     uint64
+}
+
+type nearCacheEntry struct {
+	expiresAt  time.Time
+	valueBytes []byte
+}
+
+// NearCache keeps recently read keys under a prefix in this process so that repeated reads skip the round trip to
+// the key owner.
+//
+// The near cache watches the prefix and drops a key as soon as its owner reports that it changed. Keys are also
+// dropped when their ttl runs out or after the max age, whichever is first.
+type NearCache struct {
+	cluster *Cluster
+	// cancel ends the watch of the prefix. stopped is closed once the watch is removed and the entries are dropped.
+	cancel     context.CancelFunc
+	stopped    chan struct{}
+	entries    *lru.Cache[string, nearCacheEntry]
+	prefix     string
+	options    nearCacheOptions
+	mutex      sync.Mutex
+	generation uint64
+	closed     bool
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	invalidations atomic.Uint64
+	evictions     atomic.Uint64
+}
+
+// NewNearCache creates a near cache for the keys under the prefix. It stays coherent until it is closed or the cluster
+// shuts down.
+func NewNearCache(cluster *Cluster, prefix string, options ...NearCacheOption) (*NearCache, error) {
+	ctx, cancel := context.WithCancel(cluster.ctx)
+	nearCache := &NearCache{
+		cluster: cluster,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+		prefix:  prefix,
+		options: nearCacheOptions{
+			size:   10000,
+			maxAge: time.Minute,
+		},
+		mutex: sync.Mutex{},
+	}
+
+	for index := range options {
+		options[index](&nearCache.options)
+	}
+
+	nearCache.entries = lru.New[string, nearCacheEntry](nearCache.options.size)
+
+	events, err := Watch(ctx, cluster, glob.QuoteMeta(prefix)+"*")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		defer close(nearCache.stopped)
+
+		for event := range events {
+			nearCache.Invalidate(event.Key)
+		}
+
+		// Without the watch nothing would invalidate the keys, so stop caching altogether.
+		nearCache.mutex.Lock()
+		nearCache.closed = true
+		nearCache.entries.Purge()
+		nearCache.mutex.Unlock()
+	}()
+
+	return nearCache, nil
+}
+
+// GetBytes gets the encoded value of the key from the near cache, or from the node that owns it on a miss.
+func (self *NearCache) GetBytes(ctx context.Context, key string) ([]byte, bool, error) {
+	now := time.Now()
+
+	self.mutex.Lock()
+	if entry, exists := self.entries.Get(key); exists {
+		if now.Before(entry.expiresAt) {
+			self.mutex.Unlock()
+			self.hits.Add(1)
+			return entry.valueBytes, true, nil
+		}
+		self.entries.Remove(key)
+	}
+	generation := self.generation
+	self.mutex.Unlock()
+
+	self.misses.Add(1)
+
+	response, err := self.cluster.getReply(ctx, key)
+	if err != nil || !response.Exists {
+		return nil, false, err
+	}
+
+	expiresAt := now.Add(self.options.maxAge)
+	if response.ExpiresAt != 0 && response.ExpiresAt < expiresAt.UnixNano() {
+		expiresAt = time.Unix(0, response.ExpiresAt)
+	}
+
+	self.mutex.Lock()
+	// Anything invalidated while the value was being read may have been this key, in which case the value is stale.
+	if self.generation == generation && !self.closed {
+		if self.entries.Add(key, nearCacheEntry{
+			expiresAt:  expiresAt,
+			valueBytes: response.ValueBytes,
+		}) {
+			self.evictions.Add(1)
+		}
+	}
+	self.mutex.Unlock()
+
+	return response.ValueBytes, true, nil
+}
+
+// Close removes the watch of the prefix from every node and drops the cached keys. Reads keep working afterwards, but
+// always go to the key owner.
+func (self *NearCache) Close() {
+	self.cancel()
+	<-self.stopped
+}
+
+// Invalidate drops the key from the near cache.
+func (self *NearCache) Invalidate(key string) {
+	self.mutex.Lock()
+	self.generation++
+	removed := self.entries.Remove(key)
+	self.mutex.Unlock()
+
+	if removed {
+		self.invalidations.Add(1)
+	}
+}
+
+func (self *NearCache) Prefix() string {
+	return self.prefix
+}
+
+func (self *NearCache) Stats() NearCacheStats {
+	return NearCacheStats{
+		Hits:          self.hits.Load(),
+		Misses:        self.misses.Load(),
+		Invalidations: self.invalidations.Load(),
+		Evictions:     self.evictions.Load(),
+	}
+}
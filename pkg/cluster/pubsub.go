@@ -0,0 +1,284 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"diskey/pkg/cluster/internal/glob"
+	"diskey/pkg/command"
+)
+
+const defaultSubscriptionBufferSize = 256
+
+// Message is a message published to a channel.
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+type subscribeOptions struct {
+	bufferSize   int
+	blockTimeout time.Duration
+}
+
+type SubscribeOption func(options *subscribeOptions)
+
+// SubscribeOptionBufferSize sets how many messages are buffered for the subscriber. Defaults to 256.
+func SubscribeOptionBufferSize(bufferSize int) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.bufferSize = bufferSize
+	}
+}
+
+// SubscribeOptionBlock waits up to the timeout for room in a full buffer before dropping a message. While waiting,
+// the publisher is held up as well, which slows down publishers to the pace of the subscriber.
+//
+// By default, messages are dropped as soon as the buffer is full.
+func SubscribeOptionBlock(timeout time.Duration) SubscribeOption {
+	return func(options *subscribeOptions) {
+		options.blockTimeout = timeout
+	}
+}
+
+// Subscription receives the messages published to a channel, or to every channel matching a pattern.
+type Subscription struct {
+	messages chan Message
+	// done is closed when the subscription context is done, to stop a delivery waiting for room in the buffer.
+	done    chan struct{}
+	channel string
+	options subscribeOptions
+	dropped atomic.Uint64
+	pattern bool
+	// mutex guards closed. Deliveries hold the read lock, so messages is not closed while one is in progress.
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// Messages returns the messages published to the subscription. It is closed when the subscription context is done.
+func (self *Subscription) Messages() <-chan Message {
+	return self.messages
+}
+
+// Dropped returns how many messages were dropped because the subscriber fell behind.
+func (self *Subscription) Dropped() uint64 {
+	return self.dropped.Load()
+}
+
+func (self *Subscription) matches(channel string) bool {
+	if self.pattern {
+		return glob.Match(self.channel, channel)
+	}
+	return self.channel == channel
+}
+
+// deliver returns whether the message was delivered.
+func (self *Subscription) deliver(message Message) bool {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	if self.closed {
+		return false
+	}
+
+	select {
+	case self.messages <- message:
+		return true
+	default:
+	}
+
+	if self.options.blockTimeout > 0 {
+		timer := time.NewTimer(self.options.blockTimeout)
+		defer timer.Stop()
+
+		select {
+		case self.messages <- message:
+			return true
+		case <-timer.C:
+		case <-self.done:
+			return false
+		}
+	}
+
+	self.dropped.Add(1)
+	return false
+}
+
+// close closes the messages once no delivery is in progress.
+func (self *Subscription) close() {
+	close(self.done)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.closed = true
+	close(self.messages)
+}
+
+type subscriptionRegistry struct {
+	subscriptions map[*Subscription]struct{}
+	mutex         sync.RWMutex
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	return &subscriptionRegistry{
+		subscriptions: map[*Subscription]struct{}{},
+		mutex:         sync.RWMutex{},
+	}
+}
+
+// deliverLocal delivers the message to the matching subscriptions on this node and returns how many received it.
+//
+// Deliveries happen after releasing the registry lock, so a subscriber waiting for room in its buffer does not hold
+// up subscribing and unsubscribing.
+func (self *subscriptionRegistry) deliverLocal(message Message) int {
+	matching := []*Subscription{}
+
+	self.mutex.RLock()
+	for subscription := range self.subscriptions {
+		if subscription.matches(message.Channel) {
+			matching = append(matching, subscription)
+		}
+	}
+	self.mutex.RUnlock()
+
+	receivers := 0
+	for index := range matching {
+		if matching[index].deliver(message) {
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+func (self *subscriptionRegistry) add(ctx context.Context, subscription *Subscription) {
+	self.mutex.Lock()
+	self.subscriptions[subscription] = struct{}{}
+	self.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		self.mutex.Lock()
+		delete(self.subscriptions, subscription)
+		self.mutex.Unlock()
+
+		subscription.close()
+	}()
+}
+
+type PublishArgs struct {
+	Channel string
+	Payload []byte
+}
+
+type PublishReply struct {
+	Receivers int
+}
+
+func (self ClusterCommandRpcHandlers) Publish(args PublishArgs, reply *PublishReply) error {
+	reply.Receivers = self.subscriptions.deliverLocal(Message{
+		Channel: args.Channel,
+		Payload: args.Payload,
+	})
+	return nil
+}
+
+func newPublishRequest(args PublishArgs, resp *PublishReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodePublish,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// Publish sends the payload to every subscriber of the channel on every node. Returns how many subscribers received
+// the message.
+//
+// Delivery is at most once. Messages are not stored, so subscribers only receive messages published while they are
+// subscribed, and a subscriber that falls behind drops messages. If some nodes cannot be reached, the receivers on
+// the other nodes are still counted and the errors are joined.
+func Publish(ctx context.Context, cluster *Cluster, channel string, payload []byte) (int, error) {
+	addresses := cluster.sortedAddresses()
+
+	receivers := make([]int, len(addresses))
+	errs := make([]error, len(addresses))
+
+	args := PublishArgs{
+		Channel: channel,
+		Payload: payload,
+	}
+
+	waitGroup := &sync.WaitGroup{}
+	for index := range addresses {
+		if addresses[index].String() == cluster.clusterServer.Address() {
+			receivers[index] = cluster.subscriptions.deliverLocal(Message{
+				Channel: channel,
+				Payload: payload,
+			})
+			continue
+		}
+
+		waitGroup.Add(1)
+		go func(index int) {
+			defer waitGroup.Done()
+
+			client := cluster.getClientByHostPort(addresses[index].Host, addresses[index].Port)
+			if client == nil {
+				// The node left after the addresses were read.
+				return
+			}
+
+			reply := &PublishReply{}
+			errs[index] = client.Send(ctx, newPublishRequest(args, reply))
+			receivers[index] = reply.Receivers
+		}(index)
+	}
+	waitGroup.Wait()
+
+	total := 0
+	for index := range receivers {
+		total += receivers[index]
+	}
+
+	return total, errors.Join(errs...)
+}
+
+// Subscribe receives the messages published to the channel anywhere in the cluster until the context is done.
+func Subscribe(ctx context.Context, cluster *Cluster, channel string, options ...SubscribeOption) *Subscription {
+	return cluster.subscribe(ctx, channel, false, options)
+}
+
+// PSubscribe receives the messages published to every channel matching the glob pattern anywhere in the cluster
+// until the context is done.
+func PSubscribe(ctx context.Context, cluster *Cluster, pattern string, options ...SubscribeOption) *Subscription {
+	return cluster.subscribe(ctx, pattern, true, options)
+}
+
+func (self *Cluster) subscribe(ctx context.Context, channel string, pattern bool, options []SubscribeOption) *Subscription {
+	subscription := &Subscription{
+		channel: channel,
+		pattern: pattern,
+		options: subscribeOptions{
+			bufferSize:   defaultSubscriptionBufferSize,
+			blockTimeout: 0,
+		},
+		dropped: atomic.Uint64{},
+		done:    make(chan struct{}),
+		mutex:   sync.RWMutex{},
+		closed:  false,
+	}
+
+	for index := range options {
+		options[index](&subscription.options)
+	}
+
+	subscription.messages = make(chan Message, subscription.options.bufferSize)
+
+	self.subscriptions.add(ctx, subscription)
+
+	return subscription
+}
@@ -0,0 +1,372 @@
+package cluster
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"diskey/pkg/cache"
+	"diskey/pkg/cluster/internal/glob"
+	"diskey/pkg/command"
+)
+
+const (
+	defaultScanCount = 10
+	// scanExaminedPerKey bounds how many keys a page examines on a node for each key it asks for, so that a page with a
+	// narrow pattern does not walk the whole key store.
+	scanExaminedPerKey = 10
+	// scanSessionIdleTimeout is how long a node keeps the position of a scan that is not resumed.
+	scanSessionIdleTimeout = time.Minute
+	// maxScanSessions bounds how many scans a node keeps the position of. The least recently used is dropped first.
+	maxScanSessions = 1024
+)
+
+// ErrInvalidCursor is returned by ScanPage for a cursor it did not return.
+var ErrInvalidCursor = errors.New("invalid scan cursor")
+
+type ScanArgs struct {
+	Pattern string
+	// Session resumes the scan where the previous page on this node stopped. Empty starts a new scan.
+	Session string
+	Count   int
+}
+
+type ScanReply struct {
+	Keys []string
+	// Session resumes the scan on the next page. Empty once Done.
+	Session string
+	Done    bool
+}
+
+func (self ClusterCommandRpcHandlers) Scan(args ScanArgs, reply *ScanReply) error {
+	reply.Keys, reply.Session, reply.Done = self.scanLocal(args.Pattern, args.Session, args.Count)
+	return nil
+}
+
+func newScanRequest(args ScanArgs, resp *ScanReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeScan,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// scanSession is where a scan paused on this node.
+type scanSession struct {
+	iterator *cache.Iterator
+	pattern  string
+	lastUsed time.Time
+}
+
+// scanSessions holds the scans paused on this node, so that each page picks up where the last one stopped instead of
+// walking the key store from the start.
+type scanSessions struct {
+	sessions map[string]scanSession
+	mutex    sync.Mutex
+}
+
+func newScanSessions() *scanSessions {
+	return &scanSessions{
+		sessions: map[string]scanSession{},
+		mutex:    sync.Mutex{},
+	}
+}
+
+// take removes the session so that only one page uses it at a time. A session that is unknown, for example because
+// it was idle for too long, starts over from the first key.
+func (self *scanSessions) take(id string, pattern string, keyStore cache.Cache) scanSession {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	now := time.Now()
+	for sessionID, session := range self.sessions {
+		if now.Sub(session.lastUsed) > scanSessionIdleTimeout {
+			delete(self.sessions, sessionID)
+		}
+	}
+
+	session, exists := self.sessions[id]
+	delete(self.sessions, id)
+	if !exists || session.pattern != pattern {
+		return scanSession{
+			iterator: keyStore.Iterator(),
+			pattern:  pattern,
+			lastUsed: now,
+		}
+	}
+
+	return session
+}
+
+// pause keeps the session to resume under the id.
+func (self *scanSessions) pause(id string, session scanSession) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if len(self.sessions) >= maxScanSessions {
+		var oldestID string
+		for sessionID, existing := range self.sessions {
+			if oldestID == "" || existing.lastUsed.Before(self.sessions[oldestID].lastUsed) {
+				oldestID = sessionID
+			}
+		}
+		delete(self.sessions, oldestID)
+	}
+
+	session.lastUsed = time.Now()
+	self.sessions[id] = session
+}
+
+// scanLocal returns up to count keys on this node matching the pattern, resuming the session if there is one. Like
+// redis SCAN, it stops early with fewer keys, possibly none, once it examined count*scanExaminedPerKey keys. Returns
+// the session to resume with, and whether there are no more matches after the returned keys.
+func (self *Cluster) scanLocal(pattern string, sessionID string, count int) ([]string, string, bool) {
+	prefix := glob.Prefix(pattern)
+	session := self.scans.take(sessionID, pattern, self.keyStore)
+
+	keys := []string{}
+	for examined := 0; len(keys) < count && examined < count*scanExaminedPerKey; examined++ {
+		key, _, ok := session.iterator.Next()
+		if !ok {
+			return keys, "", true
+		}
+
+		if strings.HasPrefix(key, prefix) && glob.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	if sessionID == "" {
+		sessionID = Uuid()
+	}
+	self.scans.pause(sessionID, session)
+
+	return keys, sessionID, false
+}
+
+// scanCursor is the position of a scan on every node, keyed by node address. Nodes are keyed by address rather than
+// position so that the cursor stays valid when nodes join or leave.
+type scanCursor struct {
+	// Sessions holds the session of each node whose scan is paused.
+	Sessions map[string]string
+	// Done holds the nodes that were scanned to the end.
+	Done []string
+}
+
+func decodeScanCursor(cursor string) (scanCursor, error) {
+	decoded := scanCursor{
+		Sessions: map[string]string{},
+		Done:     []string{},
+	}
+	if cursor == "" {
+		return decoded, nil
+	}
+
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return decoded, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if err := msgpack.Unmarshal(cursorBytes, &decoded); err != nil {
+		return decoded, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if decoded.Sessions == nil {
+		decoded.Sessions = map[string]string{}
+	}
+
+	return decoded, nil
+}
+
+func (self scanCursor) encode() (string, error) {
+	cursorBytes, err := msgpack.Marshal(self)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cursorBytes), nil
+}
+
+// ScanPage returns a page of up to count keys matching the glob pattern, along with the cursor for the next page.
+// Start with an empty cursor. A returned empty cursor means every node has been scanned. A page may hold fewer keys
+// than count, or none, before the scan is done, since each node examines a bounded number of keys per page.
+//
+// Every node that is not done yet is scanned in parallel for its share of the page. Each node keeps its position
+// between pages, so a full scan walks each key store once. The cursor holds the position of each node by address: a
+// node that joins during the scan is scanned from the start and a node that leaves is dropped, along with any of its
+// keys that were not returned yet. Like redis SCAN, keys set or deleted during the scan may or may not be returned and
+// a key may be returned more than once, for example after a node dropped the position of a scan that was idle for
+// too long and started over.
+func (self *Cluster) ScanPage(ctx context.Context, pattern string, cursor string, count int) ([]string, string, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	position, err := decodeScanCursor(cursor)
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	members := self.sortedAddresses()
+	pending := slices.DeleteFunc(slices.Clone(members), func(address Address) bool {
+		return slices.Contains(position.Done, address.String())
+	})
+
+	// Split the page between the nodes. Nodes past the count wait for a later page.
+	pending = pending[:min(len(pending), count)]
+	replies := make([]ScanReply, len(pending))
+	errs := make([]error, len(pending))
+
+	waitGroup := &sync.WaitGroup{}
+	for index := range pending {
+		nodeCount := count / len(pending)
+		if index < count%len(pending) {
+			nodeCount++
+		}
+
+		waitGroup.Add(1)
+		go func(index int, nodeCount int) {
+			defer waitGroup.Done()
+
+			session := position.Sessions[pending[index].String()]
+			replies[index], errs[index] = self.scanNode(ctx, pending[index], pattern, session, nodeCount)
+		}(index, nodeCount)
+	}
+	waitGroup.Wait()
+
+	keys := []string{}
+	for index := range pending {
+		if errs[index] != nil {
+			// The node is asked for the same page again next time.
+			continue
+		}
+
+		address := pending[index].String()
+		keys = append(keys, replies[index].Keys...)
+		if replies[index].Done {
+			delete(position.Sessions, address)
+			position.Done = append(position.Done, address)
+		} else {
+			position.Sessions[address] = replies[index].Session
+		}
+	}
+
+	// Forget nodes that left, so the cursor does not grow with every node that ever was a member.
+	position.Done = slices.DeleteFunc(position.Done, func(address string) bool {
+		return !slices.ContainsFunc(members, func(member Address) bool { return member.String() == address })
+	})
+	maps.DeleteFunc(position.Sessions, func(address string, _ string) bool {
+		return !slices.ContainsFunc(members, func(member Address) bool { return member.String() == address })
+	})
+
+	scanErr := errors.Join(errs...)
+	if scanErr == nil && len(position.Done) == len(members) {
+		return keys, "", nil
+	}
+
+	nextCursor, err := position.encode()
+	if err != nil {
+		return keys, cursor, err
+	}
+
+	return keys, nextCursor, scanErr
+}
+
+func (self *Cluster) scanNode(ctx context.Context, address Address, pattern string, session string, count int) (ScanReply, error) {
+	if address.String() == self.clusterServer.Address() {
+		keys, nextSession, done := self.scanLocal(pattern, session, count)
+		return ScanReply{Keys: keys, Session: nextSession, Done: done}, nil
+	}
+
+	client := self.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return ScanReply{}, fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	reply := &ScanReply{}
+	if err := client.Send(ctx, newScanRequest(ScanArgs{
+		Pattern: pattern,
+		Session: session,
+		Count:   count,
+	}, reply)); err != nil {
+		return ScanReply{}, err
+	}
+
+	return *reply, nil
+}
+
+func (self *Cluster) sortedAddresses() []Address {
+	self.clientsMutex.RLock()
+	addresses := slices.Clone(self.addresses)
+	self.clientsMutex.RUnlock()
+
+	slices.SortFunc(addresses, func(a Address, b Address) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	return addresses
+}
+
+// Scanner pages through every key in the cluster matching a glob pattern.
+//
+//	scanner := cluster.NewScanner(diskeyCluster, "user:*", 100)
+//	for scanner.Next(ctx) {
+//		for _, key := range scanner.Keys() {
+//			...
+//		}
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+type Scanner struct {
+	cluster *Cluster
+	err     error
+	pattern string
+	keys    []string
+	count   int
+	cursor  string
+	done    bool
+}
+
+func NewScanner(cluster *Cluster, pattern string, count int) *Scanner {
+	return &Scanner{
+		cluster: cluster,
+		pattern: pattern,
+		count:   count,
+	}
+}
+
+// Next fetches the next page of keys. Returns false when the scan is complete or failed.
+func (self *Scanner) Next(ctx context.Context) bool {
+	if self.done || self.err != nil {
+		return false
+	}
+
+	self.keys, self.cursor, self.err = self.cluster.ScanPage(ctx, self.pattern, self.cursor, self.count)
+	if self.err != nil {
+		return false
+	}
+
+	self.done = self.cursor == ""
+
+	return true
+}
+
+// Keys returns the current page of keys.
+func (self *Scanner) Keys() []string {
+	return self.keys
+}
+
+// Cursor returns the cursor for the next page, which can be passed to ScanPage to resume the scan later.
+func (self *Scanner) Cursor() string {
+	return self.cursor
+}
+
+func (self *Scanner) Err() error {
+	return self.err
+}
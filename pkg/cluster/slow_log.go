@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"diskey/pkg/command"
+)
+
+const (
+	defaultSlowLogThreshold = 10 * time.Millisecond
+	defaultSlowLogSize      = 128
+)
+
+// OptionSlowLog records operations that take at least the threshold in a log that keeps the latest size entries. A
+// threshold of zero records every operation and a size of zero turns the log off. Defaults to 10ms and 128 entries.
+func OptionSlowLog(threshold time.Duration, size int) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.slowLog = newSlowLog(threshold, size)
+	}
+}
+
+// SlowLogEntry is an operation that took longer than the slow log threshold.
+//
+// Peer is the node the operation was sent to, or empty when it ran against this node's key store. BatchSize is the
+// number of requests in the batch the operation was sent in, or zero when it was not batched. ID increases by one for
+// every entry recorded by the node, so gaps show entries that were overwritten.
+type SlowLogEntry struct {
+	Time      time.Time
+	Command   string
+	Key       string
+	Peer      string
+	ID        uint64
+	Duration  time.Duration
+	BatchSize int
+}
+
+// slowLog is a ring buffer of the latest slow operations.
+type slowLog struct {
+	entries   []SlowLogEntry
+	threshold time.Duration
+	next      int
+	nextID    uint64
+	mutex     sync.Mutex
+}
+
+func newSlowLog(threshold time.Duration, size int) *slowLog {
+	return &slowLog{
+		entries:   make([]SlowLogEntry, 0, size),
+		threshold: threshold,
+		next:      0,
+		nextID:    0,
+		mutex:     sync.Mutex{},
+	}
+}
+
+// record adds the entry if the operation took at least the threshold, overwriting the oldest entry once full.
+func (self *slowLog) record(entry SlowLogEntry) {
+	if entry.Duration < self.threshold {
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if cap(self.entries) == 0 {
+		return
+	}
+
+	entry.ID = self.nextID
+	self.nextID++
+
+	if len(self.entries) < cap(self.entries) {
+		self.entries = append(self.entries, entry)
+		return
+	}
+
+	self.entries[self.next] = entry
+	self.next = (self.next + 1) % len(self.entries)
+}
+
+// latest returns up to limit entries, newest first. A limit of zero or less returns every entry.
+func (self *slowLog) latest(limit int) []SlowLogEntry {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	// Entries before next were written after the entries from next onwards.
+	entries := append(slices.Clone(self.entries[self.next:]), self.entries[:self.next]...)
+	slices.Reverse(entries)
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
+
+func (self *slowLog) reset() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.entries = self.entries[:0]
+	self.next = 0
+}
+
+// recordSlow records the operation that started at start if it was slow.
+func (self *Cluster) recordSlow(start time.Time, command string, key string, peer string, batchSize int) {
+	self.slowLog.record(SlowLogEntry{
+		Time:      start,
+		Command:   command,
+		Key:       key,
+		Peer:      peer,
+		ID:        0,
+		Duration:  time.Since(start),
+		BatchSize: batchSize,
+	})
+}
+
+type SlowLogArgs struct {
+	Limit int
+	Reset bool
+}
+
+type SlowLogReply struct {
+	Entries []SlowLogEntry
+}
+
+// SlowLog returns the latest slow operations on this node, and clears them when asked to.
+func (self ClusterCommandRpcHandlers) SlowLog(args SlowLogArgs, reply *SlowLogReply) error {
+	reply.Entries = self.slowLog.latest(args.Limit)
+	if args.Reset {
+		self.slowLog.reset()
+	}
+	return nil
+}
+
+// NewSlowLogRequest builds the request for the slow log of a node. Exported for tools that talk to a node directly.
+func NewSlowLogRequest(args SlowLogArgs, resp *SlowLogReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeSlowLog,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// LocalSlowLog returns up to limit of the latest slow operations on this node, newest first.
+func (self *Cluster) LocalSlowLog(limit int) []SlowLogEntry {
+	return self.slowLog.latest(limit)
+}
+
+// ResetSlowLog clears the slow log of this node.
+func (self *Cluster) ResetSlowLog() {
+	self.slowLog.reset()
+}
+
+// NodeSlowLog returns up to limit of the latest slow operations on the node at the address, newest first.
+func NodeSlowLog(ctx context.Context, cluster *Cluster, address Address, limit int) ([]SlowLogEntry, error) {
+	if address.String() == cluster.clusterServer.Address() {
+		return cluster.LocalSlowLog(limit), nil
+	}
+
+	client := cluster.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return nil, fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	reply := &SlowLogReply{}
+	if err := client.Send(ctx, NewSlowLogRequest(SlowLogArgs{Limit: limit, Reset: false}, reply)); err != nil {
+		return nil, err
+	}
+
+	return reply.Entries, nil
+}
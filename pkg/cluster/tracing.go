@@ -0,0 +1,35 @@
+package cluster
+
+import (
+	"context"
+
+	"diskey/pkg/trace"
+)
+
+// OptionTracer records spans for the requests this node makes and serves. Without it, trace ids are still propagated
+// to other nodes and added to logs, but no spans are exported.
+func OptionTracer(tracer *trace.Tracer) func(clusterClient *Cluster) {
+	return func(clusterClient *Cluster) {
+		clusterClient.tracer = tracer
+	}
+}
+
+// startKeySpan starts the span of a key request made by this node, which the key owner continues.
+func (self *Cluster) startKeySpan(ctx context.Context, name string, key string) (context.Context, *trace.Span) {
+	ctx, span := self.tracer.Start(ctx, name, trace.SpanKindClient)
+	span.SetAttribute("key", key)
+	return ctx, span
+}
+
+// startSpan starts the span of a request handled by this node, continuing the trace of the caller when there is one.
+func (self ClusterCommandRpcHandlers) startSpan(parent trace.SpanContext, name string, key string) (context.Context, *trace.Span) {
+	ctx := self.ctx
+	if parent.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, parent)
+	}
+
+	ctx, span := self.tracer.Start(ctx, name, trace.SpanKindServer)
+	span.SetAttribute("key", key)
+
+	return ctx, span
+}
@@ -0,0 +1,449 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/batcher"
+	"diskey/pkg/cluster/internal/glob"
+	"diskey/pkg/command"
+)
+
+const (
+	// eventQueueSize bounds the events waiting to be dispatched. Key store callbacks never block, so events are
+	// dropped once the queue is full.
+	eventQueueSize = 4096
+	// maxEventBatchSize bounds how many events are sent to a watching node in one call. It also bounds the events, and
+	// the replica drops, waiting to be sent to each node.
+	maxEventBatchSize = 256
+	// watchBufferSize is the number of events buffered for each watch. Events are dropped for watchers that fall
+	// further behind.
+	watchBufferSize = 1024
+
+	watchRequestTimeout = time.Second
+)
+
+type EventType uint
+
+const (
+	EventSet = EventType(iota + 1)
+	EventDeleted
+	EventExpired
+	EventEvicted
+)
+
+func (self EventType) String() string {
+	switch self {
+	case EventSet:
+		return "Set"
+	case EventDeleted:
+		return "Deleted"
+	case EventExpired:
+		return "Expired"
+	case EventEvicted:
+		return "Evicted"
+	default:
+		return "EventType"
+	}
+}
+
+// Event is a change to a key on the node that owns it.
+type Event struct {
+	Key  string
+	Type EventType
+}
+
+// enqueueEvent queues the event for dispatch without blocking. It is called from key store callbacks, which run while
+// the key store holds its locks.
+func enqueueEvent(events chan<- Event, event Event) {
+	select {
+	case events <- event:
+	default:
+		log.Warn().Str("key", event.Key).Stringer("type", event.Type).Msg("event queue full, dropping event")
+	}
+}
+
+type localWatch struct {
+	events  chan Event
+	pattern string
+}
+
+type remoteWatch struct {
+	pattern string
+	address Address
+}
+
+// watchRegistry tracks the watches on this node and the watches other nodes have registered with it.
+type watchRegistry struct {
+	local  map[string]localWatch
+	remote map[string]remoteWatch
+	mutex  sync.RWMutex
+}
+
+func newWatchRegistry() *watchRegistry {
+	return &watchRegistry{
+		local:  map[string]localWatch{},
+		remote: map[string]remoteWatch{},
+		mutex:  sync.RWMutex{},
+	}
+}
+
+func matchesWatch(pattern string, key string) bool {
+	return strings.HasPrefix(key, glob.Prefix(pattern)) && glob.Match(pattern, key)
+}
+
+// dispatchLocal delivers the events to the matching watches on this node.
+func (self *watchRegistry) dispatchLocal(events []Event) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	for id, watch := range self.local {
+		for index := range events {
+			if !matchesWatch(watch.pattern, events[index].Key) {
+				continue
+			}
+
+			select {
+			case watch.events <- events[index]:
+			default:
+				log.Warn().Str("watch", id).Str("key", events[index].Key).Msg("watcher too slow, dropping event")
+			}
+		}
+	}
+}
+
+// remoteEvents groups the events by the watching nodes that have a matching watch.
+func (self *watchRegistry) remoteEvents(events []Event) map[Address][]Event {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	eventsByAddress := map[Address][]Event{}
+	for index := range events {
+		matched := map[Address]bool{}
+		for _, watch := range self.remote {
+			if matched[watch.address] || !matchesWatch(watch.pattern, events[index].Key) {
+				continue
+			}
+			matched[watch.address] = true
+			eventsByAddress[watch.address] = append(eventsByAddress[watch.address], events[index])
+		}
+	}
+
+	return eventsByAddress
+}
+
+func (self *watchRegistry) removeAddress(address Address) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for id, watch := range self.remote {
+		if watch.address.Host == address.Host && watch.address.Port == address.Port {
+			delete(self.remote, id)
+		}
+	}
+}
+
+// dispatchEvents delivers queued events to local watches and to the nodes watching them until the context is done.
+func (self *Cluster) dispatchEvents(ctx context.Context, events <-chan Event) {
+	batch := make([]Event, 0, maxEventBatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			batch = append(batch[:0], event)
+		}
+
+		// Pick up whatever else is already queued so that watching nodes receive events in batches.
+	drain:
+		for len(batch) < maxEventBatchSize {
+			select {
+			case event := <-events:
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+
+		self.watches.dispatchLocal(batch)
+		self.dropReplicas(ctx, batch)
+
+		for address, addressEvents := range self.watches.remoteEvents(batch) {
+			queue := self.peerQueueFor(address)
+			for index := range addressEvents {
+				select {
+				case queue.events <- addressEvents[index]:
+				default:
+					log.Ctx(ctx).Warn().Str("address", address.String()).Str("key", addressEvents[index].Key).Msg("event queue of watcher full, dropping event")
+				}
+			}
+		}
+	}
+}
+
+// peerQueue holds what is waiting to be sent to one peer. Each peer has queues of its own, so a slow or dead peer only
+// holds up what is sent to it. The queues are bounded and callers drop what does not fit.
+type peerQueue struct {
+	cancel context.CancelFunc
+	// events are sent to the peer because it watches their keys.
+	events chan<- Event
+	// dropKeys are replicated keys the peer should drop its copy of.
+	dropKeys chan<- string
+}
+
+// peerQueues holds the queue of every peer.
+type peerQueues struct {
+	queues map[string]peerQueue
+	mutex  sync.Mutex
+}
+
+func newPeerQueues() *peerQueues {
+	return &peerQueues{
+		queues: map[string]peerQueue{},
+		mutex:  sync.Mutex{},
+	}
+}
+
+// peerQueueFor returns the queue of the peer, starting it on first use.
+func (self *Cluster) peerQueueFor(address Address) peerQueue {
+	self.peerQueues.mutex.Lock()
+	defer self.peerQueues.mutex.Unlock()
+
+	if existing, exists := self.peerQueues.queues[address.String()]; exists {
+		return existing
+	}
+
+	ctx, cancel := context.WithCancel(self.ctx)
+	queue := peerQueue{
+		cancel: cancel,
+		events: batcher.Run(maxEventBatchSize, func(events []Event) {
+			if err := self.sendEvents(ctx, address, events); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("address", address.String()).Int("events", len(events)).Msg("failed to send events to watcher")
+			}
+		}, batcher.OptionContext(ctx)),
+		dropKeys: batcher.Run(maxEventBatchSize, func(keys []string) {
+			if err := self.sendDropReplicas(ctx, address, keys); err != nil {
+				log.Ctx(ctx).Warn().Err(err).Str("address", address.String()).Int("keys", len(keys)).Msg("failed to drop replicas")
+			}
+		}, batcher.OptionContext(ctx)),
+	}
+	self.peerQueues.queues[address.String()] = queue
+
+	return queue
+}
+
+// stopPeerQueue stops the queue of a peer that left, dropping whatever was still waiting to be sent to it.
+func (self *Cluster) stopPeerQueue(address Address) {
+	self.peerQueues.mutex.Lock()
+	defer self.peerQueues.mutex.Unlock()
+
+	if existing, exists := self.peerQueues.queues[address.String()]; exists {
+		existing.cancel()
+		delete(self.peerQueues.queues, address.String())
+	}
+}
+
+func (self *Cluster) sendEvents(ctx context.Context, address Address, events []Event) error {
+	client := self.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, watchRequestTimeout)
+	defer cancel()
+
+	return client.Send(ctx, newEventsRequest(EventsArgs{Events: events}, &EventsReply{}))
+}
+
+type EventsArgs struct {
+	Events []Event
+}
+
+type EventsReply struct{}
+
+// Events receives events from the nodes that own the keys this node is watching.
+func (self ClusterCommandRpcHandlers) Events(args EventsArgs, reply *EventsReply) error {
+	self.watches.dispatchLocal(args.Events)
+	return nil
+}
+
+func newEventsRequest(args EventsArgs, resp *EventsReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeEvents,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+type WatchArgs struct {
+	ID      string
+	Pattern string
+	Host    string
+	Port    string
+}
+
+type WatchReply struct{}
+
+// Watch registers a watch from another node. Events for matching keys owned by this node are sent to that node.
+func (self ClusterCommandRpcHandlers) Watch(args WatchArgs, reply *WatchReply) error {
+	self.watches.mutex.Lock()
+	defer self.watches.mutex.Unlock()
+
+	self.watches.remote[args.ID] = remoteWatch{
+		pattern: args.Pattern,
+		address: Address{
+			Host: args.Host,
+			Port: args.Port,
+			Slot: Slot(args.Host + ":" + args.Port),
+		},
+	}
+
+	return nil
+}
+
+func newWatchRequest(args WatchArgs, resp *WatchReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeWatch,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+type UnwatchArgs struct {
+	ID string
+}
+
+type UnwatchReply struct{}
+
+func (self ClusterCommandRpcHandlers) Unwatch(args UnwatchArgs, reply *UnwatchReply) error {
+	self.watches.mutex.Lock()
+	defer self.watches.mutex.Unlock()
+
+	delete(self.watches.remote, args.ID)
+
+	return nil
+}
+
+func newUnwatchRequest(args UnwatchArgs, resp *UnwatchReply) command.Request {
+	return command.Request{
+		Opcode: command.OpcodeUnwatch,
+		Args:   args,
+		Reply:  resp,
+	}
+}
+
+// Watch streams set, delete, expire and evict events for every key in the cluster matching the glob pattern. Pass a
+// key to watch a single key or a pattern such as "user:*" to watch a prefix.
+//
+// The watch is registered with every node, and the nodes that own matching keys send their events to this node.
+// Nodes that join later are told about the watch when they join. The watch ends and the channel is closed when the
+// context is done.
+//
+// Events are delivered at most once. A watcher that falls too far behind misses events, so consumers that need an
+// exact view should treat a watch as an invalidation signal and read the key again.
+func Watch(ctx context.Context, cluster *Cluster, pattern string) (<-chan Event, error) {
+	id := Uuid()
+	events := make(chan Event, watchBufferSize)
+
+	cluster.watches.mutex.Lock()
+	cluster.watches.local[id] = localWatch{
+		events:  events,
+		pattern: pattern,
+	}
+	cluster.watches.mutex.Unlock()
+
+	args := WatchArgs{
+		ID:      id,
+		Pattern: pattern,
+		Host:    cluster.clusterServer.Host(),
+		Port:    cluster.clusterServer.Port(),
+	}
+
+	errs := []error{}
+	for _, address := range cluster.sortedAddresses() {
+		if address.String() == cluster.clusterServer.Address() {
+			continue
+		}
+		if err := cluster.sendWatch(ctx, address, args); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		cluster.unwatch(context.WithoutCancel(ctx), id)
+		close(events)
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		cluster.unwatch(context.WithoutCancel(ctx), id)
+
+		// No more events can be delivered once the watch is removed from the registry.
+		close(events)
+	}()
+
+	return events, nil
+}
+
+func (self *Cluster) sendWatch(ctx context.Context, address Address, args WatchArgs) error {
+	client := self.getClientByHostPort(address.Host, address.Port)
+	if client == nil {
+		return fmt.Errorf("no connection to node %s", address.String())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, watchRequestTimeout)
+	defer cancel()
+
+	return client.Send(ctx, newWatchRequest(args, &WatchReply{}))
+}
+
+// unwatch removes the watch on this node and tells the other nodes to stop sending its events.
+func (self *Cluster) unwatch(ctx context.Context, id string) {
+	self.watches.mutex.Lock()
+	delete(self.watches.local, id)
+	self.watches.mutex.Unlock()
+
+	for _, address := range self.sortedAddresses() {
+		if address.String() == self.clusterServer.Address() {
+			continue
+		}
+
+		client := self.getClientByHostPort(address.Host, address.Port)
+		if client == nil {
+			continue
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, watchRequestTimeout)
+		if err := client.Send(requestCtx, newUnwatchRequest(UnwatchArgs{ID: id}, &UnwatchReply{})); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("address", address.String()).Msg("failed to remove watch")
+		}
+		cancel()
+	}
+}
+
+// registerWatches registers every watch on this node with a node that just joined.
+func (self *Cluster) registerWatches(ctx context.Context, address Address) {
+	self.watches.mutex.RLock()
+	argsList := make([]WatchArgs, 0, len(self.watches.local))
+	for id, watch := range self.watches.local {
+		argsList = append(argsList, WatchArgs{
+			ID:      id,
+			Pattern: watch.pattern,
+			Host:    self.clusterServer.Host(),
+			Port:    self.clusterServer.Port(),
+		})
+	}
+	self.watches.mutex.RUnlock()
+
+	for index := range argsList {
+		if err := self.sendWatch(ctx, address, argsList[index]); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("address", address.String()).Msg("failed to register watch")
+		}
+	}
+}
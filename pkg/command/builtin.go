@@ -40,9 +40,9 @@ type PingRequest = Request
 func NewPingRequest() PingRequest {
 	var zero int
 	return PingRequest{
-		Name:  "BuiltIn.Ping",
-		Args:  struct{}{},
-		Reply: &zero,
+		Opcode: OpcodePing,
+		Args:   struct{}{},
+		Reply:  &zero,
 	}
 }
 
@@ -50,8 +50,8 @@ type PipelineRequest = Request
 
 func NewPipelineRequest() PipelineRequest {
 	return PipelineRequest{
-		Name:  "BuiltIn.Pipeline",
-		Args:  []any{},
-		Reply: &[]any{},
+		Opcode: OpcodePipeline,
+		Args:   []any{},
+		Reply:  &[]any{},
 	}
 }
@@ -1,29 +1,33 @@
 package command
 
 import (
-	"context"
 	"fmt"
-	"net/rpc"
+
+	"diskey/pkg/trace"
 )
 
 type Request struct {
-	Args  any
-	Reply any
-	Name  string
+	Args   any
+	Reply  any
+	Opcode Opcode
+	// Trace is the span the request belongs to. Requests sent on their own take it from the context when it is not
+	// set. Requests sent inside a batch keep their own, since each may belong to a different trace.
+	Trace trace.SpanContext
 }
 
-func Send(_ context.Context, rpcClient *rpc.Client, request Request) error {
-	if request.Name == "" {
-		return fmt.Errorf("request name cannot be blank")
+// Validate reports whether the request can be sent.
+func (self Request) Validate() error {
+	if self.Opcode == 0 {
+		return fmt.Errorf("request opcode cannot be zero")
 	}
 
-	if request.Args == nil {
+	if self.Args == nil {
 		return fmt.Errorf("request args cannot be nil")
 	}
 
-	if request.Reply == nil {
+	if self.Reply == nil {
 		return fmt.Errorf("request reply cannot be nil")
 	}
 
-	return rpcClient.Call(request.Name, request.Args, request.Reply)
+	return nil
 }
@@ -0,0 +1,96 @@
+package command
+
+import "fmt"
+
+// Opcode identifies the handler of a request on the wire. Opcodes are part of the protocol between nodes, so existing
+// values must never change. Add new opcodes at the end of their block.
+type Opcode uint16
+
+// Built in opcodes are handled by every server.
+const (
+	OpcodePing = Opcode(iota + 1)
+	OpcodePipeline
+)
+
+// OpcodeClusterStart is the first opcode of the cluster commands.
+const OpcodeClusterStart = Opcode(64)
+
+const (
+	OpcodeGet = OpcodeClusterStart + Opcode(iota)
+	OpcodeSet
+	OpcodeDelete
+	OpcodeBatch
+	OpcodeScan
+	OpcodeDeletePattern
+	OpcodePublish
+	OpcodeEvents
+	OpcodeWatch
+	OpcodeUnwatch
+	OpcodeHotKeys
+	OpcodeReplicate
+	OpcodeDropReplicas
+	OpcodeAcquireLock
+	OpcodeRefreshLock
+	OpcodeReleaseLock
+	OpcodeGetOrLease
+	OpcodeReleaseLoadLease
+	OpcodeSlowLog
+	OpcodeMigrate
+	OpcodeSyncLockTokens
+)
+
+// OpcodeUserStart is the first opcode free for handlers outside of this module.
+const OpcodeUserStart = Opcode(1024)
+
+func (self Opcode) String() string {
+	switch self {
+	case OpcodePing:
+		return "Ping"
+	case OpcodePipeline:
+		return "Pipeline"
+	case OpcodeGet:
+		return "Get"
+	case OpcodeSet:
+		return "Set"
+	case OpcodeDelete:
+		return "Delete"
+	case OpcodeBatch:
+		return "Batch"
+	case OpcodeScan:
+		return "Scan"
+	case OpcodeDeletePattern:
+		return "DeletePattern"
+	case OpcodePublish:
+		return "Publish"
+	case OpcodeEvents:
+		return "Events"
+	case OpcodeWatch:
+		return "Watch"
+	case OpcodeUnwatch:
+		return "Unwatch"
+	case OpcodeHotKeys:
+		return "HotKeys"
+	case OpcodeReplicate:
+		return "Replicate"
+	case OpcodeDropReplicas:
+		return "DropReplicas"
+	case OpcodeAcquireLock:
+		return "AcquireLock"
+	case OpcodeRefreshLock:
+		return "RefreshLock"
+	case OpcodeReleaseLock:
+		return "ReleaseLock"
+	case OpcodeGetOrLease:
+		return "GetOrLease"
+	case OpcodeReleaseLoadLease:
+		return "ReleaseLoadLease"
+	case OpcodeSlowLog:
+		return "SlowLog"
+	case OpcodeMigrate:
+		return "Migrate"
+	case OpcodeSyncLockTokens:
+		return "SyncLockTokens"
+	default:
+		return fmt.Sprintf("Opcode(%d)", uint16(self))
+	}
+}
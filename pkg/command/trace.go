@@ -0,0 +1,25 @@
+package command
+
+import (
+	"diskey/pkg/trace"
+)
+
+// NewTrace returns the span context as sent in gRPC requests, or nil if it is not valid.
+func NewTrace(spanContext trace.SpanContext) *Trace {
+	if !spanContext.IsValid() {
+		return nil
+	}
+
+	return &Trace{
+		TraceId: spanContext.TraceID[:],
+		SpanId:  spanContext.SpanID[:],
+	}
+}
+
+// SpanContext returns the span context sent in a gRPC request. It is not valid if the request carried no trace.
+func (self *Trace) SpanContext() trace.SpanContext {
+	var spanContext trace.SpanContext
+	copy(spanContext.TraceID[:], self.GetTraceId())
+	copy(spanContext.SpanID[:], self.GetSpanId())
+	return spanContext
+}
@@ -2,10 +2,12 @@ package diskey
 
 import (
 	"context"
+	"time"
 
 	"diskey/pkg/cache"
 	"diskey/pkg/cluster"
 	"diskey/pkg/discovery"
+	"diskey/pkg/rpc"
 )
 
 type clientContextKey struct{}
@@ -14,6 +16,18 @@ type Config struct {
 	Host               string
 	ServerToServerPort string
 	MemberListPort     string
+	// Codec encodes values written by this client. Defaults to cache.Msgpack. Values written by clients using other
+	// codecs are still decoded.
+	Codec cache.Codec
+	// Compressor compresses values written by this client of at least CompressionThreshold bytes. Compression is off
+	// when nil.
+	Compressor           cache.Compressor
+	CompressionThreshold int
+	// MaxDecompressedSize bounds how large a compressed value may grow when this client reads it. Defaults to
+	// cache.DefaultMaxDecompressedSize.
+	MaxDecompressedSize int
+	// Transport is how other nodes connect to this one. Defaults to rpc.TransportFramed.
+	Transport rpc.Transport
 }
 
 type Client struct {
@@ -21,22 +35,42 @@ type Client struct {
 }
 
 func NewClient(ctx context.Context, config Config, disco discovery.Discovery) Client {
+	options := []cluster.Option{
+		cluster.OptionDiscovery(disco),
+		cluster.OptionMemberListPort(config.MemberListPort),
+		cluster.OptionTransport(config.Transport),
+	}
+	if config.Codec != nil {
+		options = append(options, cluster.OptionCodec(config.Codec))
+	}
+	if config.Compressor != nil {
+		options = append(options, cluster.OptionCompression(config.Compressor, config.CompressionThreshold))
+	}
+	if config.MaxDecompressedSize > 0 {
+		options = append(options, cluster.OptionMaxDecompressedSize(config.MaxDecompressedSize))
+	}
+
 	return Client{
 		diskeyCluster: cluster.NewCluster(
 			ctx,
 			config.Host,
 			config.ServerToServerPort,
-			cluster.OptionDiscovery(disco),
-			cluster.OptionMemberListPort(config.MemberListPort),
+			options...,
 		),
 	}
 }
 
+// Cluster returns the cluster node backing the client. Used by packages building on top of the diskey API.
+func (self Client) Cluster() *cluster.Cluster {
+	return self.diskeyCluster
+}
+
 func WithContext(ctx context.Context, client Client) context.Context {
 	return context.WithValue(ctx, clientContextKey{}, client)
 }
 
-func fromContext(ctx context.Context) Client {
+// FromContext returns the Client added by WithContext(). Panics if there is no Client in the Context.
+func FromContext(ctx context.Context) Client {
 	if client, ok := ctx.Value(clientContextKey{}).(Client); ok {
 		return client
 	}
@@ -44,13 +78,73 @@ func fromContext(ctx context.Context) Client {
 }
 
 func Get[T cache.Value](ctx context.Context, key string) (T, bool) {
-	return cluster.Get[T](fromContext(ctx).diskeyCluster, key)
+	return cluster.Get[T](ctx, FromContext(ctx).diskeyCluster, key)
 }
 
 func Set[T cache.Value](ctx context.Context, key string, value T) {
-	_ = cluster.Set[T](fromContext(ctx).diskeyCluster, key, value)
+	_ = cluster.Set[T](ctx, FromContext(ctx).diskeyCluster, key, value)
 }
 
 func Delete(ctx context.Context, key string) {
-	_ = cluster.Delete(fromContext(ctx).diskeyCluster, key)
+	_ = cluster.Delete(ctx, FromContext(ctx).diskeyCluster, key)
+}
+
+// MGet gets many keys with one round trip per node that owns any of the keys.
+// Results are in the same order as the keys.
+func MGet[T cache.Value](ctx context.Context, keys ...string) []cluster.GetResult[T] {
+	return cluster.MGet[T](ctx, FromContext(ctx).diskeyCluster, keys...)
+}
+
+// MSet sets many keys with one round trip per node that owns any of the keys.
+// Errors are in the same order as the entries.
+func MSet[T cache.Value](ctx context.Context, entries ...cluster.Entry[T]) []error {
+	return cluster.MSet[T](ctx, FromContext(ctx).diskeyCluster, entries...)
+}
+
+// MDelete deletes many keys with one round trip per node that owns any of the keys.
+// Errors are in the same order as the keys.
+func MDelete(ctx context.Context, keys ...string) []error {
+	return cluster.MDelete(ctx, FromContext(ctx).diskeyCluster, keys...)
+}
+
+// GetOrLoad gets the key or, if it does not exist, calls the loader and sets the key with the loaded value for the ttl.
+// Concurrent misses for the same key anywhere in the cluster share a single call to a loader.
+func GetOrLoad[T cache.Value](ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (T, error)) (T, error) {
+	return cluster.GetOrLoad[T](ctx, FromContext(ctx).diskeyCluster, key, ttl, loader)
+}
+
+// Scan pages through every key in the cluster matching the glob pattern, fetching up to count keys per page.
+func Scan(ctx context.Context, pattern string, count int) *cluster.Scanner {
+	return cluster.NewScanner(FromContext(ctx).diskeyCluster, pattern, count)
+}
+
+// DeleteByPrefix deletes every key in the cluster starting with the prefix. Returns the number of keys deleted.
+func DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	return cluster.DeleteByPrefix(ctx, FromContext(ctx).diskeyCluster, prefix)
+}
+
+// DeleteByPattern deletes every key in the cluster matching the glob pattern. Returns the number of keys deleted.
+func DeleteByPattern(ctx context.Context, pattern string) (int, error) {
+	return cluster.DeleteByPattern(ctx, FromContext(ctx).diskeyCluster, pattern)
+}
+
+// Watch streams changes to every key in the cluster matching the glob pattern until the context is done.
+func Watch(ctx context.Context, pattern string) (<-chan cluster.Event, error) {
+	return cluster.Watch(ctx, FromContext(ctx).diskeyCluster, pattern)
+}
+
+// Publish sends the payload to every subscriber of the channel in the cluster. Returns how many subscribers received
+// the message.
+func Publish(ctx context.Context, channel string, payload []byte) (int, error) {
+	return cluster.Publish(ctx, FromContext(ctx).diskeyCluster, channel, payload)
+}
+
+// Subscribe receives the messages published to the channel until the context is done.
+func Subscribe(ctx context.Context, channel string, options ...cluster.SubscribeOption) *cluster.Subscription {
+	return cluster.Subscribe(ctx, FromContext(ctx).diskeyCluster, channel, options...)
+}
+
+// PSubscribe receives the messages published to every channel matching the glob pattern until the context is done.
+func PSubscribe(ctx context.Context, pattern string, options ...cluster.SubscribeOption) *cluster.Subscription {
+	return cluster.PSubscribe(ctx, FromContext(ctx).diskeyCluster, pattern, options...)
 }
@@ -0,0 +1,313 @@
+package diskey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/cache"
+	"diskey/pkg/cluster"
+)
+
+type tableOptions struct {
+	encoding         cache.Encoding
+	upgrades         map[uint32]tableUpgrade
+	nearCacheOptions []cluster.NearCacheOption
+	ttl              time.Duration
+	nearCacheEnabled bool
+}
+
+type TableOption func(options *tableOptions)
+
+// tableUpgrade upgrades values from the shape of one version to the shape of the next.
+type tableUpgrade struct {
+	oldType reflect.Type
+	newType reflect.Type
+	// decode decodes a value written at the version of the upgrade and upgrades it.
+	decode func(encoding cache.Encoding, key string, valueBytes []byte) (any, error)
+	// upgrade upgrades a value returned by the upgrade from the version before.
+	upgrade func(old any) (any, error)
+}
+
+// TableOptionTTL sets the ttl used by Table.Set. Defaults to never expiring keys.
+func TableOptionTTL(ttl time.Duration) TableOption {
+	return func(options *tableOptions) {
+		options.ttl = ttl
+	}
+}
+
+// TableOptionCodec sets the codec values of the table are encoded with. Defaults to the codec of the client.
+func TableOptionCodec(codec cache.Codec) TableOption {
+	return func(options *tableOptions) {
+		options.encoding.Codec = codec
+	}
+}
+
+// TableOptionCompression compresses values of the table of at least threshold bytes. Defaults to the compression of
+// the client.
+func TableOptionCompression(compressor cache.Compressor, threshold int) TableOption {
+	return func(options *tableOptions) {
+		options.encoding.Compressor = compressor
+		options.encoding.CompressionThreshold = threshold
+	}
+}
+
+// TableOptionEncryption encrypts values of the table with the primary key of the keyring, so that other nodes only ever
+// hold ciphertext. Values can only be read by tables holding the key they were encrypted with. Use a separate keyring
+// for each table that needs one.
+func TableOptionEncryption(keyring *cache.Keyring) TableOption {
+	return func(options *tableOptions) {
+		options.encoding.Keyring = keyring
+	}
+}
+
+// TableOptionVersion records the schema version with every value written through the table. Bump it whenever fields of
+// T are added, removed or renamed. Values written at any other version are treated as misses, unless there is an
+// upgrade for their version. Defaults to 0, the version of values written without one.
+func TableOptionVersion(version uint32) TableOption {
+	return func(options *tableOptions) {
+		options.encoding.Version = version
+	}
+}
+
+// TableOptionNearCache keeps recently read keys of the table in this process. Repeated reads of a key are served
+// locally until the key changes on its owner.
+func TableOptionNearCache(options ...cluster.NearCacheOption) TableOption {
+	return func(tableOptions *tableOptions) {
+		tableOptions.nearCacheEnabled = true
+		tableOptions.nearCacheOptions = options
+	}
+}
+
+// Table is a typed handle to every key under a prefix.
+//
+// Values written through a Table carry a fingerprint of T. Reading a key back as any other type returns an error
+// wrapping cache.ErrTypeMismatch rather than quietly reporting the key as missing.
+type Table[T cache.Value] struct {
+	diskeyCluster *cluster.Cluster
+	nearCache     *cluster.NearCache
+	prefix        string
+	options       tableOptions
+}
+
+// NewTable creates a handle to the keys under the prefix. Create it once and share it. Close a table with a near cache
+// once it is no longer used.
+func NewTable[T cache.Value](client Client, prefix string, options ...TableOption) Table[T] {
+	table := Table[T]{
+		diskeyCluster: client.diskeyCluster,
+		prefix:        prefix,
+		options: tableOptions{
+			encoding: tableEncoding(client),
+			upgrades: map[uint32]tableUpgrade{},
+			ttl:      0,
+		},
+	}
+
+	for index := range options {
+		options[index](&table.options)
+	}
+
+	if table.options.nearCacheEnabled {
+		nearCache, err := cluster.NewNearCache(client.diskeyCluster, prefix, table.options.nearCacheOptions...)
+		if err != nil {
+			// Reads still work, they just always go to the key owner.
+			log.Warn().Err(err).Str("prefix", prefix).Msg("failed to create near cache")
+		}
+		table.nearCache = nearCache
+	}
+
+	return table
+}
+
+// tableEncoding is the encoding of the client with type fingerprints.
+func tableEncoding(client Client) cache.Encoding {
+	encoding := client.diskeyCluster.Encoding()
+	encoding.Fingerprint = true
+	return encoding
+}
+
+func (self Table[T]) Prefix() string {
+	return self.prefix
+}
+
+// Key returns the full cluster key for a key in the table.
+func (self Table[T]) Key(key string) string {
+	return self.prefix + key
+}
+
+// Get gets the key, reading through the near cache when it is enabled.
+func (self Table[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	var value T
+
+	getBytes := self.diskeyCluster.GetBytes
+	if self.nearCache != nil {
+		getBytes = self.nearCache.GetBytes
+	}
+
+	valueBytes, exists, err := getBytes(ctx, self.Key(key))
+	if err != nil || !exists {
+		return value, false, err
+	}
+
+	return self.decode(ctx, self.Key(key), valueBytes)
+}
+
+// decode decodes a value stored under the cluster key, upgrading values written at an older version.
+func (self Table[T]) decode(ctx context.Context, key string, valueBytes []byte) (T, bool, error) {
+	var value T
+
+	err := cache.UnmarshalValueFor(self.options.encoding, key, valueBytes, &value)
+	if !errors.Is(err, cache.ErrVersionMismatch) {
+		return value, err == nil, err
+	}
+
+	version, err := cache.ValueVersion(valueBytes)
+	if err != nil {
+		return value, false, err
+	}
+
+	upgrade, exists := self.options.upgrades[version]
+	if !exists {
+		// Misses make callers load and write the value again at the current version.
+		log.Ctx(ctx).Debug().Str("prefix", self.prefix).Uint32("version", version).Msg("skipped value of another schema version")
+		return value, false, nil
+	}
+
+	upgraded, err := upgrade.decode(self.options.encoding, key, valueBytes)
+	if err != nil {
+		return value, false, err
+	}
+
+	for next := version + 1; next < self.options.encoding.Version; next++ {
+		upgrade, exists := self.options.upgrades[next]
+		if !exists {
+			log.Ctx(ctx).Debug().Str("prefix", self.prefix).Uint32("version", version).Uint32("missing", next).Msg("skipped value of another schema version")
+			return value, false, nil
+		}
+
+		if upgraded, err = upgrade.upgrade(upgraded); err != nil {
+			return value, false, err
+		}
+	}
+
+	// AddUpgrade only accepts upgrades to the version of the table that return T.
+	return upgraded.(T), true, nil
+}
+
+// AddUpgrade upgrades values of the table written at fromVersion to the shape they have at fromVersion+1. Old and New
+// mirror those shapes, under any type name, and New must be T when fromVersion+1 is the version of the table.
+//
+// Upgrades are chained: a value written at version 1 of a table at version 3 goes through the upgrades from 1 and
+// from 2. Values for which an upgrade of the chain is missing are misses. Upgraded values are not written back, so
+// keep the upgrades until values of the old versions have expired or been rewritten. Add upgrades right after
+// creating the table, before it is used.
+func AddUpgrade[Old cache.Value, New cache.Value, T cache.Value](table Table[T], fromVersion uint32, upgrade func(old Old) (New, error)) error {
+	version := table.options.encoding.Version
+	if fromVersion >= version {
+		return fmt.Errorf("upgrade from version %d: the table is at version %d", fromVersion, version)
+	}
+
+	oldType := reflect.TypeFor[Old]()
+	newType := reflect.TypeFor[New]()
+	if fromVersion+1 == version && newType != reflect.TypeFor[T]() {
+		return fmt.Errorf("upgrade from version %d returns %s instead of the table type %s", fromVersion, newType, reflect.TypeFor[T]())
+	}
+	if previous, exists := table.options.upgrades[fromVersion-1]; exists && fromVersion > 0 && previous.newType != oldType {
+		return fmt.Errorf("upgrade from version %d takes %s but the upgrade before returns %s", fromVersion, oldType, previous.newType)
+	}
+	if next, exists := table.options.upgrades[fromVersion+1]; exists && next.oldType != newType {
+		return fmt.Errorf("upgrade from version %d returns %s but the upgrade after takes %s", fromVersion, newType, next.oldType)
+	}
+
+	table.options.upgrades[fromVersion] = tableUpgrade{
+		oldType: oldType,
+		newType: newType,
+		decode: func(encoding cache.Encoding, key string, valueBytes []byte) (any, error) {
+			var old Old
+			encoding.Version = fromVersion
+			if err := cache.UnmarshalVersionWith(encoding, key, valueBytes, &old); err != nil {
+				return nil, err
+			}
+			return upgrade(old)
+		},
+		upgrade: func(old any) (any, error) {
+			return upgrade(old.(Old))
+		},
+	}
+
+	return nil
+}
+
+// Set sets the key using the table ttl.
+func (self Table[T]) Set(ctx context.Context, key string, value T) error {
+	return self.SetWithTTL(ctx, key, value, self.options.ttl)
+}
+
+func (self Table[T]) SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) error {
+	valueBytes, err := cache.MarshalValueFor(self.options.encoding, self.Key(key), value)
+	if err != nil {
+		return err
+	}
+
+	err = self.diskeyCluster.SetBytes(ctx, self.Key(key), valueBytes, ttl)
+	self.invalidate(key)
+
+	return err
+}
+
+func (self Table[T]) Delete(ctx context.Context, key string) error {
+	err := cluster.Delete(ctx, self.diskeyCluster, self.Key(key))
+	self.invalidate(key)
+
+	return err
+}
+
+// invalidate drops the key from the near cache right away, so that this process reads its own writes without
+// waiting on the event from the key owner.
+func (self Table[T]) invalidate(key string) {
+	if self.nearCache != nil {
+		self.nearCache.Invalidate(self.Key(key))
+	}
+}
+
+// Close releases the near cache of the table, if it has one. The table keeps working afterwards, but reads always go to
+// the key owner.
+func (self Table[T]) Close() {
+	if self.nearCache != nil {
+		self.nearCache.Close()
+	}
+}
+
+// NearCacheStats returns the hit and miss counts of the near cache. The stats are zero when it is not enabled.
+func (self Table[T]) NearCacheStats() cluster.NearCacheStats {
+	if self.nearCache == nil {
+		return cluster.NearCacheStats{}
+	}
+	return self.nearCache.Stats()
+}
+
+// MGet gets many keys from the table with one round trip per node that owns any of the keys.
+// Results are in the same order as the keys. MGet always reads from the key owners, bypassing the near cache.
+func (self Table[T]) MGet(ctx context.Context, keys ...string) []cluster.GetResult[T] {
+	tableKeys := make([]string, len(keys))
+	for index := range keys {
+		tableKeys[index] = self.Key(keys[index])
+	}
+
+	byteResults := self.diskeyCluster.MGetBytes(ctx, tableKeys...)
+
+	results := make([]cluster.GetResult[T], len(keys))
+	for index := range results {
+		if byteResults[index].Err != nil || !byteResults[index].Exists {
+			results[index].Err = byteResults[index].Err
+			continue
+		}
+		results[index].Value, results[index].Exists, results[index].Err = self.decode(ctx, tableKeys[index], byteResults[index].Value)
+	}
+
+	return results
+}
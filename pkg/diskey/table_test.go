@@ -0,0 +1,157 @@
+package diskey_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/cache"
+	"diskey/pkg/discovery"
+	"diskey/pkg/diskey"
+)
+
+type User struct {
+	Name string
+	Age  int
+}
+
+// userV0 is the shape User had before Name replaced FullName.
+type userV0 struct {
+	FullName string
+}
+
+// userV1 is the shape User had before Age was added.
+type userV1 struct {
+	Name string
+}
+
+type Account struct {
+	Name    string
+	Balance int
+}
+
+func Test_Table(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := diskey.NewClient(ctx, diskey.Config{
+		Host:               "localhost",
+		ServerToServerPort: "9200",
+		MemberListPort:     "9700",
+	}, discovery.NewLocalhost([]string{"9700"}))
+
+	users := diskey.NewTable[User](client, "user:", diskey.TableOptionTTL(time.Minute))
+	accounts := diskey.NewTable[Account](client, "user:")
+
+	expectedUser := User{
+		Name: "gopher",
+		Age:  14,
+	}
+
+	assert.NoError(t, users.Set(ctx, "1", expectedUser))
+
+	user, exists, err := users.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, expectedUser, user)
+
+	// The prefix is part of the key.
+	_, exists = diskey.Get[User](diskey.WithContext(ctx, client), "1")
+	assert.False(t, exists)
+
+	// Both tables share a prefix, but the value was not written as an Account.
+	_, exists, err = accounts.Get(ctx, "1")
+	assert.ErrorIs(t, err, cache.ErrTypeMismatch)
+	assert.False(t, exists)
+
+	results := users.MGet(ctx, "1", "2")
+	assert.NoError(t, results[0].Err)
+	assert.True(t, results[0].Exists)
+	assert.Equal(t, expectedUser, results[0].Value)
+	assert.NoError(t, results[1].Err)
+	assert.False(t, results[1].Exists)
+
+	// Values written with another codec are decoded with the codec recorded in the value.
+	jsonUsers := diskey.NewTable[User](client, "user:", diskey.TableOptionCodec(cache.JSON))
+	assert.NoError(t, jsonUsers.Set(ctx, "2", expectedUser))
+	user, exists, err = users.Get(ctx, "2")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, expectedUser, user)
+	assert.NoError(t, jsonUsers.Delete(ctx, "2"))
+
+	// Encrypted values are stored as ciphertext and only readable with the key.
+	keyring := cache.NewKeyring()
+	assert.NoError(t, keyring.Add(1, []byte("0123456789abcdef")))
+	secretUsers := diskey.NewTable[User](client, "secret:", diskey.TableOptionEncryption(keyring))
+	assert.NoError(t, secretUsers.Set(ctx, "1", expectedUser))
+	user, exists, err = secretUsers.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, expectedUser, user)
+
+	valueBytes, exists, err := client.Cluster().GetBytes(ctx, secretUsers.Key("1"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.NotContains(t, string(valueBytes), expectedUser.Name)
+
+	_, _, err = diskey.NewTable[User](client, "secret:").Get(ctx, "1")
+	assert.ErrorIs(t, err, cache.ErrUnknownKey)
+	assert.NoError(t, secretUsers.Delete(ctx, "1"))
+
+	// Values written at an older schema version are upgraded, or skipped when there is no upgrade.
+	oldUsers := diskey.NewTable[userV0](client, "versioned:")
+	assert.NoError(t, oldUsers.Set(ctx, "1", userV0{FullName: "gopher"}))
+
+	_, exists, err = diskey.NewTable[User](client, "versioned:", diskey.TableOptionVersion(1)).Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	versionedUsers := diskey.NewTable[User](client, "versioned:", diskey.TableOptionVersion(1))
+	assert.NoError(t, diskey.AddUpgrade(versionedUsers, 0, func(old userV0) (User, error) {
+		return User{Name: old.FullName, Age: 0}, nil
+	}))
+	user, exists, err = versionedUsers.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, User{Name: "gopher", Age: 0}, user)
+
+	// Upgrades are chained across versions.
+	chainedUsers := diskey.NewTable[User](client, "versioned:", diskey.TableOptionVersion(2))
+	assert.NoError(t, diskey.AddUpgrade(chainedUsers, 0, func(old userV0) (userV1, error) {
+		return userV1{Name: old.FullName}, nil
+	}))
+	assert.NoError(t, diskey.AddUpgrade(chainedUsers, 1, func(old userV1) (User, error) {
+		return User{Name: old.Name, Age: 1}, nil
+	}))
+	user, exists, err = chainedUsers.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, User{Name: "gopher", Age: 1}, user)
+
+	// Upgrades that do not fit the chain or the table are rejected.
+	assert.Error(t, diskey.AddUpgrade(chainedUsers, 1, func(old userV0) (User, error) {
+		return User{Name: old.FullName, Age: 0}, nil
+	}))
+	assert.Error(t, diskey.AddUpgrade(diskey.NewTable[User](client, "versioned:", diskey.TableOptionVersion(1)), 0, func(old userV0) (userV1, error) {
+		return userV1{Name: old.FullName}, nil
+	}))
+	assert.Error(t, diskey.AddUpgrade(chainedUsers, 2, func(old User) (User, error) {
+		return old, nil
+	}))
+
+	// Older builds skip values written at a newer version.
+	assert.NoError(t, versionedUsers.Set(ctx, "1", expectedUser))
+	_, exists, err = oldUsers.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.NoError(t, versionedUsers.Delete(ctx, "1"))
+
+	assert.NoError(t, users.Delete(ctx, "1"))
+
+	_, exists, err = users.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,87 @@
+package lock
+
+import (
+	"diskey/pkg/cluster"
+)
+
+type AcquireError uint
+
+const (
+	AcquireErrorBlankName = AcquireError(iota + 1)
+	AcquireErrorHeld
+	AcquireErrorOwnerUnavailable
+)
+
+func (self AcquireError) String() string {
+	switch self {
+	case AcquireErrorBlankName:
+		return "BlankName"
+	case AcquireErrorHeld:
+		return "Held"
+	case AcquireErrorOwnerUnavailable:
+		return "OwnerUnavailable"
+	default:
+		return "AcquireError"
+	}
+}
+
+func toAcquireError(cause cluster.LockError) AcquireError {
+	switch cause {
+	case cluster.LockErrorBlankName:
+		return AcquireErrorBlankName
+	case cluster.LockErrorHeld:
+		return AcquireErrorHeld
+	default:
+		return AcquireErrorOwnerUnavailable
+	}
+}
+
+type RefreshError uint
+
+const (
+	RefreshErrorLost = RefreshError(iota + 1)
+	RefreshErrorOwnerUnavailable
+)
+
+func (self RefreshError) String() string {
+	switch self {
+	case RefreshErrorLost:
+		return "Lost"
+	case RefreshErrorOwnerUnavailable:
+		return "OwnerUnavailable"
+	default:
+		return "RefreshError"
+	}
+}
+
+func toRefreshError(cause cluster.LockError) RefreshError {
+	if cause == cluster.LockErrorLost {
+		return RefreshErrorLost
+	}
+	return RefreshErrorOwnerUnavailable
+}
+
+type ReleaseError uint
+
+const (
+	ReleaseErrorLost = ReleaseError(iota + 1)
+	ReleaseErrorOwnerUnavailable
+)
+
+func (self ReleaseError) String() string {
+	switch self {
+	case ReleaseErrorLost:
+		return "Lost"
+	case ReleaseErrorOwnerUnavailable:
+		return "OwnerUnavailable"
+	default:
+		return "ReleaseError"
+	}
+}
+
+func toReleaseError(cause cluster.LockError) ReleaseError {
+	if cause == cluster.LockErrorLost {
+		return ReleaseErrorLost
+	}
+	return ReleaseErrorOwnerUnavailable
+}
@@ -0,0 +1,101 @@
+// Package lock provides distributed locks on top of a diskey cluster.
+//
+// Each lock lives on the node that owns the lock name's hash slot. The owner expires the lease on its own if the
+// holder stops refreshing it, so a crashed holder can never keep a lock forever. Every successful acquire hands
+// out a fencing token that is larger than any token issued before it. Pass the token along with any write the lock
+// protects so that the protected resource can reject writes from a holder whose lease has already expired.
+package lock
+
+import (
+	"context"
+	"time"
+
+	"diskey/pkg/cluster"
+	"diskey/pkg/diskey"
+	"diskey/pkg/errors"
+)
+
+const acquireRetryInterval = 25 * time.Millisecond
+
+// Lock is a handle to a held lock.
+type Lock struct {
+	cluster  *cluster.Cluster
+	name     string
+	holder   string
+	token    uint64
+	leaseTTL time.Duration
+}
+
+// Acquire blocks until the named lock is acquired or the Context is done.
+// The lease expires after leaseTTL unless it is refreshed.
+//
+// If the node that owns the lock fails, the node that takes it over knows the last fencing token but not the lease, so
+// it may grant the lock to someone else while this holder still believes it holds it. Only a resource that checks the
+// fencing token is safe from that.
+func Acquire(ctx context.Context, name string, leaseTTL time.Duration) (*Lock, errors.Error[AcquireError]) {
+	ticker := time.NewTicker(acquireRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		held, err := TryAcquire(ctx, name, leaseTTL)
+		if err.IsOk() {
+			return held, err
+		}
+		if err.Cause() == AcquireErrorBlankName {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-ticker.C:
+			continue
+		}
+	}
+}
+
+// TryAcquire makes a single attempt at acquiring the named lock.
+func TryAcquire(ctx context.Context, name string, leaseTTL time.Duration) (*Lock, errors.Error[AcquireError]) {
+	diskeyCluster := diskey.FromContext(ctx).Cluster()
+	holder := cluster.Uuid()
+
+	token, err := cluster.AcquireLock(ctx, diskeyCluster, name, holder, leaseTTL)
+	if err.IsErr() {
+		return nil, errors.FromError(toAcquireError(err.Cause()), err)
+	}
+
+	return &Lock{
+		cluster:  diskeyCluster,
+		name:     name,
+		holder:   holder,
+		token:    token,
+		leaseTTL: leaseTTL,
+	}, errors.Ok[AcquireError]()
+}
+
+func (self *Lock) Name() string {
+	return self.name
+}
+
+// Token is the fencing token for this acquisition of the lock.
+func (self *Lock) Token() uint64 {
+	return self.token
+}
+
+// Refresh extends the lease by the lease TTL the lock was acquired with.
+// Fails with RefreshErrorLost once the lease has expired and the lock must be acquired again.
+func (self *Lock) Refresh(ctx context.Context) errors.Error[RefreshError] {
+	if err := cluster.RefreshLock(ctx, self.cluster, self.name, self.holder, self.token, self.leaseTTL); err.IsErr() {
+		return errors.FromError(toRefreshError(err.Cause()), err)
+	}
+	return errors.Ok[RefreshError]()
+}
+
+// Release gives up the lock so that others may acquire it.
+// Fails with ReleaseErrorLost if the lease had already expired.
+func (self *Lock) Release(ctx context.Context) errors.Error[ReleaseError] {
+	if err := cluster.ReleaseLock(ctx, self.cluster, self.name, self.holder, self.token); err.IsErr() {
+		return errors.FromError(toReleaseError(err.Cause()), err)
+	}
+	return errors.Ok[ReleaseError]()
+}
@@ -0,0 +1,118 @@
+package lock_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"diskey/pkg/discovery"
+	"diskey/pkg/diskey"
+	"diskey/pkg/errors/errorstest"
+	"diskey/pkg/lock"
+)
+
+func newClient(ctx context.Context, serverPort string, memberListPort string, memberListPorts []string) context.Context {
+	client := diskey.NewClient(ctx, diskey.Config{
+		Host:               "localhost",
+		ServerToServerPort: serverPort,
+		MemberListPort:     memberListPort,
+	}, discovery.NewLocalhost(memberListPorts))
+	return diskey.WithContext(ctx, client)
+}
+
+func waitForClients(t *testing.T, numClients int, ctxs ...context.Context) {
+	t.Helper()
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for index := range ctxs {
+		require.NoError(t, diskey.FromContext(ctxs[index]).Cluster().WaitReady(waitCtx, numClients+1))
+	}
+}
+
+func Test_Acquire_Release(t *testing.T) {
+	t.Parallel()
+
+	ctx := newClient(context.Background(), "9000", "9500", []string{"9500"})
+
+	held, err := lock.Acquire(ctx, "resource", time.Minute)
+	errorstest.NoError(t, err)
+
+	_, tryErr := lock.TryAcquire(ctx, "resource", time.Minute)
+	errorstest.ErrorIs(t, tryErr, lock.AcquireErrorHeld)
+
+	errorstest.NoError(t, held.Refresh(ctx))
+	errorstest.NoError(t, held.Release(ctx))
+	errorstest.ErrorIs(t, held.Release(ctx), lock.ReleaseErrorLost)
+
+	next, err := lock.TryAcquire(ctx, "resource", time.Minute)
+	errorstest.NoError(t, err)
+	assert.Greater(t, next.Token(), held.Token())
+
+	_, err = lock.TryAcquire(ctx, "", time.Minute)
+	errorstest.ErrorIs(t, err, lock.AcquireErrorBlankName)
+}
+
+func Test_Acquire_lease_expires_while_held(t *testing.T) {
+	t.Parallel()
+
+	ctx := newClient(context.Background(), "9001", "9501", []string{"9501"})
+
+	held, err := lock.Acquire(ctx, "resource", 100*time.Millisecond)
+	errorstest.NoError(t, err)
+
+	// The holder stalls past its lease, so the owner expires it and hands the lock to the next caller.
+	acquireCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	next, err := lock.Acquire(acquireCtx, "resource", time.Minute)
+	errorstest.NoError(t, err)
+	assert.Greater(t, next.Token(), held.Token())
+
+	errorstest.ErrorIs(t, held.Refresh(ctx), lock.RefreshErrorLost)
+	errorstest.ErrorIs(t, held.Release(ctx), lock.ReleaseErrorLost)
+
+	errorstest.NoError(t, next.Refresh(ctx))
+	errorstest.NoError(t, next.Release(ctx))
+}
+
+func Test_Acquire_owner_node_failure(t *testing.T) {
+	t.Parallel()
+
+	memberListPorts := []string{"9502", "9503"}
+	ctx1 := newClient(context.Background(), "9002", "9502", memberListPorts)
+	ctx2 := newClient(context.Background(), "9003", "9503", memberListPorts)
+	waitForClients(t, 1, ctx1, ctx2)
+
+	cluster1 := diskey.FromContext(ctx1).Cluster()
+	cluster2 := diskey.FromContext(ctx2).Cluster()
+
+	// Find a lock that lives on the second node so that it can be taken down underneath the holder.
+	var name string
+	for index := 0; ; index++ {
+		name = "resource" + strconv.Itoa(index)
+		if cluster1.OwnerAddress(name).String() == cluster2.Address().String() {
+			break
+		}
+	}
+
+	held, err := lock.Acquire(ctx1, name, time.Minute)
+	errorstest.NoError(t, err)
+
+	require.NoError(t, cluster2.Close())
+	waitForClients(t, 0, ctx1)
+	assert.Equal(t, cluster1.Address().String(), cluster1.OwnerAddress(name).String())
+
+	// The new owner has no record of the lease, so the lock is free. The fencing token still moves forward which
+	// lets the protected resource reject the stale holder.
+	next, err := lock.TryAcquire(ctx1, name, time.Minute)
+	errorstest.NoError(t, err)
+	assert.Greater(t, next.Token(), held.Token())
+
+	errorstest.ErrorIs(t, held.Refresh(ctx1), lock.RefreshErrorLost)
+	errorstest.NoError(t, next.Release(ctx1))
+}
@@ -0,0 +1,399 @@
+// Package metrics is a small metrics registry that is exposed in the Prometheus text format.
+//
+// See: https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are histogram buckets, in seconds, suited to latencies from a tenth of a millisecond up to a
+// few seconds.
+var DefaultLatencyBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type metricType string
+
+const (
+	typeCounter   = metricType("counter")
+	typeGauge     = metricType("gauge")
+	typeHistogram = metricType("histogram")
+)
+
+// labelSeparator joins label values into a series key. It cannot appear in valid UTF-8 text.
+const labelSeparator = "\xff"
+
+type metric interface {
+	writeText(writer *bufio.Writer)
+}
+
+type Registry struct {
+	metrics []metric
+	names   map[string]struct{}
+	mutex   sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: []metric{},
+		names:   map[string]struct{}{},
+		mutex:   sync.Mutex{},
+	}
+}
+
+func (self *Registry) register(name string, newMetric metric) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if _, exists := self.names[name]; exists {
+		panic("metric registered twice: " + name)
+	}
+
+	self.names[name] = struct{}{}
+	self.metrics = append(self.metrics, newMetric)
+}
+
+// WriteText writes every metric in the Prometheus text format, in the order they were registered.
+func (self *Registry) WriteText(writer io.Writer) error {
+	self.mutex.Lock()
+	metrics := slices.Clone(self.metrics)
+	self.mutex.Unlock()
+
+	bufferedWriter := bufio.NewWriter(writer)
+	for index := range metrics {
+		metrics[index].writeText(bufferedWriter)
+	}
+
+	return bufferedWriter.Flush()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (self *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = self.WriteText(writer)
+	})
+}
+
+type description struct {
+	name       string
+	help       string
+	metricType metricType
+	labelNames []string
+}
+
+func (self description) writeHeader(writer *bufio.Writer) {
+	fmt.Fprintf(writer, "# HELP %s %s\n", self.name, escapeHelp(self.help))
+	fmt.Fprintf(writer, "# TYPE %s %s\n", self.name, self.metricType)
+}
+
+// labels formats the label pairs, including any extra pair such as a histogram bucket.
+func (self description) labels(labelValues []string, extraName string, extraValue string) string {
+	if len(self.labelNames) == 0 && extraName == "" {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for index := range self.labelNames {
+		if index > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(self.labelNames[index])
+		builder.WriteString(`="`)
+		builder.WriteString(escapeLabelValue(labelValues[index]))
+		builder.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(self.labelNames) > 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(extraName)
+		builder.WriteString(`="`)
+		builder.WriteString(extraValue)
+		builder.WriteByte('"')
+	}
+	builder.WriteByte('}')
+
+	return builder.String()
+}
+
+func (self description) seriesKey(labelValues []string) string {
+	if len(labelValues) != len(self.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", self.name, len(self.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+func splitSeriesKey(key string, labelCount int) []string {
+	if labelCount == 0 {
+		return nil
+	}
+	return strings.Split(key, labelSeparator)
+}
+
+// sortedKeys returns the series keys in a stable order so that the output does not shuffle between scrapes.
+func sortedKeys[V any](series map[string]V) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// values is a set of float series keyed by their label values. Counters and gauges are both stored this way.
+type values struct {
+	series map[string]float64
+	description
+	mutex sync.Mutex
+}
+
+func (self *values) add(delta float64, labelValues []string) {
+	key := self.seriesKey(labelValues)
+
+	self.mutex.Lock()
+	self.series[key] += delta
+	self.mutex.Unlock()
+}
+
+func (self *values) set(value float64, labelValues []string) {
+	key := self.seriesKey(labelValues)
+
+	self.mutex.Lock()
+	self.series[key] = value
+	self.mutex.Unlock()
+}
+
+func (self *values) writeText(writer *bufio.Writer) {
+	self.writeHeader(writer)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, key := range sortedKeys(self.series) {
+		labels := self.labels(splitSeriesKey(key, len(self.labelNames)), "", "")
+		fmt.Fprintf(writer, "%s%s %s\n", self.name, labels, formatFloat(self.series[key]))
+	}
+}
+
+// Counter is a value that only goes up, such as the number of requests served.
+type Counter struct {
+	values *values
+}
+
+// NewCounter registers a counter. Counter names conventionally end in "_total".
+func (self *Registry) NewCounter(name string, help string, labelNames ...string) Counter {
+	counter := Counter{
+		values: &values{
+			series: map[string]float64{},
+			description: description{
+				name:       name,
+				help:       help,
+				metricType: typeCounter,
+				labelNames: labelNames,
+			},
+		},
+	}
+	self.register(name, counter.values)
+	return counter
+}
+
+// Inc adds one to the series with the label values, given in the order of the label names.
+func (self Counter) Inc(labelValues ...string) {
+	self.values.add(1, labelValues)
+}
+
+// Add adds the delta, which must not be negative, to the series with the label values.
+func (self Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease: " + self.values.name)
+	}
+	self.values.add(delta, labelValues)
+}
+
+// Gauge is a value that goes up and down, such as the number of open connections.
+type Gauge struct {
+	values *values
+}
+
+func (self *Registry) NewGauge(name string, help string, labelNames ...string) Gauge {
+	gauge := Gauge{
+		values: &values{
+			series: map[string]float64{},
+			description: description{
+				name:       name,
+				help:       help,
+				metricType: typeGauge,
+				labelNames: labelNames,
+			},
+		},
+	}
+	self.register(name, gauge.values)
+	return gauge
+}
+
+func (self Gauge) Set(value float64, labelValues ...string) {
+	self.values.set(value, labelValues)
+}
+
+func (self Gauge) Add(delta float64, labelValues ...string) {
+	self.values.add(delta, labelValues)
+}
+
+// valueFunc is a metric without labels whose value is read when the metrics are written.
+type valueFunc struct {
+	value func() float64
+	description
+}
+
+func (self *valueFunc) writeText(writer *bufio.Writer) {
+	self.writeHeader(writer)
+	fmt.Fprintf(writer, "%s %s\n", self.name, formatFloat(self.value()))
+}
+
+// NewCounterFunc registers a counter whose value is read from the function on every scrape. Use it to expose counts
+// kept elsewhere.
+func (self *Registry) NewCounterFunc(name string, help string, value func() float64) {
+	self.register(name, &valueFunc{
+		value: value,
+		description: description{
+			name:       name,
+			help:       help,
+			metricType: typeCounter,
+		},
+	})
+}
+
+// NewGaugeFunc registers a gauge whose value is read from the function on every scrape.
+func (self *Registry) NewGaugeFunc(name string, help string, value func() float64) {
+	self.register(name, &valueFunc{
+		value: value,
+		description: description{
+			name:       name,
+			help:       help,
+			metricType: typeGauge,
+		},
+	})
+}
+
+type histogramSeries struct {
+	// bucketCounts holds the count of observations in each bucket, not cumulatively.
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+// Histogram counts observations, such as request latencies, into buckets.
+type Histogram struct {
+	histogram *histogram
+}
+
+type histogram struct {
+	series  map[string]*histogramSeries
+	buckets []float64
+	description
+	mutex sync.Mutex
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets, in increasing order. A bucket for
+// everything above the last bound is added automatically.
+func (self *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) Histogram {
+	if !slices.IsSorted(buckets) {
+		panic("histogram buckets must be sorted: " + name)
+	}
+
+	newHistogram := Histogram{
+		histogram: &histogram{
+			series:  map[string]*histogramSeries{},
+			buckets: buckets,
+			description: description{
+				name:       name,
+				help:       help,
+				metricType: typeHistogram,
+				labelNames: labelNames,
+			},
+		},
+	}
+	self.register(name, newHistogram.histogram)
+	return newHistogram
+}
+
+func (self Histogram) Observe(value float64, labelValues ...string) {
+	key := self.histogram.seriesKey(labelValues)
+	bucket, _ := slices.BinarySearch(self.histogram.buckets, value)
+
+	self.histogram.mutex.Lock()
+	defer self.histogram.mutex.Unlock()
+
+	series, exists := self.histogram.series[key]
+	if !exists {
+		series = &histogramSeries{
+			bucketCounts: make([]uint64, len(self.histogram.buckets)+1),
+		}
+		self.histogram.series[key] = series
+	}
+
+	series.bucketCounts[bucket]++
+	series.sum += value
+	series.count++
+}
+
+func (self *histogram) writeText(writer *bufio.Writer) {
+	self.writeHeader(writer)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, key := range sortedKeys(self.series) {
+		labelValues := splitSeriesKey(key, len(self.labelNames))
+		series := self.series[key]
+
+		cumulative := uint64(0)
+		for index := range series.bucketCounts {
+			cumulative += series.bucketCounts[index]
+
+			upperBound := math.Inf(1)
+			if index < len(self.buckets) {
+				upperBound = self.buckets[index]
+			}
+
+			fmt.Fprintf(writer, "%s_bucket%s %d\n", self.name, self.labels(labelValues, "le", formatFloat(upperBound)), cumulative)
+		}
+
+		labels := self.labels(labelValues, "", "")
+		fmt.Fprintf(writer, "%s_sum%s %s\n", self.name, labels, formatFloat(series.sum))
+		fmt.Fprintf(writer, "%s_count%s %d\n", self.name, labels, series.count)
+	}
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
@@ -0,0 +1,69 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/metrics"
+)
+
+func Test_Registry_WriteText(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+
+	requests := registry.NewCounter("requests_total", "Requests served.", "peer", "method")
+	connections := registry.NewGauge("connections", "Open connections.")
+	registry.NewGaugeFunc("entries", "Entries in the cache.", func() float64 { return 42 })
+	latency := registry.NewHistogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "method")
+
+	requests.Inc("b:1", "Get")
+	requests.Add(2, "a:1", `Say "hi"`)
+	connections.Set(3)
+	connections.Add(-1)
+	latency.Observe(0.1, "Get")
+	latency.Observe(0.5, "Get")
+	latency.Observe(3, "Get")
+
+	expected := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{peer="a:1",method="Say \"hi\""} 2
+requests_total{peer="b:1",method="Get"} 1
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 2
+# HELP entries Entries in the cache.
+# TYPE entries gauge
+entries 42
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="Get",le="0.1"} 1
+latency_seconds_bucket{method="Get",le="1"} 2
+latency_seconds_bucket{method="Get",le="+Inf"} 3
+latency_seconds_sum{method="Get"} 3.6
+latency_seconds_count{method="Get"} 3
+`
+
+	var builder strings.Builder
+	assert.NoError(t, registry.WriteText(&builder))
+	assert.Equal(t, expected, builder.String())
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, expected, recorder.Body.String())
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "text/plain")
+}
+
+func Test_Registry_duplicate_name(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	registry.NewCounter("requests_total", "Requests served.")
+
+	assert.Panics(t, func() {
+		registry.NewGauge("requests_total", "Requests served.")
+	})
+}
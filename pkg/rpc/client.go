@@ -1,15 +1,21 @@
 package rpc
 
 import (
+	"bufio"
 	"context"
+	"fmt"
 	"net"
-	"net/rpc"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/vmihailenco/msgpack/v5"
 
+	"diskey/pkg/bytespool"
 	"diskey/pkg/command"
 	"diskey/pkg/errors"
+	"diskey/pkg/trace"
 )
 
 const (
@@ -18,15 +24,22 @@ const (
 	defaultKeepAlivePeriod = 10 * time.Second
 )
 
+// Observer is called after every request sent by a client with the request name, how long it took and its error.
+type Observer func(name string, duration time.Duration, err error)
+
 type Client struct {
-	tcpConnection  *net.TCPConn
-	rpcClient      *rpc.Client
-	cancel         context.CancelFunc
-	host           string
-	port           string
-	address        string
-	sendTimeout    time.Duration
-	receiveTimeout time.Duration
+	connectionMutex sync.RWMutex
+	observer        Observer
+	tcpConnection   *net.TCPConn
+	connection      *clientConnection
+	grpcConnection  *grpcConnection
+	cancel          context.CancelFunc
+	host            string
+	port            string
+	address         string
+	sendTimeout     time.Duration
+	receiveTimeout  time.Duration
+	transport       Transport
 }
 
 func NewClient(host string, port string) *Client {
@@ -38,6 +51,8 @@ func NewClient(host string, port string) *Client {
 		cancel:         nil,
 		sendTimeout:    defaultSendTimeout,
 		receiveTimeout: defaultReceiveTimeout,
+		observer:       func(string, time.Duration, error) {},
+		transport:      TransportFramed,
 	}
 }
 
@@ -45,12 +60,18 @@ func NewWithConnection(tcpConnection *net.TCPConn) *Client {
 	return &Client{
 		address:        tcpConnection.RemoteAddr().String(),
 		tcpConnection:  tcpConnection,
+		connection:     newClientConnection(tcpConnection),
 		sendTimeout:    defaultSendTimeout,
 		receiveTimeout: defaultReceiveTimeout,
+		observer:       func(string, time.Duration, error) {},
+		transport:      TransportFramed,
 	}
 }
 
 func (self *Client) Disconnect(ctx context.Context) {
+	self.connectionMutex.Lock()
+	defer self.connectionMutex.Unlock()
+
 	if self.cancel != nil {
 		self.cancel()
 		self.cancel = nil
@@ -62,7 +83,15 @@ func (self *Client) Disconnect(ctx context.Context) {
 		}
 		log.Ctx(ctx).Debug().Msg("closed client connection")
 		self.tcpConnection = nil
-		self.rpcClient = nil
+		self.connection = nil
+	}
+
+	if self.grpcConnection != nil {
+		if err := self.grpcConnection.close(); err != nil {
+			log.Ctx(ctx).Err(err).Msg("failed to close grpc connection")
+		}
+		log.Ctx(ctx).Debug().Msg("closed client grpc connection")
+		self.grpcConnection = nil
 	}
 }
 
@@ -78,6 +107,26 @@ func (self *Client) Address() string {
 	return self.address
 }
 
+// Connected reports whether the client holds an open connection.
+func (self *Client) Connected() bool {
+	self.connectionMutex.RLock()
+	defer self.connectionMutex.RUnlock()
+
+	if self.grpcConnection != nil {
+		return self.grpcConnection.connected()
+	}
+	return self.connection != nil && self.connection.failure() == nil
+}
+
+// SetTransport sets the transport of the server the client connects to. Set it before connecting.
+func (self *Client) SetTransport(transport Transport) {
+	self.transport = transport
+}
+
+func (self *Client) Transport() Transport {
+	return self.transport
+}
+
 func (self *Client) SetSendTimeout(sendTimeout time.Duration) {
 	self.sendTimeout = sendTimeout
 }
@@ -86,10 +135,34 @@ func (self *Client) SetReceiveTimeout(receiveTimeout time.Duration) {
 	self.receiveTimeout = receiveTimeout
 }
 
+// SetObserver sets the function called after every request. Set it before the client is shared.
+func (self *Client) SetObserver(observer Observer) {
+	self.observer = observer
+}
+
 func (self *Client) Connect(ctx context.Context) errors.Error[ConnectError] {
+	self.connectionMutex.Lock()
+	defer self.connectionMutex.Unlock()
+
 	ctx, cancel := context.WithCancel(ctx)
 	self.cancel = cancel
 
+	if self.transport == TransportGRPC {
+		grpcConnection, err := newGRPCConnection(ctx, self.address, self.sendTimeout)
+		if err != nil {
+			return errors.NewWithErr(ConnectErrorConnectionFailure, err)
+		}
+		self.grpcConnection = grpcConnection
+		log.Ctx(ctx).Debug().Str("remoteAddress", self.address).Msg("connected over grpc")
+
+		go func(ctx context.Context) {
+			<-ctx.Done()
+			self.Disconnect(ctx)
+		}(ctx)
+
+		return errors.Ok[ConnectError]()
+	}
+
 	tcpAddr, err := net.ResolveTCPAddr("tcp", self.address)
 	if err != nil {
 		return errors.NewWithErr(ConnectErrorInvalidAddress, err)
@@ -117,7 +190,7 @@ func (self *Client) Connect(ctx context.Context) errors.Error[ConnectError] {
 	}
 	log.Ctx(ctx).Debug().Msg("connected")
 
-	self.rpcClient = rpc.NewClientWithCodec(NewClientCodecMsgpack(self.tcpConnection))
+	self.connection = newClientConnection(self.tcpConnection)
 
 	go func(ctx context.Context) {
 		<-ctx.Done()
@@ -127,21 +200,203 @@ func (self *Client) Connect(ctx context.Context) errors.Error[ConnectError] {
 	return errors.Ok[ConnectError]()
 }
 
+// Send sends the request and decodes the response into its reply. Requests on the same client are multiplexed over
+// one connection and do not wait on each other.
 func (self *Client) Send(ctx context.Context, cmd command.Request) error {
-	if self.tcpConnection == nil {
-		log.Ctx(ctx).Error().Msg("not connected")
-		return nil
+	if err := cmd.Validate(); err != nil {
+		return err
 	}
 
-	if self.rpcClient == nil {
-		log.Ctx(ctx).Error().Msg("not connected")
-		return nil
+	self.connectionMutex.RLock()
+	connection := self.connection
+	grpcConnection := self.grpcConnection
+	self.connectionMutex.RUnlock()
+
+	if connection == nil && grpcConnection == nil {
+		return ErrNotConnected
 	}
 
-	if err := self.tcpConnection.SetDeadline(time.Now().Add(self.receiveTimeout)); err != nil {
-		log.Ctx(ctx).Err(err).Msg("failed to set deadline")
-		return nil
+	if !cmd.Trace.IsValid() {
+		cmd.Trace = trace.SpanContextFromContext(ctx)
 	}
 
-	return command.Send(ctx, self.rpcClient, cmd)
+	start := time.Now()
+	var err error
+	if grpcConnection != nil {
+		err = grpcConnection.send(ctx, cmd, self.receiveTimeout)
+	} else {
+		err = connection.send(ctx, cmd, self.sendTimeout, self.receiveTimeout)
+	}
+	self.observer(cmd.Opcode.String(), time.Since(start), err)
+
+	return err
+}
+
+// ErrNotConnected is returned by Send when the client is not connected.
+var ErrNotConnected = fmt.Errorf("not connected")
+
+// ServerError is an error returned by the handler of a request.
+type ServerError string
+
+func (self ServerError) Error() string {
+	return string(self)
+}
+
+// call is a request waiting for its response.
+type call struct {
+	reply any
+	done  chan error
+}
+
+// clientConnection multiplexes requests over a connection. Each request gets an id that its response repeats, so
+// responses are matched to requests in whatever order they arrive.
+type clientConnection struct {
+	tcpConnection *net.TCPConn
+	pending       map[uint64]*call
+	err           error
+	nextID        atomic.Uint64
+	writeMutex    sync.Mutex
+	pendingMutex  sync.Mutex
+}
+
+func newClientConnection(tcpConnection *net.TCPConn) *clientConnection {
+	connection := &clientConnection{
+		tcpConnection: tcpConnection,
+		pending:       map[uint64]*call{},
+		err:           nil,
+		nextID:        atomic.Uint64{},
+		writeMutex:    sync.Mutex{},
+		pendingMutex:  sync.Mutex{},
+	}
+
+	go connection.readResponses()
+
+	return connection
+}
+
+// failure returns the error the connection failed with, or nil while it works.
+func (self *clientConnection) failure() error {
+	self.pendingMutex.Lock()
+	defer self.pendingMutex.Unlock()
+
+	return self.err
+}
+
+func (self *clientConnection) send(ctx context.Context, request command.Request, sendTimeout time.Duration, receiveTimeout time.Duration) error {
+	header := frameHeader{
+		trace:  request.Trace,
+		id:     self.nextID.Add(1),
+		opcode: request.Opcode,
+		flags:  0,
+	}
+	if request.Trace.IsValid() {
+		header.flags |= frameFlagTrace
+	}
+
+	waiting := &call{
+		reply: request.Reply,
+		done:  make(chan error, 1),
+	}
+
+	self.pendingMutex.Lock()
+	if self.err != nil {
+		self.pendingMutex.Unlock()
+		return self.err
+	}
+	self.pending[header.id] = waiting
+	self.pendingMutex.Unlock()
+
+	frame, err := appendFrame(bytespool.Get(), header, request.Args)
+	if err == nil {
+		err = self.write(frame, sendTimeout)
+	}
+	bytespool.Put(frame)
+	if err != nil {
+		self.forget(header.id)
+		return err
+	}
+
+	timer := time.NewTimer(receiveTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-waiting.done:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = fmt.Errorf("no response to %s from %s after %s", request.Opcode, self.tcpConnection.RemoteAddr(), receiveTimeout)
+	}
+
+	if !self.forget(header.id) {
+		// The response is being decoded into the reply, so wait for it rather than return while the reply is written.
+		return <-waiting.done
+	}
+	return err
+}
+
+func (self *clientConnection) write(frame []byte, sendTimeout time.Duration) error {
+	self.writeMutex.Lock()
+	defer self.writeMutex.Unlock()
+
+	if err := self.tcpConnection.SetWriteDeadline(time.Now().Add(sendTimeout)); err != nil {
+		return err
+	}
+	if _, err := self.tcpConnection.Write(frame); err != nil {
+		// A partial write leaves the stream unusable.
+		_ = self.tcpConnection.Close()
+		return err
+	}
+	return nil
+}
+
+// forget stops waiting for the response to the request. It reports false if the response already arrived.
+func (self *clientConnection) forget(id uint64) bool {
+	self.pendingMutex.Lock()
+	defer self.pendingMutex.Unlock()
+
+	_, exists := self.pending[id]
+	delete(self.pending, id)
+	return exists
+}
+
+// readResponses hands each response to the request waiting for it until the connection fails, then fails every
+// request still waiting.
+func (self *clientConnection) readResponses() {
+	reader := bufio.NewReader(self.tcpConnection)
+
+	var err error
+	for {
+		var header frameHeader
+		var body, buffer []byte
+		header, body, buffer, err = readFrame(reader)
+		if err != nil {
+			break
+		}
+
+		self.pendingMutex.Lock()
+		waiting, exists := self.pending[header.id]
+		delete(self.pending, header.id)
+		self.pendingMutex.Unlock()
+
+		if exists {
+			if header.flags&frameFlagError != 0 {
+				waiting.done <- ServerError(body)
+			} else {
+				waiting.done <- msgpack.Unmarshal(body, waiting.reply)
+			}
+		}
+		bytespool.Put(buffer)
+	}
+
+	_ = self.tcpConnection.Close()
+
+	self.pendingMutex.Lock()
+	defer self.pendingMutex.Unlock()
+
+	self.err = fmt.Errorf("connection to %s closed: %w", self.tcpConnection.RemoteAddr(), err)
+	for id, waiting := range self.pending {
+		waiting.done <- self.err
+		delete(self.pending, id)
+	}
 }
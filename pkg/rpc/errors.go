@@ -22,12 +22,15 @@ type HandlersError uint
 
 const (
 	HandlersErrorRpcFailure = HandlersError(iota + 1)
+	HandlersErrorDuplicateOpcode
 )
 
 func (self HandlersError) String() string {
 	switch self {
 	case HandlersErrorRpcFailure:
 		return "RpcFailure"
+	case HandlersErrorDuplicateOpcode:
+		return "DuplicateOpcode"
 	default:
 		return "HandlersError"
 	}
@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"diskey/pkg/bytespool"
+	"diskey/pkg/command"
+	"diskey/pkg/trace"
+)
+
+// Requests and responses are sent as frames:
+//
+//	4 bytes: length of the rest of the frame
+//	1 byte: frame flags
+//	2 bytes: opcode
+//	8 bytes: request id, which the response repeats so responses can arrive in any order
+//	[16 bytes: trace id, 8 bytes: span id, when frameFlagTrace is set]
+//	body: msgpack encoded args or reply, or the error message when frameFlagError is set
+const (
+	frameLengthSize = 4
+	frameHeaderSize = 1 + 2 + 8
+	frameTraceSize  = 16 + 8
+	// maxFrameSize protects against reading garbage lengths from a broken connection.
+	maxFrameSize = 64 << 20
+)
+
+type frameFlag byte
+
+const (
+	frameFlagResponse frameFlag = 1 << iota
+	frameFlagError
+	frameFlagTrace
+)
+
+type frameHeader struct {
+	trace  trace.SpanContext
+	id     uint64
+	opcode command.Opcode
+	flags  frameFlag
+}
+
+// appendWriter lets msgpack encode straight into a pooled buffer.
+type appendWriter struct {
+	buffer []byte
+}
+
+func (self *appendWriter) Write(data []byte) (int, error) {
+	self.buffer = append(self.buffer, data...)
+	return len(data), nil
+}
+
+func (self *appendWriter) WriteByte(data byte) error {
+	self.buffer = append(self.buffer, data)
+	return nil
+}
+
+// appendFrame appends the frame to the buffer. The body is the error message for error frames.
+func appendFrame(buffer []byte, header frameHeader, body any) ([]byte, error) {
+	start := len(buffer)
+
+	buffer = append(buffer, 0, 0, 0, 0)
+	buffer = append(buffer, byte(header.flags))
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(header.opcode))
+	buffer = binary.BigEndian.AppendUint64(buffer, header.id)
+	if header.flags&frameFlagTrace != 0 {
+		buffer = append(buffer, header.trace.TraceID[:]...)
+		buffer = append(buffer, header.trace.SpanID[:]...)
+	}
+
+	if header.flags&frameFlagError != 0 {
+		buffer = append(buffer, body.(string)...)
+	} else {
+		writer := &appendWriter{buffer: buffer}
+		encoder := msgpack.GetEncoder()
+		encoder.Reset(writer)
+		err := encoder.Encode(body)
+		msgpack.PutEncoder(encoder)
+		if err != nil {
+			return writer.buffer, err
+		}
+		buffer = writer.buffer
+	}
+
+	length := len(buffer) - start - frameLengthSize
+	if length > maxFrameSize {
+		return buffer, fmt.Errorf("frame of %d bytes is larger than the %d byte limit", length, maxFrameSize)
+	}
+	binary.BigEndian.PutUint32(buffer[start:], uint32(length))
+
+	return buffer, nil
+}
+
+// readFrame reads the next frame. The body points into the returned buffer, which comes from bytespool and should be
+// put back once the body has been decoded.
+func readFrame(reader *bufio.Reader) (frameHeader, []byte, []byte, error) {
+	var lengthBytes [frameLengthSize]byte
+	if _, err := io.ReadFull(reader, lengthBytes[:]); err != nil {
+		return frameHeader{}, nil, nil, err
+	}
+
+	length := int(binary.BigEndian.Uint32(lengthBytes[:]))
+	if length < frameHeaderSize || length > maxFrameSize {
+		return frameHeader{}, nil, nil, fmt.Errorf("invalid frame length: %d", length)
+	}
+
+	buffer := bytespool.GetWithCapacity(length)[:length]
+	if _, err := io.ReadFull(reader, buffer); err != nil {
+		bytespool.Put(buffer)
+		return frameHeader{}, nil, nil, err
+	}
+
+	header := frameHeader{
+		flags:  frameFlag(buffer[0]),
+		opcode: command.Opcode(binary.BigEndian.Uint16(buffer[1:])),
+		id:     binary.BigEndian.Uint64(buffer[3:]),
+		trace:  trace.SpanContext{},
+	}
+	offset := frameHeaderSize
+	if header.flags&frameFlagTrace != 0 {
+		if length < offset+frameTraceSize {
+			bytespool.Put(buffer)
+			return frameHeader{}, nil, nil, fmt.Errorf("frame trace truncated: %d bytes", length)
+		}
+		copy(header.trace.TraceID[:], buffer[offset:])
+		copy(header.trace.SpanID[:], buffer[offset+len(header.trace.TraceID):])
+		offset += frameTraceSize
+	}
+
+	return header, buffer[offset:], buffer, nil
+}
@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	"diskey/pkg/command"
+)
+
+// grpcService is a gRPC service served next to the node service.
+type grpcService struct {
+	desc *grpc.ServiceDesc
+	impl any
+}
+
+// RegisterService registers a gRPC service to serve next to the node service. It has no effect unless the server uses
+// TransportGRPC. Services must be registered before AcceptConnections.
+func (self Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	self.handlers.mutex.Lock()
+	defer self.handlers.mutex.Unlock()
+
+	self.handlers.services = append(self.handlers.services, grpcService{desc: desc, impl: impl})
+}
+
+// nodeService serves the requests of clients using TransportGRPC with the handlers registered on the server.
+type nodeService struct {
+	command.UnimplementedNodeServer
+	server   Server
+	builtIns builtInHandlers
+}
+
+func (self nodeService) Ping(_ context.Context, _ *command.PingArgs) (*command.PingReply, error) {
+	var reply int
+	if err := self.builtIns.Ping(struct{}{}, &reply); err != nil {
+		return nil, err
+	}
+	return &command.PingReply{Value: int64(reply)}, nil
+}
+
+func (self nodeService) Call(_ context.Context, args *command.CallArgs) (*command.CallReply, error) {
+	opcode := command.Opcode(args.GetOpcode())
+
+	handler, exists := self.server.handlers.get(opcode)
+	if !exists {
+		return nil, status.Errorf(codes.Unimplemented, "no handler for %s", opcode)
+	}
+
+	reply, err := handler.handle(args.GetTrace().SpanContext(), args.GetBody())
+	if err != nil {
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+
+	body, err := msgpack.Marshal(reply)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &command.CallReply{Body: body}, nil
+}
+
+// serveGRPC serves gRPC requests from the listener until the server is shut down or the context is done.
+func (self Server) serveGRPC(ctx context.Context, netListener net.Listener) {
+	grpcServer := grpc.NewServer(
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle:     0,
+			MaxConnectionAge:      0,
+			MaxConnectionAgeGrace: 0,
+			Time:                  self.keepAlive,
+			Timeout:               self.receiveTimeout,
+		}),
+		grpc.MaxConcurrentStreams(uint32(self.maxConcurrentCalls)),
+	)
+	command.RegisterNodeServer(grpcServer, nodeService{
+		UnimplementedNodeServer: command.UnimplementedNodeServer{},
+		server:                  self,
+		builtIns:                newBuiltInHandlers(ctx),
+	})
+
+	self.handlers.mutex.RLock()
+	for _, service := range self.handlers.services {
+		grpcServer.RegisterService(service.desc, service.impl)
+	}
+	self.handlers.mutex.RUnlock()
+
+	self.connections.mutex.Lock()
+	if self.connections.closed {
+		self.connections.mutex.Unlock()
+		return
+	}
+	self.connections.grpcServer = grpcServer
+	self.connections.mutex.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			grpcServer.Stop()
+		case <-self.connections.done:
+		}
+	}()
+
+	log.Ctx(ctx).Debug().Msg("serving grpc")
+	if err := grpcServer.Serve(netListener); err != nil {
+		log.Ctx(ctx).Err(err).Msg("grpc server stopped")
+	}
+}
+
+// grpcConnection sends the requests of a client using TransportGRPC. gRPC multiplexes calls over the connection and
+// reconnects when it breaks.
+type grpcConnection struct {
+	clientConnection *grpc.ClientConn
+	node             command.NodeClient
+}
+
+// newGRPCConnection connects to the address and waits until the connection is ready, so that a server that cannot be
+// reached fails the connect like it does with the framed transport.
+func newGRPCConnection(ctx context.Context, address string, connectTimeout time.Duration) (*grpcConnection, error) {
+	clientConnection, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                defaultKeepAlivePeriod,
+			Timeout:             defaultReceiveTimeout,
+			PermitWithoutStream: false,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	clientConnection.Connect()
+	for state := clientConnection.GetState(); state != connectivity.Ready; state = clientConnection.GetState() {
+		if state == connectivity.TransientFailure || !clientConnection.WaitForStateChange(ctx, state) {
+			_ = clientConnection.Close()
+			return nil, fmt.Errorf("failed to connect to %s: %s", address, state)
+		}
+	}
+
+	return &grpcConnection{
+		clientConnection: clientConnection,
+		node:             command.NewNodeClient(clientConnection),
+	}, nil
+}
+
+func (self *grpcConnection) connected() bool {
+	state := self.clientConnection.GetState()
+	return state != connectivity.TransientFailure && state != connectivity.Shutdown
+}
+
+func (self *grpcConnection) send(ctx context.Context, request command.Request, receiveTimeout time.Duration) error {
+	body, err := msgpack.Marshal(request.Args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, receiveTimeout)
+	defer cancel()
+
+	reply, err := self.node.Call(ctx, &command.CallArgs{
+		Opcode: uint32(request.Opcode),
+		Body:   body,
+		Trace:  command.NewTrace(request.Trace),
+	})
+	if err != nil {
+		if callStatus, ok := status.FromError(err); ok && callStatus.Code() == codes.Unknown {
+			return ServerError(callStatus.Message())
+		}
+		return err
+	}
+
+	return msgpack.Unmarshal(reply.GetBody(), request.Reply)
+}
+
+func (self *grpcConnection) close() error {
+	return self.clientConnection.Close()
+}
+
+// shutdownGRPC stops the gRPC server once the calls in flight have replied, or right away if the context is done
+// first.
+func shutdownGRPC(ctx context.Context, grpcServer *grpc.Server, accepting *sync.WaitGroup) error {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+		<-stopped
+		err = ctx.Err()
+	}
+
+	accepting.Wait()
+	return err
+}
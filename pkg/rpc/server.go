@@ -1,38 +1,70 @@
 package rpc
 
 import (
+	"bufio"
 	"context"
+	"fmt"
 	"net"
-	"net/rpc"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/grpc"
 
+	"diskey/pkg/bytespool"
+	"diskey/pkg/command"
 	"diskey/pkg/errors"
+	"diskey/pkg/trace"
 )
 
+// defaultMaxConcurrentCalls bounds how many requests of one connection run at once.
+const defaultMaxConcurrentCalls = 256
+
 type Server struct {
-	rpcServer      *rpc.Server
-	host           string
-	port           string
-	address        string
-	keepAlive      time.Duration
-	sendTimeout    time.Duration
-	receiveTimeout time.Duration
+	handlers           *handlerTable
+	connections        *connections
+	host               string
+	port               string
+	address            string
+	keepAlive          time.Duration
+	sendTimeout        time.Duration
+	receiveTimeout     time.Duration
+	maxConcurrentCalls int
+	transport          Transport
 }
 
 func NewServer(host string, port string) Server {
 	return Server{
-		rpcServer:      rpc.NewServer(),
-		host:           host,
-		port:           port,
-		address:        host + ":" + port,
-		keepAlive:      defaultKeepAlivePeriod,
-		sendTimeout:    defaultSendTimeout,
-		receiveTimeout: defaultReceiveTimeout,
+		handlers:           newHandlerTable(),
+		connections:        newConnections(),
+		host:               host,
+		port:               port,
+		address:            host + ":" + port,
+		keepAlive:          defaultKeepAlivePeriod,
+		sendTimeout:        defaultSendTimeout,
+		receiveTimeout:     defaultReceiveTimeout,
+		maxConcurrentCalls: defaultMaxConcurrentCalls,
+		transport:          TransportFramed,
 	}
 }
 
+// SetMaxConcurrentCalls bounds how many requests of one connection run at once. Once that many are running, the
+// server stops reading requests from the connection until one of them replies, which holds up the client instead of
+// piling up work. Defaults to 256. Set it before accepting connections.
+func (self *Server) SetMaxConcurrentCalls(maxConcurrentCalls int) {
+	self.maxConcurrentCalls = max(maxConcurrentCalls, 1)
+}
+
+// SetTransport sets the transport clients must use to connect. Set it before accepting connections.
+func (self *Server) SetTransport(transport Transport) {
+	self.transport = transport
+}
+
+func (self Server) Transport() Transport {
+	return self.transport
+}
+
 func (self Server) Address() string {
 	return self.address
 }
@@ -45,21 +77,71 @@ func (self Server) Port() string {
 	return self.port
 }
 
-// RegisterHandler publishes in the server the set of methods of the
-// receiver value that satisfy the following conditions:
-//   - exported method of exported type
-//   - two arguments, both of exported type
-//   - the second argument is a pointer
-//   - one return value, of type error
-//
-// It returns an error if the receiver is not an exported type or has
-// no suitable methods. It also logs the error using package log.
-// The client accesses each method using a string of the form "Type.Method",
-// where Type is the receiver's concrete type.
-func (self Server) RegisterHandler(ctx context.Context, handler any) errors.Error[HandlersError] {
-	if rpcErr := self.rpcServer.Register(handler); rpcErr != nil {
-		log.Ctx(ctx).Err(rpcErr).Type("handlerType", handler).Msg("failed to register rpc extension handler")
-		return errors.NewWithErr(HandlersErrorRpcFailure, rpcErr)
+// Handler runs the requests of one opcode. Create it with NewHandler.
+type Handler struct {
+	// handle decodes the args from the body of a request, runs the request and returns the reply to encode.
+	handle func(spanContext trace.SpanContext, body []byte) (any, error)
+}
+
+// NewHandler creates a handler from a function taking the args of a request and filling in its reply. Args and
+// replies are msgpack encoded. Args that are a trace.Carrier receive the span context the request was sent with.
+func NewHandler[Args any, Reply any](handle func(args Args, reply *Reply) error) Handler {
+	return Handler{
+		handle: func(spanContext trace.SpanContext, body []byte) (any, error) {
+			var args Args
+			if err := msgpack.Unmarshal(body, &args); err != nil {
+				return nil, err
+			}
+			if carrier, ok := any(&args).(trace.Carrier); ok && spanContext.IsValid() {
+				carrier.SetSpanContext(spanContext)
+			}
+
+			reply := new(Reply)
+			if err := handle(args, reply); err != nil {
+				return nil, err
+			}
+			return reply, nil
+		},
+	}
+}
+
+type handlerTable struct {
+	handlers map[command.Opcode]Handler
+	services []grpcService
+	mutex    sync.RWMutex
+}
+
+func newHandlerTable() *handlerTable {
+	return &handlerTable{
+		handlers: map[command.Opcode]Handler{},
+		services: nil,
+		mutex:    sync.RWMutex{},
+	}
+}
+
+func (self *handlerTable) get(opcode command.Opcode) (Handler, bool) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	handler, exists := self.handlers[opcode]
+	return handler, exists
+}
+
+// RegisterHandlers registers the handler of each opcode. Nothing is registered if any of the opcodes already has a
+// handler.
+func (self Server) RegisterHandlers(ctx context.Context, handlers map[command.Opcode]Handler) errors.Error[HandlersError] {
+	self.handlers.mutex.Lock()
+	defer self.handlers.mutex.Unlock()
+
+	for opcode := range handlers {
+		if _, exists := self.handlers.handlers[opcode]; exists {
+			log.Ctx(ctx).Error().Stringer("opcode", opcode).Msg("rpc handler already registered")
+			return errors.New(HandlersErrorDuplicateOpcode, "opcode %s is already registered", opcode)
+		}
+	}
+
+	for opcode, handler := range handlers {
+		self.handlers.handlers[opcode] = handler
 	}
 
 	return errors.Ok[HandlersError]()
@@ -72,9 +154,12 @@ func (self Server) Listen(ctx context.Context) (net.Listener, errors.Error[Liste
 		Str("serverPort", self.port).
 		Logger().WithContext(ctx)
 
-	if rpcErr := self.rpcServer.Register(newBuiltInHandlers(ctx).BuiltIn); rpcErr != nil {
-		log.Ctx(ctx).Err(rpcErr).Msg("failed to register rpc built in handlers")
-		return nil, errors.NewWithErr(ListenErrorRpcFailure, rpcErr)
+	handlerErr := self.RegisterHandlers(ctx, map[command.Opcode]Handler{
+		command.OpcodePing: NewHandler(newBuiltInHandlers(ctx).Ping),
+	})
+	if handlerErr.IsErr() {
+		log.Ctx(ctx).Err(handlerErr).Msg("failed to register rpc built in handlers")
+		return nil, errors.FromError(ListenErrorRpcFailure, handlerErr)
 	}
 
 	listenConfig := &net.ListenConfig{
@@ -92,6 +177,99 @@ func (self Server) Listen(ctx context.Context) (net.Listener, errors.Error[Liste
 	return netListener, errors.Ok[ListenError]()
 }
 
+// connections tracks the listener and the live connections of a server so that it can be shut down. It is shared by
+// every copy of the Server.
+type connections struct {
+	listener net.Listener
+	// grpcServer serves the connections when the server uses TransportGRPC.
+	grpcServer *grpc.Server
+	live       map[*net.TCPConn]struct{}
+	accepting  sync.WaitGroup
+	serving    sync.WaitGroup
+	mutex      sync.Mutex
+	closed     bool
+	done       chan struct{}
+}
+
+func newConnections() *connections {
+	return &connections{
+		live: map[*net.TCPConn]struct{}{},
+		done: make(chan struct{}),
+	}
+}
+
+// add tracks the connection. It reports false once the server is shutting down.
+func (self *connections) add(tcpConnection *net.TCPConn) bool {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.closed {
+		return false
+	}
+
+	self.live[tcpConnection] = struct{}{}
+	self.serving.Add(1)
+	return true
+}
+
+func (self *connections) remove(tcpConnection *net.TCPConn) {
+	self.mutex.Lock()
+	delete(self.live, tcpConnection)
+	self.mutex.Unlock()
+
+	self.serving.Done()
+}
+
+// Shutdown stops accepting connections and stops reading requests from live connections. Each connection is closed
+// once the calls it is serving have replied. If the context is done first, the remaining connections are closed
+// without waiting and the context error is returned.
+func (self Server) Shutdown(ctx context.Context) error {
+	self.connections.mutex.Lock()
+	if self.connections.closed {
+		self.connections.mutex.Unlock()
+		return nil
+	}
+	self.connections.closed = true
+	close(self.connections.done)
+
+	if grpcServer := self.connections.grpcServer; grpcServer != nil {
+		self.connections.mutex.Unlock()
+		return shutdownGRPC(ctx, grpcServer, &self.connections.accepting)
+	}
+
+	if self.connections.listener != nil {
+		_ = self.connections.listener.Close()
+	}
+	for tcpConnection := range self.connections.live {
+		// The server reads end of file and closes the connection after replying to the calls in flight.
+		_ = tcpConnection.CloseRead()
+	}
+	self.connections.mutex.Unlock()
+
+	self.connections.accepting.Wait()
+
+	served := make(chan struct{})
+	go func() {
+		self.connections.serving.Wait()
+		close(served)
+	}()
+
+	select {
+	case <-served:
+		return nil
+	case <-ctx.Done():
+		self.connections.mutex.Lock()
+		for tcpConnection := range self.connections.live {
+			_ = tcpConnection.Close()
+		}
+		self.connections.mutex.Unlock()
+
+		<-served
+		return ctx.Err()
+	}
+}
+
+// AcceptConnections serves connections from the listener until the context is done or the server is shut down.
 func (self Server) AcceptConnections(ctx context.Context, netListener net.Listener) {
 	ctx = log.Ctx(ctx).With().
 		Str("source", "server").
@@ -99,11 +277,30 @@ func (self Server) AcceptConnections(ctx context.Context, netListener net.Listen
 		Str("serverPort", self.port).
 		Logger().WithContext(ctx)
 
-	go func() {
-		<-ctx.Done()
+	self.connections.mutex.Lock()
+	if self.connections.closed {
+		self.connections.mutex.Unlock()
 		_ = netListener.Close()
+		return
+	}
+	self.connections.listener = netListener
+	self.connections.accepting.Add(1)
+	self.connections.mutex.Unlock()
+	defer self.connections.accepting.Done()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = netListener.Close()
+		case <-self.connections.done:
+		}
 	}()
 
+	if self.transport == TransportGRPC {
+		self.serveGRPC(ctx, netListener)
+		return
+	}
+
 	var connectionId uint64
 	var netConnection net.Conn
 	var err error
@@ -116,12 +313,11 @@ func (self Server) AcceptConnections(ctx context.Context, netListener net.Listen
 
 		netConnection, err = netListener.Accept()
 		if err != nil {
-			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			if errors.Is(err, net.ErrClosed) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 				break
 			}
 
-			// FIXME: This gets hit with "use of closed network connection"
-			// log.Ctx(ctx).Err(err).Msg("failed to accept connection")
+			log.Ctx(ctx).Err(err).Msg("failed to accept connection")
 			continue
 		}
 
@@ -151,19 +347,98 @@ func (self Server) AcceptConnections(ctx context.Context, netListener net.Listen
 			continue
 		}
 
-		go handleConnection(connectionCtx, asTcpConnection, self.rpcServer)
+		if !self.connections.add(asTcpConnection) {
+			_ = asTcpConnection.Close()
+			break
+		}
+
+		go self.handleConnection(connectionCtx, asTcpConnection)
+	}
+}
+
+func (self Server) handleConnection(ctx context.Context, tcpConnection *net.TCPConn) {
+	defer self.connections.remove(tcpConnection)
+
+	var calls sync.WaitGroup
+	var writeMutex sync.Mutex
+	slots := make(chan struct{}, self.maxConcurrentCalls)
+	reader := bufio.NewReader(tcpConnection)
+
+	// Requests run concurrently and reply as soon as they finish, in any order. Reading stops once the connection
+	// fails or the client closes it, and the connection is closed after replying to the calls in flight.
+	for {
+		header, body, buffer, err := readFrame(reader)
+		if err != nil {
+			break
+		}
+		if header.flags&frameFlagResponse != 0 {
+			bytespool.Put(buffer)
+			log.Ctx(ctx).Error().Msg("received a response on a server connection")
+			break
+		}
+
+		slots <- struct{}{}
+		calls.Add(1)
+		go func() {
+			defer calls.Done()
+			defer func() { <-slots }()
+
+			reply, err := self.runRequest(header, body)
+			bytespool.Put(buffer)
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			if err := self.writeResponse(tcpConnection, header, reply, err); err != nil {
+				// A partly written frame leaves the stream unreadable, so the calls of the client must fail. Closing
+				// also ends the read loop and fails the writes of the other calls in flight.
+				log.Ctx(ctx).Err(err).Stringer("opcode", header.opcode).Msg("failed to write response, closing connection")
+				_ = tcpConnection.Close()
+			}
+		}()
 	}
+
+	calls.Wait()
+	_ = tcpConnection.Close()
 }
 
-func handleConnection(_ context.Context, tcpConnection *net.TCPConn, rpcServer *rpc.Server) {
-	// log.Ctx(ctx).Debug().Msg("handling new connection")
+func (self Server) runRequest(header frameHeader, body []byte) (any, error) {
+	handler, exists := self.handlers.get(header.opcode)
+	if !exists {
+		return nil, fmt.Errorf("no handler for %s", header.opcode)
+	}
+	return handler.handle(header.trace, body)
+}
 
-	// go func(ctx context.Context, tcpConnection *net.TCPConn) {
-	// 	<-ctx.Done()
-	// 	_ = tcpConnection.Close()
-	// }(ctx, tcpConnection)
+// writeResponse writes the reply, or the error if the request failed.
+func (self Server) writeResponse(tcpConnection *net.TCPConn, request frameHeader, reply any, err error) error {
+	header := frameHeader{
+		trace:  trace.SpanContext{},
+		id:     request.id,
+		opcode: request.opcode,
+		flags:  frameFlagResponse,
+	}
+	var body any = reply
+	if err != nil {
+		header.flags |= frameFlagError
+		body = err.Error()
+	}
 
-	rpcServer.ServeCodec(NewServerCodecMsgpack(tcpConnection))
+	frame, encodeErr := appendFrame(bytespool.Get(), header, body)
+	defer func() {
+		bytespool.Put(frame)
+	}()
+	if encodeErr != nil {
+		// Tell the client the reply could not be encoded rather than leaving the call waiting.
+		header.flags |= frameFlagError
+		frame, encodeErr = appendFrame(frame[:0], header, encodeErr.Error())
+		if encodeErr != nil {
+			return encodeErr
+		}
+	}
 
-	// log.Ctx(ctx).Debug().Msg("closed server connection")
+	if err := tcpConnection.SetWriteDeadline(time.Now().Add(self.sendTimeout)); err != nil {
+		return err
+	}
+	_, err = tcpConnection.Write(frame)
+	return err
 }
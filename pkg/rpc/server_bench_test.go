@@ -31,6 +31,15 @@ import (
 // BenchmarkPing-8            	    8246	    144255 ns/op	    2416 B/op	      20 allocs/op
 // BenchmarkPing_parallel-8   	   35959	     32518 ns/op	    2424 B/op	      20 allocs/op
 
+// Protocol: framed msgpack with multiplexed requests. BenchmarkTest is still net/rpc with gob.
+// goos: linux
+// goarch: amd64
+// pkg: diskey/pkg/rpc
+// cpu: Intel(R) Xeon(R) Processor @ 2.10GHz
+// BenchmarkTest          	  156007	      7590 ns/op	     512 B/op	      16 allocs/op
+// BenchmarkPing          	  132937	      8873 ns/op	    1632 B/op	      22 allocs/op
+// BenchmarkPing_parallel 	  133797	     12401 ns/op	    1632 B/op	      22 allocs/op
+
 type Args struct {
 	A, B int
 }
@@ -2,7 +2,9 @@ package rpc_test
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
@@ -10,6 +12,7 @@ import (
 	"diskey/pkg/command"
 	"diskey/pkg/errors/errorstest"
 	"diskey/pkg/rpc"
+	"diskey/pkg/trace"
 )
 
 func Test_Server_Handler(t *testing.T) {
@@ -20,18 +23,20 @@ func Test_Server_Handler(t *testing.T) {
 	port := "7000"
 
 	testServer := rpc.NewServer("localhost", port)
-	handlerErr := testServer.RegisterHandler(ctx, TestExtension{
-		extensionHandler: func(_ context.Context, input string) (TestHandlerReply, error) {
-			if input == "test" {
+	handlerErr := testServer.RegisterHandlers(ctx, map[command.Opcode]rpc.Handler{
+		opcodeExtension: rpc.NewHandler(TestExtension{
+			extensionHandler: func(_ context.Context, input string) (TestHandlerReply, error) {
+				if input == "test" {
+					return TestHandlerReply{
+						Output: "success",
+					}, nil
+				}
+
 				return TestHandlerReply{
-					Output: "success",
+					Output: "failure",
 				}, nil
-			}
-
-			return TestHandlerReply{
-				Output: "failure",
-			}, nil
-		},
+			},
+		}.Extension),
 	})
 	errorstest.NoError(t, handlerErr)
 
@@ -77,6 +82,46 @@ func Test_Server_Handler(t *testing.T) {
 	}
 }
 
+func Test_Server_Handler_trace(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	port := "7200"
+
+	testServer := rpc.NewServer("localhost", port)
+	handlerErr := testServer.RegisterHandlers(ctx, map[command.Opcode]rpc.Handler{
+		opcodeTraced: rpc.NewHandler(TracedExtension{}.Traced),
+	})
+	errorstest.NoError(t, handlerErr)
+
+	listener, listenErr := testServer.Listen(ctx)
+	errorstest.NoError(t, listenErr)
+
+	go testServer.AcceptConnections(ctx, listener)
+
+	testClient := rpc.NewClient("localhost", port)
+	connectErr := testClient.Connect(ctx)
+	errorstest.NoError(t, connectErr)
+
+	// The span context of the request reaches the handler through the request header.
+	tracedCtx, span := trace.NewTracer(trace.NewInMemoryExporter()).Start(ctx, "request", trace.SpanKindClient)
+	request := newTracedRequest()
+	assert.NoError(t, testClient.Send(tracedCtx, request))
+	assert.Equal(t, span.SpanContext(), request.Reply.(*TracedHandlerReply).Trace)
+
+	// Requests outside of a span carry no span context.
+	request = newTracedRequest()
+	assert.NoError(t, testClient.Send(ctx, request))
+	assert.False(t, request.Reply.(*TracedHandlerReply).Trace.IsValid())
+}
+
+const (
+	opcodeExtension = command.OpcodeUserStart + iota
+	opcodeTraced
+	opcodeBlocking
+)
+
 type TestExtension struct {
 	extensionHandler func(ctx context.Context, input string) (TestHandlerReply, error)
 }
@@ -103,8 +148,230 @@ type extensionRequest = command.Request
 
 func newExtensionRequest(input string) extensionRequest {
 	return extensionRequest{
-		Name:  "TestExtension.Extension",
-		Args:  TestHandlerArgs{Input: input},
-		Reply: &TestHandlerReply{},
+		Opcode: opcodeExtension,
+		Args:   TestHandlerArgs{Input: input},
+		Reply:  &TestHandlerReply{},
+	}
+}
+
+type TracedExtension struct{}
+
+type TracedHandlerArgs struct {
+	trace.Propagated `msgpack:"-"`
+	Input            string
+}
+
+type TracedHandlerReply struct {
+	Trace trace.SpanContext
+}
+
+func (self TracedExtension) Traced(args TracedHandlerArgs, reply *TracedHandlerReply) error {
+	reply.Trace = args.SpanContext()
+	return nil
+}
+
+func newTracedRequest() command.Request {
+	return command.Request{
+		Opcode: opcodeTraced,
+		Args:   TracedHandlerArgs{Input: "test", Propagated: trace.Propagated{}},
+		Reply:  &TracedHandlerReply{},
+	}
+}
+
+func Test_Server_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	port := "7300"
+
+	testServer := rpc.NewServer("localhost", port)
+
+	listener, listenErr := testServer.Listen(ctx)
+	errorstest.NoError(t, listenErr)
+
+	accepting := make(chan struct{})
+	go func() {
+		testServer.AcceptConnections(ctx, listener)
+		close(accepting)
+	}()
+
+	testClient := rpc.NewClient("localhost", port)
+	connectErr := testClient.Connect(ctx)
+	errorstest.NoError(t, connectErr)
+	assert.NoError(t, testClient.Send(ctx, command.NewPingRequest()))
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, testServer.Shutdown(shutdownCtx))
+	assert.NoError(t, testServer.Shutdown(shutdownCtx))
+
+	select {
+	case <-accepting:
+	case <-shutdownCtx.Done():
+		t.Fatal("server still accepting connections")
+	}
+
+	// The live connection was closed and no new connections are accepted.
+	assert.Error(t, testClient.Send(ctx, command.NewPingRequest()))
+	assert.True(t, rpc.NewClient("localhost", port).Connect(ctx).IsErr())
+}
+
+func Test_Server_multiplexing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	port := "7400"
+
+	// Blocking requests wait until a later request on the same connection releases them.
+	release := make(chan struct{})
+	testServer := rpc.NewServer("localhost", port)
+	handlerErr := testServer.RegisterHandlers(ctx, map[command.Opcode]rpc.Handler{
+		opcodeBlocking: rpc.NewHandler(func(releases bool, reply *bool) error {
+			if releases {
+				close(release)
+			} else {
+				<-release
+			}
+			*reply = releases
+			return nil
+		}),
+	})
+	errorstest.NoError(t, handlerErr)
+
+	listener, listenErr := testServer.Listen(ctx)
+	errorstest.NoError(t, listenErr)
+
+	go testServer.AcceptConnections(ctx, listener)
+
+	testClient := rpc.NewClient("localhost", port)
+	connectErr := testClient.Connect(ctx)
+	errorstest.NoError(t, connectErr)
+
+	blocked := make(chan error)
+	go func() {
+		blocked <- testClient.Send(ctx, command.Request{Opcode: opcodeBlocking, Args: false, Reply: new(bool)})
+	}()
+
+	// The second request is answered first and releases the first.
+	reply := false
+	assert.NoError(t, testClient.Send(ctx, command.Request{Opcode: opcodeBlocking, Args: true, Reply: &reply}))
+	assert.True(t, reply)
+	assert.NoError(t, <-blocked)
+
+	// Unknown opcodes fail without breaking the connection.
+	err := testClient.Send(ctx, command.Request{Opcode: opcodeBlocking + 1, Args: true, Reply: new(bool)})
+	var serverErr rpc.ServerError
+	assert.ErrorAs(t, err, &serverErr)
+	assert.NoError(t, testClient.Send(ctx, command.NewPingRequest()))
+}
+
+func Test_Server_TransportGRPC(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	port := "7500"
+
+	testServer := rpc.NewServer("localhost", port)
+	testServer.SetTransport(rpc.TransportGRPC)
+	handlerErr := testServer.RegisterHandlers(ctx, map[command.Opcode]rpc.Handler{
+		opcodeTraced: rpc.NewHandler(TracedExtension{}.Traced),
+	})
+	errorstest.NoError(t, handlerErr)
+
+	listener, listenErr := testServer.Listen(ctx)
+	errorstest.NoError(t, listenErr)
+
+	go testServer.AcceptConnections(ctx, listener)
+
+	testClient := rpc.NewClient("localhost", port)
+	testClient.SetTransport(rpc.TransportGRPC)
+	connectErr := testClient.Connect(ctx)
+	errorstest.NoError(t, connectErr)
+	assert.True(t, testClient.Connected())
+
+	ping := command.NewPingRequest()
+	assert.NoError(t, testClient.Send(ctx, ping))
+	assert.Equal(t, 1, *ping.Reply.(*int))
+
+	// The span context travels in the gRPC request.
+	tracedCtx, span := trace.NewTracer(trace.NewInMemoryExporter()).Start(ctx, "request", trace.SpanKindClient)
+	request := newTracedRequest()
+	assert.NoError(t, testClient.Send(tracedCtx, request))
+	assert.Equal(t, span.SpanContext(), request.Reply.(*TracedHandlerReply).Trace)
+
+	err := testClient.Send(ctx, command.Request{Opcode: opcodeBlocking, Args: true, Reply: new(bool)})
+	assert.ErrorContains(t, err, "no handler")
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.NoError(t, testServer.Shutdown(shutdownCtx))
+	assert.Error(t, testClient.Send(ctx, command.NewPingRequest()))
+
+	// Connecting waits for the connection, so it fails once the server is gone.
+	lateClient := rpc.NewClient("localhost", port)
+	lateClient.SetTransport(rpc.TransportGRPC)
+	assert.True(t, lateClient.Connect(ctx).IsErr())
+}
+
+func Test_Server_max_concurrent_calls(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	port := "7700"
+
+	var started atomic.Int32
+	release := make(chan struct{})
+	testServer := rpc.NewServer("localhost", port)
+	testServer.SetMaxConcurrentCalls(2)
+	handlerErr := testServer.RegisterHandlers(ctx, map[command.Opcode]rpc.Handler{
+		opcodeBlocking: rpc.NewHandler(func(_ bool, reply *bool) error {
+			started.Add(1)
+			<-release
+			*reply = true
+			return nil
+		}),
+	})
+	errorstest.NoError(t, handlerErr)
+
+	listener, listenErr := testServer.Listen(ctx)
+	errorstest.NoError(t, listenErr)
+
+	go testServer.AcceptConnections(ctx, listener)
+
+	testClient := rpc.NewClient("localhost", port)
+	connectErr := testClient.Connect(ctx)
+	errorstest.NoError(t, connectErr)
+
+	replies := make(chan error, 3)
+	for range 3 {
+		go func() {
+			replies <- testClient.Send(ctx, command.Request{Opcode: opcodeBlocking, Args: false, Reply: new(bool)})
+		}()
 	}
+
+	// The third request is not read until one of the first two replies.
+	assert.Eventually(t, func() bool {
+		return started.Load() == 2
+	}, time.Second, time.Millisecond)
+	assert.Never(t, func() bool {
+		return started.Load() > 2
+	}, 100*time.Millisecond, time.Millisecond)
+
+	close(release)
+	for range 3 {
+		assert.NoError(t, <-replies)
+	}
+	assert.Equal(t, int32(3), started.Load())
+}
+
+func Test_Client_Send_not_connected(t *testing.T) {
+	t.Parallel()
+
+	testClient := rpc.NewClient("localhost", "7600")
+
+	assert.ErrorIs(t, testClient.Send(context.Background(), command.NewPingRequest()), rpc.ErrNotConnected)
 }
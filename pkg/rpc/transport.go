@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"fmt"
+)
+
+// Transport is how a server and its clients talk to each other. A client must use the transport of the server it
+// connects to.
+type Transport uint
+
+const (
+	// TransportFramed is the framed msgpack protocol of this package. It is the default.
+	TransportFramed = Transport(iota)
+	// TransportGRPC sends requests as gRPC calls over HTTP/2. Requests carry the same msgpack encoded args and replies
+	// as the framed transport, and servers also serve the command.Cache service to callers that are not nodes.
+	TransportGRPC
+)
+
+func (self Transport) String() string {
+	switch self {
+	case TransportFramed:
+		return "framed"
+	case TransportGRPC:
+		return "grpc"
+	default:
+		return fmt.Sprintf("Transport(%d)", uint(self))
+	}
+}
+
+// ParseTransport returns the transport named by Transport.String.
+func ParseTransport(name string) (Transport, error) {
+	switch name {
+	case "framed":
+		return TransportFramed, nil
+	case "grpc":
+		return TransportGRPC, nil
+	default:
+		return 0, fmt.Errorf("unknown transport: %q", name)
+	}
+}
@@ -0,0 +1,47 @@
+package trace
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// InMemoryExporter keeps every exported span in memory. Intended for tests.
+type InMemoryExporter struct {
+	spans []SpanData
+	mutex sync.Mutex
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{
+		spans: []SpanData{},
+		mutex: sync.Mutex{},
+	}
+}
+
+func (self *InMemoryExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.spans = append(self.spans, spans...)
+	return nil
+}
+
+func (self *InMemoryExporter) Shutdown(_ context.Context) error {
+	return nil
+}
+
+// Spans returns a copy of the spans exported so far, in the order they ended.
+func (self *InMemoryExporter) Spans() []SpanData {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return slices.Clone(self.spans)
+}
+
+func (self *InMemoryExporter) Reset() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.spans = self.spans[:0]
+}
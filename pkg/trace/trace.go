@@ -0,0 +1,328 @@
+// Package trace propagates trace and span ids across the nodes of a cluster and records spans for the work done on
+// each node.
+//
+// Span ids follow the W3C trace context format, so spans can be handed to an OpenTelemetry collector by an Exporter
+// that converts SpanData into the collector's span type.
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"diskey/pkg/batcher"
+)
+
+// maxExportBatchSize bounds how many spans are handed to the exporter at once. It also bounds how many ended spans
+// wait to be exported, spans ending while that many are waiting are dropped.
+const maxExportBatchSize = 512
+
+type TraceID [16]byte
+
+func (self TraceID) IsValid() bool {
+	return self != TraceID{}
+}
+
+func (self TraceID) String() string {
+	return hex.EncodeToString(self[:])
+}
+
+type SpanID [8]byte
+
+func (self SpanID) IsValid() bool {
+	return self != SpanID{}
+}
+
+func (self SpanID) String() string {
+	return hex.EncodeToString(self[:])
+}
+
+func newTraceID() TraceID {
+	var traceID TraceID
+	binary.BigEndian.PutUint64(traceID[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(traceID[8:], rand.Uint64())
+	return traceID
+}
+
+func newSpanID() SpanID {
+	var spanID SpanID
+	binary.BigEndian.PutUint64(spanID[:], rand.Uint64())
+	return spanID
+}
+
+// SpanContext identifies a span. It is what travels between nodes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (self SpanContext) IsValid() bool {
+	return self.TraceID.IsValid() && self.SpanID.IsValid()
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a context whose spans are children of the span context.
+func ContextWithSpanContext(ctx context.Context, spanContext SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, spanContext)
+}
+
+// SpanContextFromContext returns the span context of the current span, or an invalid span context if there is none.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	spanContext, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return spanContext
+}
+
+// Carrier receives the span context sent along with a request.
+type Carrier interface {
+	SetSpanContext(spanContext SpanContext)
+}
+
+// Propagated is embedded in rpc args to receive the span context sent in the request header. It is not part of the
+// encoded args.
+type Propagated struct {
+	spanContext SpanContext
+}
+
+func (self *Propagated) SetSpanContext(spanContext SpanContext) {
+	self.spanContext = spanContext
+}
+
+func (self Propagated) SpanContext() SpanContext {
+	return self.spanContext
+}
+
+type SpanKind uint
+
+const (
+	SpanKindInternal = SpanKind(iota)
+	// SpanKindClient is a request sent to another node.
+	SpanKindClient
+	// SpanKindServer is a request received from another node.
+	SpanKindServer
+)
+
+func (self SpanKind) String() string {
+	switch self {
+	case SpanKindInternal:
+		return "Internal"
+	case SpanKindClient:
+		return "Client"
+	case SpanKindServer:
+		return "Server"
+	default:
+		return "SpanKind"
+	}
+}
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]string
+	Name         string
+	Error        string
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Kind         SpanKind
+}
+
+// Exporter receives finished spans. It mirrors the OpenTelemetry SpanExporter so an adapter only needs to convert
+// SpanData.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts spans and hands them to its exporter in batches once they end.
+type Tracer struct {
+	exporter Exporter
+	// spans are the ended spans waiting to be exported.
+	spans chan<- SpanData
+	// exporting counts the spans sent to spans and not exported yet.
+	exporting sync.WaitGroup
+	// mutex guards closed. Ending spans hold the read lock, so spans is not closed while one is sent.
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewTracer creates a tracer that exports to the exporter. A nil exporter still propagates the ids of incoming traces
+// and adds them to logs, but records nothing.
+func NewTracer(exporter Exporter) *Tracer {
+	tracer := &Tracer{
+		exporter:  exporter,
+		spans:     nil,
+		exporting: sync.WaitGroup{},
+		mutex:     sync.RWMutex{},
+		closed:    false,
+	}
+
+	if exporter != nil {
+		tracer.spans = batcher.Run(maxExportBatchSize, tracer.export)
+	}
+
+	return tracer
+}
+
+func (self *Tracer) export(spans []SpanData) {
+	defer self.exporting.Add(-len(spans))
+
+	if err := self.exporter.ExportSpans(context.Background(), spans); err != nil {
+		log.Warn().Err(err).Int("spans", len(spans)).Msg("failed to export spans")
+	}
+}
+
+// Start starts a span that is a child of the span in the context, or the root of a new trace if there is none. The
+// returned context carries the new span and a logger with its traceId and spanId fields.
+//
+// Without an exporter and without a span in the context there is nothing to record or continue, so the context is
+// returned as is along with a nil span, whose methods do nothing.
+func (self *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	if self.exporter == nil && !parent.IsValid() {
+		return ctx, nil
+	}
+
+	spanContext := SpanContext{
+		TraceID: parent.TraceID,
+		SpanID:  newSpanID(),
+	}
+	if !parent.IsValid() {
+		spanContext.TraceID = newTraceID()
+	}
+
+	span := &Span{
+		tracer: self,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  spanContext,
+			ParentSpanID: parent.SpanID,
+			StartTime:    time.Now(),
+		},
+		mutex: sync.Mutex{},
+	}
+
+	ctx = ContextWithSpanContext(ctx, spanContext)
+	ctx = log.Ctx(ctx).With().
+		Stringer("traceId", spanContext.TraceID).
+		Stringer("spanId", spanContext.SpanID).
+		Logger().WithContext(ctx)
+
+	return ctx, span
+}
+
+// Shutdown exports the spans that ended so far and shuts down the exporter. Spans ending afterwards are dropped.
+func (self *Tracer) Shutdown(ctx context.Context) error {
+	if self.exporter == nil {
+		return nil
+	}
+
+	self.mutex.Lock()
+	if !self.closed {
+		self.closed = true
+		close(self.spans)
+	}
+	self.mutex.Unlock()
+
+	exported := make(chan struct{})
+	go func() {
+		self.exporting.Wait()
+		close(exported)
+	}()
+
+	select {
+	case <-exported:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return self.exporter.Shutdown(ctx)
+}
+
+// enqueue hands an ended span to the exporter without waiting for it to be exported.
+func (self *Tracer) enqueue(data SpanData) {
+	self.mutex.RLock()
+	defer self.mutex.RUnlock()
+
+	if self.closed {
+		return
+	}
+
+	self.exporting.Add(1)
+	select {
+	case self.spans <- data:
+	default:
+		self.exporting.Done()
+		log.Warn().Str("span", data.Name).Msg("span export queue full, dropping span")
+	}
+}
+
+// Span is a span started by a Tracer. A nil span is valid and records nothing.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	mutex  sync.Mutex
+	ended  bool
+}
+
+func (self *Span) SpanContext() SpanContext {
+	if self == nil {
+		return SpanContext{}
+	}
+	return self.data.SpanContext
+}
+
+func (self *Span) SetAttribute(key string, value string) {
+	if self == nil {
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.data.Attributes == nil {
+		self.data.Attributes = map[string]string{}
+	}
+	self.data.Attributes[key] = value
+}
+
+// RecordError marks the span as failed. A nil error is ignored.
+func (self *Span) RecordError(err error) {
+	if self == nil || err == nil {
+		return
+	}
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.data.Error = err.Error()
+}
+
+// End finishes the span and hands it to the exporter. Calls after the first are ignored.
+func (self *Span) End() {
+	if self == nil {
+		return
+	}
+
+	self.mutex.Lock()
+	if self.ended {
+		self.mutex.Unlock()
+		return
+	}
+	self.ended = true
+	self.data.EndTime = time.Now()
+	data := self.data
+	self.mutex.Unlock()
+
+	if self.tracer.exporter == nil {
+		return
+	}
+
+	self.tracer.enqueue(data)
+}
@@ -0,0 +1,133 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"diskey/pkg/trace"
+)
+
+func TestTracer_Start(t *testing.T) {
+	t.Parallel()
+
+	exporter := trace.NewInMemoryExporter()
+	tracer := trace.NewTracer(exporter)
+
+	ctx, parent := tracer.Start(context.Background(), "parent", trace.SpanKindClient)
+	assert.True(t, parent.SpanContext().IsValid())
+	assert.Equal(t, parent.SpanContext(), trace.SpanContextFromContext(ctx))
+
+	_, child := tracer.Start(ctx, "child", trace.SpanKindServer)
+	child.SetAttribute("key", "value")
+	child.RecordError(errors.New("failed"))
+	child.End()
+	child.End()
+	parent.End()
+
+	// Shutting down exports the spans that ended.
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+	spans := exporter.Spans()
+	assert.Len(t, spans, 2)
+
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, trace.SpanKindServer, spans[0].Kind)
+	assert.Equal(t, parent.SpanContext().TraceID, spans[0].SpanContext.TraceID)
+	assert.Equal(t, parent.SpanContext().SpanID, spans[0].ParentSpanID)
+	assert.NotEqual(t, parent.SpanContext().SpanID, spans[0].SpanContext.SpanID)
+	assert.Equal(t, map[string]string{"key": "value"}, spans[0].Attributes)
+	assert.Equal(t, "failed", spans[0].Error)
+	assert.False(t, spans[0].EndTime.Before(spans[0].StartTime))
+
+	assert.Equal(t, "parent", spans[1].Name)
+	assert.False(t, spans[1].ParentSpanID.IsValid())
+
+	exporter.Reset()
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestTracer_Start_remoteParent(t *testing.T) {
+	t.Parallel()
+
+	exporter := trace.NewInMemoryExporter()
+	tracer := trace.NewTracer(exporter)
+
+	// A span context received from another node continues that node's trace.
+	remote := trace.SpanContext{
+		TraceID: trace.TraceID{1, 2, 3},
+		SpanID:  trace.SpanID{4, 5, 6},
+	}
+	_, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), remote), "handler", trace.SpanKindServer)
+	span.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	spans := exporter.Spans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, remote.TraceID, spans[0].SpanContext.TraceID)
+	assert.Equal(t, remote.SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "01020300000000000000000000000000", spans[0].SpanContext.TraceID.String())
+}
+
+func TestTracer_noExporter(t *testing.T) {
+	t.Parallel()
+
+	tracer := trace.NewTracer(nil)
+
+	// Without an exporter and without an incoming trace, there is nothing to record or continue.
+	ctx, span := tracer.Start(context.Background(), "span", trace.SpanKindInternal)
+	span.SetAttribute("key", "value")
+	span.End()
+	assert.Nil(t, span)
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+
+	// An incoming trace is still continued, so that its ids reach other nodes and logs.
+	remote := trace.SpanContext{
+		TraceID: trace.TraceID{1, 2, 3},
+		SpanID:  trace.SpanID{4, 5, 6},
+	}
+	ctx, span = tracer.Start(trace.ContextWithSpanContext(context.Background(), remote), "span", trace.SpanKindServer)
+	span.End()
+	assert.Equal(t, remote.TraceID, trace.SpanContextFromContext(ctx).TraceID)
+	assert.NotEqual(t, remote.SpanID, trace.SpanContextFromContext(ctx).SpanID)
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestTracer_batches(t *testing.T) {
+	t.Parallel()
+
+	exporter := &blockingExporter{
+		InMemoryExporter: trace.NewInMemoryExporter(),
+		release:          make(chan struct{}),
+		batches:          make(chan int, 10),
+	}
+	tracer := trace.NewTracer(exporter)
+
+	// Ending a span does not wait for the export, and spans ending during an export are exported together.
+	_, first := tracer.Start(context.Background(), "first", trace.SpanKindInternal)
+	first.End()
+	assert.Equal(t, 1, <-exporter.batches)
+
+	for range 3 {
+		_, span := tracer.Start(context.Background(), "next", trace.SpanKindInternal)
+		span.End()
+	}
+	close(exporter.release)
+
+	assert.Equal(t, 3, <-exporter.batches)
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+	assert.Len(t, exporter.Spans(), 4)
+}
+
+type blockingExporter struct {
+	*trace.InMemoryExporter
+	release chan struct{}
+	batches chan int
+}
+
+func (self *blockingExporter) ExportSpans(ctx context.Context, spans []trace.SpanData) error {
+	self.batches <- len(spans)
+	<-self.release
+	return self.InMemoryExporter.ExportSpans(ctx, spans)
+}